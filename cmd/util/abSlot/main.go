@@ -0,0 +1,53 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// Command abSlot prints the state of the A/B root slots, as json. For use in
+// the booted OS, it can also request that the newest image in the recovery
+// volume be written to the inactive slot on next boot. To confirm the slot
+// after the update, use imgHistory -health.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/ab"
+)
+
+func main() {
+	var rec string
+	var request bool
+	flag.StringVar(&rec, "rec", "/mnt/"+strs.RecVolName(), "mount point of recovery volume")
+	flag.BoolVar(&request, "request-update", false, "update inactive slot on next boot")
+	flag.Parse()
+
+	log.AddConsoleLog(0)
+	log.FlushMemLog()
+
+	st, err := ab.Load(rec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading slot state: %s\n", err)
+		os.Exit(1)
+	}
+	if request {
+		st.UpdateRequested = true
+		if err = st.Write(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "writing slot state: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshalling state: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -6,18 +6,22 @@
 //
 
 // Appliance-schema generates a json schema for github.com/purecloudlabs/gprovision/pkg/appliance or
-// github.com/purecloudlabs/gprovision/pkg/mfg/qa.
+// github.com/purecloudlabs/gprovision/pkg/mfg/qa. With -validate, it instead validates a json file against
+// the schema, and checks things the schema can't, such as partition layouts.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/purecloudlabs/gprovision/pkg/appliance"
 
 	"github.com/alecthomas/jsonschema"
+	validator "github.com/santhosh-tekuri/jsonschema"
 )
 
 const Warn = `WARNING:
@@ -29,7 +33,23 @@
 
 func main() {
 	dofacts := flag.Bool("facts", false, "produce schema for platform_facts.json rather than appliance.json")
+	validate := flag.String("validate", "", "validate given json file rather than producing a schema")
+	schemaFile := flag.String("schema", "", "with -validate, schema to use (default: pkg/appliance/schemas/{appliance,platform_facts}.json)")
 	flag.Parse()
+	if *validate != "" {
+		if *schemaFile == "" {
+			*schemaFile = "pkg/appliance/schemas/appliance.json"
+			if *dofacts {
+				*schemaFile = "pkg/appliance/schemas/platform_facts.json"
+			}
+		}
+		if err := validateFile(*validate, *schemaFile, *dofacts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *validate, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "%s: ok\n", *validate)
+		return
+	}
 	fmt.Fprint(os.Stderr, Warn)
 	var schem *jsonschema.Schema
 	if *dofacts {
@@ -45,3 +65,35 @@ type root struct{
 	}
 	fmt.Printf("%s\n", data)
 }
+
+//validate against schema, then check each variant
+func validateFile(file, schemaFile string, facts bool) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	schema, err := validator.Compile(schemaFile)
+	if err != nil {
+		return fmt.Errorf("schema: %s", err)
+	}
+	if err = schema.Validate(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if facts {
+		var pf appliance.PlatFacts
+		if err = json.Unmarshal(data, &pf); err != nil {
+			return err
+		}
+		return pf.Validate()
+	}
+	var root struct{ Variants []appliance.Variant_ }
+	if err = json.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	for _, v := range root.Variants {
+		if err = v.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", v.DevCodeName, err)
+		}
+	}
+	return nil
+}
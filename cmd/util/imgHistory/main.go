@@ -0,0 +1,121 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// Command imgHistory prints the image history from the recovery volume, as a
+// table or as json. For use in the booted OS, it can also record the result of
+// the first-boot health check, which also confirms or rejects a newly updated
+// A/B slot.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/ab"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+func main() {
+	var rec, health, image, reason, detail, bootloader string
+	var asJson, events bool
+	var severity uint
+	flag.StringVar(&rec, "rec", "/mnt/"+strs.RecVolName(), "mount point of recovery volume")
+	flag.BoolVar(&asJson, "json", false, "print history as json")
+	flag.BoolVar(&events, "events", false, "include events in table")
+	flag.StringVar(&health, "health", "", "record result of health check: 'good' or 'bad'")
+	flag.StringVar(&image, "image", "", "with -health: image name (default: from disktag of /)")
+	flag.UintVar(&severity, "severity", 1, "with -health bad: failure severity")
+	flag.StringVar(&reason, "reason", "", "with -health: reason code")
+	flag.StringVar(&detail, "detail", "", "with -health: details")
+	flag.StringVar(&bootloader, "bootloader", "", "with -health: bootloader in use")
+	flag.Parse()
+
+	log.AddConsoleLog(0)
+	log.FlushMemLog()
+
+	switch health {
+	case "":
+		history.SetRoot(rec)
+		history.Load()
+	case "good", "bad":
+		if image == "" {
+			image = strings.TrimSuffix(dt.Read("/"), ".disktag")
+		}
+		history.ReportHealth(rec, history.Health{
+			Image:      image,
+			Healthy:    health == "good",
+			Severity:   severity,
+			Reason:     history.Reason(reason),
+			Detail:     detail,
+			Bootloader: bootloader,
+		})
+		confirmSlot(rec, image, health == "good")
+	default:
+		fmt.Fprintf(os.Stderr, "-health: want 'good' or 'bad', got %q\n", health)
+		os.Exit(1)
+	}
+
+	if asJson {
+		data, err := json.MarshalIndent(history.Results(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshalling history: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	printTable(history.Results(), events)
+}
+
+//on units with A/B slots, the health check decides whether a newly updated
+//slot is kept
+func confirmSlot(rec, image string, healthy bool) {
+	st, err := ab.Load(rec)
+	if err != nil {
+		log.Logf("reading slot state: %s", err)
+		return
+	}
+	if !st.Confirm(image, healthy) {
+		return
+	}
+	if err = st.Write(rec); err != nil {
+		log.Logf("writing slot state: %s", err)
+	}
+}
+
+func printTable(results history.ResultList, events bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tIMAGING FAIL/TRY\tBOOT FAIL/TRY\tSCORE\tOK\tKNOWN GOOD\tLAST EVENT")
+	for _, r := range results {
+		known := "-"
+		if r.KnownGood != nil {
+			known = r.KnownGood.Format("2006-01-02 15:04")
+		}
+		last := "-"
+		if len(r.Events) > 0 {
+			last = r.Events[len(r.Events)-1].String()
+		} else if len(r.Notes) > 0 {
+			last = r.Notes[len(r.Notes)-1]
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%d/%d\t%.2f\t%t\t%s\t%s\n", r.Image,
+			r.ImagingFailures, r.ImagingAttempts, r.BootFailures, r.BootAttempts,
+			r.Score(), history.Check(r.Image), known, last)
+		if events {
+			for _, ev := range r.Events {
+				fmt.Fprintf(w, "\t\t\t\t\t\t%s\n", ev)
+			}
+		}
+	}
+	w.Flush()
+}
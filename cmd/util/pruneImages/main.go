@@ -0,0 +1,53 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// Command pruneImages deletes images which are no longer needed from the
+// recovery volume, as is done during factory restore. For use in the booted
+// OS. See archive.Prune for the images which are kept.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	fp "path/filepath"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+func main() {
+	var rec string
+	var keep int
+	var dryRun bool
+	flag.StringVar(&rec, "rec", "/mnt/"+strs.RecVolName(), "mount point of recovery volume")
+	flag.IntVar(&keep, "keep", archive.DefaultKeepNewest, "number of newest images to keep")
+	flag.BoolVar(&dryRun, "n", false, "dry run - list images that would be removed")
+	flag.Parse()
+
+	log.AddConsoleLog(0)
+	log.FlushMemLog()
+
+	//history uses platform-specific names
+	if platform := appliance.Read(); platform != nil {
+		dt.SetPlatform(platform.DeviceCodeName())
+	}
+	history.SetRoot(rec)
+	history.Load()
+	removed, err := archive.Prune(fp.Join(rec, "Image"), keep, dryRun)
+	for _, r := range removed {
+		fmt.Println(r)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pruning images: %s\n", err)
+		os.Exit(1)
+	}
+}
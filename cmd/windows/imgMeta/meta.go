@@ -58,6 +58,12 @@ func main() {
 		mdata, err = meta.Read(flag.Arg(0))
 		if err == nil {
 			fmt.Print(mdata)
+			if *v {
+				//too long for the summary
+				for _, c := range mdata.Contents {
+					fmt.Printf("  %s  %s\n", c.Sha256, c.Path)
+				}
+			}
 		}
 	}
 	if err == io.ErrUnexpectedEOF {
@@ -81,6 +81,63 @@
 // SwRaidlevel impacts whether we try to create a raid array and what type of
 // array is created.
 //
+// PartitionLayout, if present, lists the partitions factory restore creates on
+// each data disk and manufacturing creates on the recovery device, in order.
+// Partitions have a size in MB or a percentage of the disk, or use remaining
+// space if last. A partition's role (root, boot, esp, recovery) determines how
+// it is used; partitions without a role are formatted and added to fstab if
+// they have a fs type. Firmware limits a partition to uefi or legacy units.
+//
+//	"PartitionLayout": {
+//	  "Data": [
+//	    {"Label": "g4d", "Type": "linux", "SizeMB": 200, "Role": "boot", "Firmware": "legacy", "FsType": "ext3"},
+//	    {"Label": "root", "Type": "linux", "Percent": 80, "Role": "root", "FsType": "ext4", "MountPoint": "/"},
+//	    {"Label": "data", "Type": "linux", "FsType": "ext4", "MountPoint": "/data", "MountOpts": "auto,noatime"}
+//	  ]
+//	}
+//
+// Supported fs types are ext2, ext3, ext4, vfat, xfs, and btrfs. Xfs and btrfs
+// are only allowed for root and role-less partitions. A btrfs root is created
+// with a subvolume named @, which is the default subvolume and is mounted at /.
+// MkfsOpts replaces the default mkfs options for a fs type:
+//
+//	"MkfsOpts": {"xfs": ["-m", "reflink=1"], "ext4": ["-m", "1"]}
+//
+// Encryption, if present, causes the primary volume to be created inside a LUKS2
+// container, with a key from the Stasher. With TPM2 set, a second key sealed
+// by the TPM (bound to TPM2PCRs) is enrolled, so init can unlock the volume
+// without the Stasher. Data erase destroys the container's keyslots.
+//
+//	"Encryption": {"TPM2": true, "TPM2PCRs": "7"}
+//
+// A partition with role root_b is a second root slot. With it, an image update
+// is written to whichever of root and root_b is not in use, leaving the other
+// slot and role-less partitions untouched; init boots the new slot and falls
+// back to the old one if the booted OS doesn't confirm the new slot (via
+// `imgHistory -health good`) within a few boots. A/B slots require a single
+// data disk without raid or Encryption. See package recovery/ab.
+//
+// On uefi units, Bootloader selects how the kernel is booted: "efistub" (the
+// default) creates a firmware boot entry for each of normal boot, factory
+// restore, and data erase, while "systemd-boot" installs systemd-boot on the
+// ESP with a loader entry and Unified Kernel Image for each. The latter needs
+// ukify and systemd-boot in the recovery environment.
+//
+//	"Bootloader": "systemd-boot"
+//
+// ErasePolicy configures data erase: the drive commands to try, in order
+// (nvme-sanitize, nvme-format, ata-secure-erase), the overwrite patterns and
+// number of passes used when none succeed, and how much of each disk is read
+// back afterwards (canary, full, or sample with VerifyPercent). A file named
+// erase_policy.json on the recovery volume, holding an ErasePolicy, overrides
+// the variant's. Patterns are zeros, ones, random, a hex byte such as 0x55, or
+// dod (zeros, ones, random).
+//
+//	"ErasePolicy": {"Methods": ["nvme-sanitize", "ata-secure-erase"], "Patterns": ["dod"], "Verify": "sample", "VerifyPercent": 10}
+//
+// Layouts are checked when loaded; `appliance-schema -validate` checks a file
+// against the schema and performs the same checks. See DefaultLayout.
+//
 // Appliance json compared to manufData
 //
 // ManufData, used by the mfg app, has some similarities. Both impose
@@ -0,0 +1,109 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package appliance
+
+import (
+	"fmt"
+	"regexp"
+)
+
+//Erase methods; see ErasePolicy.Methods
+const (
+	EraseSanitize  = "nvme-sanitize"    //NVMe sanitize: crypto, block, or overwrite action
+	EraseFormat    = "nvme-format"      //NVMe format with secure erase
+	EraseATA       = "ata-secure-erase" //ATA (enhanced) secure erase
+	EraseOverwrite = "overwrite"        //pattern overwrite; always the last resort
+)
+
+//Overwrite patterns; see ErasePolicy.Patterns. A pattern can also be a single
+//byte in hex, such as "0x55".
+const (
+	PatternZeros  = "zeros"
+	PatternOnes   = "ones"
+	PatternRandom = "random"
+	PatternDoD    = "dod" //shorthand for zeros, ones, random
+)
+
+//Verify modes; see ErasePolicy.Verify
+const (
+	VerifyCanary = "canary" //check canary locations only
+	VerifyFull   = "full"   //also read back the entire disk
+	VerifySample = "sample" //also read back VerifyPercent of the disk
+)
+
+var patternByteRe = regexp.MustCompile(`^0x[0-9a-fA-F]{2}$`)
+
+//ErasePolicy configures data erase. Empty fields select defaults. A policy
+//on the recovery volume takes precedence over the variant's.
+type ErasePolicy struct {
+	//erase methods to try, in order of preference. Drive commands that are
+	//not listed are not used; overwrite is used if none succeed. An empty
+	//list means overwrite only.
+	Methods []string `json:",omitempty"`
+	//overwrite patterns, one per pass. If there are more passes than
+	//patterns, the last pattern is repeated.
+	Patterns []string `json:",omitempty"`
+	//number of overwrite passes; default is the number of patterns
+	Passes int `json:",omitempty"`
+	//read-back after erase: canary (default), full, or sample
+	Verify string `json:",omitempty"`
+	//percentage of each disk read back with sample
+	VerifyPercent int `json:",omitempty"`
+}
+
+//ErasePolicy returns the variant's erase policy, or nil if it has none.
+func (v *Variant) ErasePolicy() *ErasePolicy {
+	return v.i.ErasePolicy
+}
+
+//Validate checks the policy for unknown or conflicting values.
+func (p *ErasePolicy) Validate() error {
+	seen := make(map[string]bool)
+	for i, m := range p.Methods {
+		switch m {
+		case EraseSanitize, EraseFormat, EraseATA, EraseOverwrite:
+		default:
+			return fmt.Errorf("Methods: unknown method %q", m)
+		}
+		if seen[m] {
+			return fmt.Errorf("Methods: %s listed twice", m)
+		}
+		seen[m] = true
+		if m == EraseOverwrite && i != len(p.Methods)-1 {
+			return fmt.Errorf("Methods: methods after %s would never be used", m)
+		}
+	}
+	if p.Patterns != nil && len(p.Patterns) == 0 {
+		return fmt.Errorf("Patterns: must not be empty; omit for defaults")
+	}
+	for _, pat := range p.Patterns {
+		switch pat {
+		case PatternZeros, PatternOnes, PatternRandom, PatternDoD:
+		default:
+			if !patternByteRe.MatchString(pat) {
+				return fmt.Errorf("Patterns: unknown pattern %q", pat)
+			}
+		}
+	}
+	if p.Passes < 0 {
+		return fmt.Errorf("Passes: must not be negative")
+	}
+	switch p.Verify {
+	case "", VerifyCanary, VerifyFull:
+		if p.VerifyPercent != 0 {
+			return fmt.Errorf("VerifyPercent: only valid with Verify %q", VerifySample)
+		}
+	case VerifySample:
+		if p.VerifyPercent < 1 || p.VerifyPercent > 100 {
+			return fmt.Errorf("VerifyPercent: want 1-100, got %d", p.VerifyPercent)
+		}
+	default:
+		return fmt.Errorf("Verify: unknown mode %q", p.Verify)
+	}
+	return nil
+}
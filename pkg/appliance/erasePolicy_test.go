@@ -0,0 +1,64 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package appliance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema"
+)
+
+func TestErasePolicy(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []struct {
+		name      string
+		pol       string
+		schemaErr bool //also rejected by the schema
+		err       string
+	}{
+		{name: "valid", pol: `{"Methods":["nvme-sanitize","ata-secure-erase","overwrite"],"Patterns":["dod","0x55"],"Passes":5,"Verify":"sample","VerifyPercent":10}`},
+		{name: "empty", pol: `{}`},
+		{name: "badMethod", pol: `{"Methods":["shred"]}`, schemaErr: true, err: "unknown method"},
+		{name: "overwriteFirst", pol: `{"Methods":["overwrite","nvme-format"]}`, err: "never be used"},
+		{name: "twice", pol: `{"Methods":["nvme-format","nvme-format"]}`, err: "listed twice"},
+		{name: "badPattern", pol: `{"Patterns":["0x5"]}`, schemaErr: true, err: "unknown pattern"},
+		{name: "noPatterns", pol: `{"Patterns":[],"Passes":2}`, schemaErr: true, err: "must not be empty"},
+		{name: "noPercent", pol: `{"Verify":"sample"}`, err: "want 1-100"},
+		{name: "percentFull", pol: `{"Verify":"full","VerifyPercent":50}`, err: "only valid with"},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`, `"Prototype":true,"ErasePolicy":`+td.pol+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); (err != nil) != td.schemaErr {
+				t.Errorf("schema: want error %t, got %v", td.schemaErr, err)
+			}
+			err := loadJson([]byte(v))
+			if td.err != "" {
+				if err == nil || !strings.Contains(err.Error(), td.err) {
+					t.Fatalf("want error containing %q, got %v", td.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Get("QEMU").ErasePolicy() == nil {
+				t.Error("erase policy not set")
+			}
+		})
+	}
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+	if Get("QEMU").ErasePolicy() != nil {
+		t.Error("want no erase policy by default")
+	}
+}
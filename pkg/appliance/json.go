@@ -11,12 +11,15 @@
 	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/net/xfer"
 )
 
+//LoadJson loads variant descriptions from url. Retrieval uses the shared tls
+//config, so url must be https when tls is enabled.
 func LoadJson(url string) {
 	log.Logf("loading appliance json from %s", url)
 	data, err := xfer.GetFile(url)
@@ -32,12 +35,66 @@ func LoadJson(url string) {
 func loadJson(data []byte) (err error) {
 	var loadStruct struct{ Variants []Variant_ } //necessary because on output, we wrap the Variant array
 	err = json.Unmarshal(data, &loadStruct)
-	if err == nil {
-		variants = loadStruct.Variants
+	if err != nil {
+		return
+	}
+	for _, v := range loadStruct.Variants {
+		if err = v.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", v.DevCodeName, err)
+		}
 	}
+	variants = loadStruct.Variants
 	return
 }
 
+//tpm2 pcr list, as accepted by systemd-cryptenroll
+var pcrRe = regexp.MustCompile(`^[0-9]+(\+[0-9]+)*$`)
+
+//Validate checks fields of the variant which can't be checked with the
+//schema.
+func (v Variant_) Validate() error {
+	for fs := range v.MkfsOpts {
+		if fs == "" || !layoutFsTypes[fs] {
+			return fmt.Errorf("MkfsOpts: unsupported fs type %q", fs)
+		}
+	}
+	if v.Encryption != nil && v.Encryption.TPM2PCRs != "" && !pcrRe.MatchString(v.Encryption.TPM2PCRs) {
+		return fmt.Errorf("Encryption: bad TPM2PCRs %q", v.Encryption.TPM2PCRs)
+	}
+	if v.ErasePolicy != nil {
+		if err := v.ErasePolicy.Validate(); err != nil {
+			return fmt.Errorf("ErasePolicy: %s", err)
+		}
+	}
+	switch v.Bootloader {
+	case "", BootloaderStub, BootloaderSdBoot:
+	default:
+		return fmt.Errorf("unknown Bootloader %q", v.Bootloader)
+	}
+	if v.PartitionLayout == nil {
+		return nil
+	}
+	if err := v.PartitionLayout.Validate(); err != nil {
+		return fmt.Errorf("PartitionLayout: %s", err)
+	}
+	if v.PartitionLayout.abSlots() {
+		if v.NumDataDisks > 1 || v.SwRaidlevel != -1 {
+			return fmt.Errorf("PartitionLayout: A/B slots not supported with multiple data disks or raid")
+		}
+		if v.Encryption != nil {
+			return fmt.Errorf("PartitionLayout: A/B slots not supported with Encryption")
+		}
+	}
+	if v.NumDataDisks > 1 {
+		for _, s := range v.PartitionLayout.Data {
+			if s.Role == RoleOther && s.MountPoint != "" {
+				return fmt.Errorf("PartitionLayout: mount point %s not supported with multiple data disks", s.MountPoint)
+			}
+		}
+	}
+	return nil
+}
+
 func DumpDescriptions() {
 	m, err := json.MarshalIndent(variants, "  ", "  ")
 	if err != nil {
@@ -0,0 +1,241 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package appliance
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/partitioning"
+)
+
+//PartRole determines how factory restore uses a partition.
+type PartRole string
+
+const (
+	RoleRoot     PartRole = "root"     //main volume; raid member on raid platforms
+	RoleRootB    PartRole = "root_b"   //second root slot for A/B updates; see ABSlots
+	RoleBoot     PartRole = "boot"     //legacy boot partition, holding grub4dos files and kernel
+	RoleESP      PartRole = "esp"      //EFI system partition, on recovery device
+	RoleRecovery PartRole = "recovery" //recovery volume; must be last on recovery device
+	RoleOther    PartRole = ""         //formatted and added to fstab if FsType is set
+)
+
+//Firmware, if set, limits a partition to units booted via uefi or legacy bios.
+const (
+	FirmwareUEFI   = "uefi"
+	FirmwareLegacy = "legacy"
+)
+
+//PartitionLayout describes the partitions created on data disks and on the
+//recovery device, in order. If a Variant_ has no layout, DefaultLayout is used.
+type PartitionLayout struct {
+	Data     []PartSpec //created on each data disk
+	Recovery []PartSpec `json:",omitempty"` //created on the recovery device during manufacture
+}
+
+//PartSpec describes a single partition. At most one of SizeMB and Percent may
+//be set; if neither is, the partition uses all remaining space and must be
+//last.
+type PartSpec struct {
+	Label      string                `json:",omitempty"` //gpt name, and fs label unless role dictates one
+	Type       partitioning.PartType //on raid platforms, root is always raid
+	SizeMB     uint64                `json:",omitempty"`
+	Percent    uint64                `json:",omitempty"` //percentage of disk size
+	Boot       bool                  `json:",omitempty"` //set boot flag (mbr)
+	Role       PartRole              `json:",omitempty"`
+	Firmware   string                `json:",omitempty"` //uefi, legacy, or empty for both
+	FsType     string                `json:",omitempty"` //empty: unformatted, except for root and boot
+	MountPoint string                `json:",omitempty"` //in fstab
+	MountOpts  string                `json:",omitempty"`
+}
+
+//DefaultLayout is used for variants which don't specify a layout.
+func DefaultLayout() *PartitionLayout {
+	return &PartitionLayout{
+		Data: []PartSpec{
+			{Label: "g4d", Type: partitioning.Linux, SizeMB: 200, Role: RoleBoot, Firmware: FirmwareLegacy,
+				FsType: "ext3", MountOpts: "noauto,relatime,noexec"},
+			{Label: strs.PriVolName(), Type: partitioning.Linux, Role: RoleRoot, FsType: "ext4",
+				MountPoint: "/", MountOpts: "auto,relatime"},
+		},
+		Recovery: []PartSpec{
+			{Label: "ESP", Type: partitioning.ESP, SizeMB: 200, Boot: true, Role: RoleESP, Firmware: FirmwareUEFI,
+				FsType: "vfat"},
+			{Label: "recovery", Type: partitioning.Linux, Boot: true, Role: RoleRecovery, Firmware: FirmwareLegacy,
+				FsType: "ext3"},
+			{Label: "recovery", Type: partitioning.Linux, Role: RoleRecovery, Firmware: FirmwareUEFI,
+				FsType: "ext3"},
+		},
+	}
+}
+
+//Layout returns the partition layout for the variant.
+func (v *Variant) Layout() *PartitionLayout {
+	if v.i.PartitionLayout != nil {
+		return v.i.PartitionLayout
+	}
+	return DefaultLayout()
+}
+
+//Encryption configures full-disk encryption of the primary volume. The key
+//comes from the Stasher; optionally, a second key is sealed by the TPM so that
+//the unit can boot without the Stasher.
+type Encryption struct {
+	TPM2     bool   `json:",omitempty"` //also enroll a tpm2-sealed key
+	TPM2PCRs string `json:",omitempty"` //PCRs the tpm2 key is bound to, such as "7" or "0+7"
+}
+
+//Encryption returns encryption settings for the primary volume, or nil if it
+//is not to be encrypted.
+func (v *Variant) Encryption() *Encryption {
+	return v.i.Encryption
+}
+
+//Uefi boot backends. With BootloaderStub, the firmware boots the kernel's EFI
+//stub directly; with BootloaderSdBoot, it boots systemd-boot, which boots
+//Unified Kernel Images. Legacy bios units always use grub4dos.
+const (
+	BootloaderStub   = "efistub"
+	BootloaderSdBoot = "systemd-boot"
+)
+
+//Bootloader returns the variant's uefi boot backend.
+func (v *Variant) Bootloader() string {
+	if v.i.Bootloader == "" {
+		return BootloaderStub
+	}
+	return v.i.Bootloader
+}
+
+//ABSlots returns true if the layout has a second root partition, in which
+//case updates are written to whichever root slot is inactive rather than
+//requiring a factory restore.
+func (v *Variant) ABSlots() bool {
+	return v.Layout().abSlots()
+}
+
+func (l *PartitionLayout) abSlots() bool {
+	return l != nil && count(l.Data, RoleRootB) > 0
+}
+
+//Select returns the partitions in specs which apply given the boot firmware.
+func Select(specs []PartSpec, uefi bool) (sel []PartSpec) {
+	for _, s := range specs {
+		if s.Firmware == FirmwareUEFI && !uefi || s.Firmware == FirmwareLegacy && uefi {
+			continue
+		}
+		sel = append(sel, s)
+	}
+	return
+}
+
+//Megs returns the size of the partition in MB given the disk size in bytes,
+//or 0 for all remaining space.
+func (s PartSpec) Megs(diskBytes uint64) uint64 {
+	if s.Percent > 0 {
+		return diskBytes / (1024 * 1024) * s.Percent / 100
+	}
+	return s.SizeMB
+}
+
+//fs types supported by disk.Filesystem.Format
+var layoutFsTypes = map[string]bool{"": true, "ext2": true, "ext3": true, "ext4": true, "vfat": true, "xfs": true, "btrfs": true}
+
+//fs types that grub4dos and the recovery kernel can't be relied upon to read
+var noBootFsTypes = map[string]bool{"xfs": true, "btrfs": true}
+
+//MkfsOpts returns the variant's mkfs options for the given fs type, or nil if
+//defaults are to be used.
+func (v *Variant) MkfsOpts(fsType string) []string {
+	return v.i.MkfsOpts[fsType]
+}
+
+//Validate checks the layout for errors that would prevent factory restore.
+func (l *PartitionLayout) Validate() error {
+	if l == nil {
+		return nil
+	}
+	for _, uefi := range []bool{true, false} {
+		fw := FirmwareLegacy
+		if uefi {
+			fw = FirmwareUEFI
+		}
+		data := Select(l.Data, uefi)
+		if err := validateSpecs(data); err != nil {
+			return fmt.Errorf("Data (%s): %s", fw, err)
+		}
+		if count(data, RoleRoot) != 1 {
+			return fmt.Errorf("Data (%s): need exactly one root partition", fw)
+		}
+		if count(data, RoleRootB) > 1 {
+			return fmt.Errorf("Data (%s): at most one root_b partition", fw)
+		}
+		if !uefi && count(data, RoleBoot) != 1 {
+			return fmt.Errorf("Data (%s): need exactly one boot partition", fw)
+		}
+		if len(l.Recovery) == 0 {
+			continue
+		}
+		recov := Select(l.Recovery, uefi)
+		if err := validateSpecs(recov); err != nil {
+			return fmt.Errorf("Recovery (%s): %s", fw, err)
+		}
+		if len(recov) == 0 || recov[len(recov)-1].Role != RoleRecovery || count(recov, RoleRecovery) != 1 {
+			return fmt.Errorf("Recovery (%s): recovery partition must be last, and only one", fw)
+		}
+		if uefi && count(recov, RoleESP) != 1 {
+			return fmt.Errorf("Recovery (%s): need exactly one ESP", fw)
+		}
+	}
+	return nil
+}
+
+func validateSpecs(specs []PartSpec) error {
+	var pct uint64
+	for i, s := range specs {
+		if s.SizeMB > 0 && s.Percent > 0 {
+			return fmt.Errorf("partition %d: both SizeMB and Percent set", i+1)
+		}
+		if s.SizeMB == 0 && s.Percent == 0 && i != len(specs)-1 {
+			return fmt.Errorf("partition %d: only the last partition may use remaining space", i+1)
+		}
+		pct += s.Percent
+		if !layoutFsTypes[s.FsType] {
+			return fmt.Errorf("partition %d: unsupported fs type %s", i+1, s.FsType)
+		}
+		if noBootFsTypes[s.FsType] && s.Role != RoleRoot && s.Role != RoleRootB && s.Role != RoleOther {
+			return fmt.Errorf("partition %d: fs type %s not supported for role %s", i+1, s.FsType, s.Role)
+		}
+		if s.MountPoint != "" && s.FsType == "" && s.Role == RoleOther {
+			return fmt.Errorf("partition %d: mount point without fs type", i+1)
+		}
+		switch s.Role {
+		case RoleRoot, RoleRootB, RoleBoot, RoleESP, RoleRecovery, RoleOther:
+		default:
+			return fmt.Errorf("partition %d: unknown role %s", i+1, s.Role)
+		}
+		if s.Firmware != "" && s.Firmware != FirmwareUEFI && s.Firmware != FirmwareLegacy {
+			return fmt.Errorf("partition %d: unknown firmware %s", i+1, s.Firmware)
+		}
+	}
+	if pct > 100 {
+		return errors.New("percentages exceed 100")
+	}
+	return nil
+}
+
+func count(specs []PartSpec, r PartRole) (n int) {
+	for _, s := range specs {
+		if s.Role == r {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,298 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package appliance
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/partitioning"
+
+	"github.com/santhosh-tekuri/jsonschema"
+)
+
+func TestLayout(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//inserted into a copy of the first variant in aj_default
+	for _, td := range []struct {
+		name      string
+		layout    string
+		schemaErr bool
+		err       string
+	}{
+		{
+			name: "valid",
+			layout: `{"Data":[
+				{"Label":"g4d","Type":"linux","SizeMB":200,"Role":"boot","Firmware":"legacy","FsType":"ext3"},
+				{"Label":"root","Type":"linux","Percent":50,"Role":"root","FsType":"ext4","MountPoint":"/"},
+				{"Label":"data","Type":"linux","FsType":"ext4","MountPoint":"/data","MountOpts":"auto,noatime"}]}`,
+		},
+		{
+			name:      "badType",
+			layout:    `{"Data":[{"Type":"linuxx","Role":"root"}]}`,
+			schemaErr: true,
+			err:       "unknown partition type",
+		},
+		{
+			name:   "noRoot",
+			layout: `{"Data":[{"Type":"linux","Role":"boot"}]}`,
+			err:    "need exactly one root",
+		},
+		{
+			name:   "noLegacyBoot",
+			layout: `{"Data":[{"Type":"linux","Role":"root"}]}`,
+			err:    "need exactly one boot",
+		},
+		{
+			name: "remainingNotLast",
+			layout: `{"Data":[{"Type":"linux","Role":"boot","Firmware":"legacy"},
+				{"Type":"linux","Role":"root","SizeMB":100}]}`,
+			err: "only the last partition",
+		},
+		{
+			name: "recoveryNotLast",
+			layout: `{"Data":[{"Type":"linux","Role":"root","Firmware":"uefi"},
+					{"Type":"linux","Role":"boot","Firmware":"legacy","SizeMB":200},{"Type":"linux","Role":"root","Firmware":"legacy"}],
+				"Recovery":[{"Type":"linux","Role":"recovery","SizeMB":100},{"Type":"esp","Role":"esp"}]}`,
+			err: "recovery partition must be last",
+		},
+		{
+			name:      "badFs",
+			layout:    `{"Data":[{"Type":"linux","Role":"boot","Firmware":"legacy","SizeMB":200},{"Type":"linux","Role":"root","FsType":"zfs"}]}`,
+			schemaErr: true,
+			err:       "unsupported fs type",
+		},
+		{
+			name:   "xfsBoot",
+			layout: `{"Data":[{"Type":"linux","Role":"boot","Firmware":"legacy","SizeMB":200,"FsType":"xfs"},{"Type":"linux","Role":"root","FsType":"xfs"}]}`,
+			err:    "not supported for role boot",
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`,
+				`"Prototype":true,"PartitionLayout":`+td.layout+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); (err != nil) != td.schemaErr {
+				t.Errorf("schema: want error %t, got %v", td.schemaErr, err)
+			}
+			err := loadJson([]byte(v))
+			if td.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), td.err) {
+					t.Fatalf("want error containing %q, got %v", td.err, err)
+				}
+				return
+			}
+			l := Get("QEMU").Layout()
+			data := Select(l.Data, false)
+			if len(data) != 3 || data[1].Type != partitioning.Linux || data[1].Megs(4096*1024*1024) != 2048 {
+				t.Errorf("bad layout %#v", data)
+			}
+			if uefi := Select(l.Data, true); len(uefi) != 2 || uefi[0].Role != RoleRoot {
+				t.Errorf("bad uefi layout %#v", uefi)
+			}
+			out, err := json.Marshal(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(out), `"Type":"linux"`) {
+				t.Errorf("part type not marshalled by name: %s", out)
+			}
+		})
+	}
+	//restore default variants
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+	if err = DefaultLayout().Validate(); err != nil {
+		t.Errorf("default layout: %s", err)
+	}
+	if Get("QEMU").Layout() == nil {
+		t.Error("no default layout")
+	}
+}
+
+func TestMkfsOpts(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []struct {
+		name string
+		opts string
+		err  bool
+	}{
+		{name: "valid", opts: `{"xfs":["-m","reflink=1"],"ext4":[]}`},
+		{name: "badFs", opts: `{"zfs":["-o","ashift=12"]}`, err: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`, `"Prototype":true,"MkfsOpts":`+td.opts+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); (err != nil) != td.err {
+				t.Errorf("schema: want error %t, got %v", td.err, err)
+			}
+			err := loadJson([]byte(v))
+			if (err != nil) != td.err {
+				t.Fatalf("want error %t, got %v", td.err, err)
+			}
+			if err != nil {
+				return
+			}
+			q := Get("QEMU")
+			if o := q.MkfsOpts("xfs"); len(o) != 2 || o[1] != "reflink=1" {
+				t.Errorf("bad xfs opts %v", o)
+			}
+			if o := q.MkfsOpts("ext4"); o == nil || len(o) != 0 {
+				t.Errorf("want empty, non-nil ext4 opts, got %#v", o)
+			}
+			if o := q.MkfsOpts("btrfs"); o != nil {
+				t.Errorf("want nil btrfs opts, got %v", o)
+			}
+		})
+	}
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []struct {
+		name string
+		enc  string
+		err  bool
+	}{
+		{name: "valid", enc: `{"TPM2":true,"TPM2PCRs":"0+7"}`},
+		{name: "noTpm", enc: `{}`},
+		{name: "badPcrs", enc: `{"TPM2":true,"TPM2PCRs":"0,7"}`, err: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`, `"Prototype":true,"Encryption":`+td.enc+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); (err != nil) != td.err {
+				t.Errorf("schema: want error %t, got %v", td.err, err)
+			}
+			err := loadJson([]byte(v))
+			if (err != nil) != td.err {
+				t.Fatalf("want error %t, got %v", td.err, err)
+			}
+			if err == nil && Get("QEMU").Encryption() == nil {
+				t.Error("encryption not set")
+			}
+		})
+	}
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+	if Get("QEMU").Encryption() != nil {
+		t.Error("encryption should be off by default")
+	}
+}
+
+func TestBootloader(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []struct {
+		name string
+		bl   string
+		want string
+		err  bool
+	}{
+		{name: "sdboot", bl: `"systemd-boot"`, want: BootloaderSdBoot},
+		{name: "stub", bl: `"efistub"`, want: BootloaderStub},
+		{name: "empty", bl: `""`, want: BootloaderStub},
+		{name: "grub", bl: `"grub"`, err: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`, `"Prototype":true,"Bootloader":`+td.bl+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); (err != nil) != td.err {
+				t.Errorf("schema: want error %t, got %v", td.err, err)
+			}
+			err := loadJson([]byte(v))
+			if (err != nil) != td.err {
+				t.Fatalf("want error %t, got %v", td.err, err)
+			}
+			if err == nil && Get("QEMU").Bootloader() != td.want {
+				t.Errorf("want %s, got %s", td.want, Get("QEMU").Bootloader())
+			}
+		})
+	}
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+	if Get("QEMU").Bootloader() != BootloaderStub {
+		t.Error("want efistub by default")
+	}
+}
+
+func TestABSlots(t *testing.T) {
+	schema, err := jsonschema.Compile("schemas/appliance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	factsSchema, err := jsonschema.Compile("schemas/platform_facts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		boot  = `{"Type":"linux","Role":"boot","Firmware":"legacy","SizeMB":200},`
+		rootA = `{"Type":"linux","Role":"root","Percent":30},`
+		rootB = `{"Type":"linux","Role":"root_b","Percent":30},`
+		data  = `{"Type":"linux","FsType":"ext4","MountPoint":"/data"}`
+	)
+	for _, td := range []struct {
+		name  string
+		extra string
+		err   string
+	}{
+		{name: "valid", extra: `"PartitionLayout":{"Data":[` + boot + rootA + rootB + data + `]}`},
+		{name: "twoB", extra: `"PartitionLayout":{"Data":[` + boot + rootA + rootB + rootB + data + `]}`, err: "at most one root_b"},
+		{name: "encrypted", extra: `"Encryption":{},"PartitionLayout":{"Data":[` + boot + rootA + rootB + data + `]}`, err: "not supported with Encryption"},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			v := strings.Replace(aj_default, `"Prototype":true},`, `"Prototype":true,`+td.extra+`},`, 1)
+			if err := schema.Validate(strings.NewReader(v)); err != nil {
+				t.Errorf("schema: %s", err)
+			}
+			err := loadJson([]byte(v))
+			if td.err != "" {
+				if err == nil || !strings.Contains(err.Error(), td.err) {
+					t.Fatalf("want error containing %q, got %v", td.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Get("QEMU").ABSlots() {
+				t.Error("want A/B slots")
+			}
+			//platform facts written for the variant must pass their schema too
+			out := &Variant{i: Get("QEMU").i, mfg: "mfg", prod: "prod", sku: "sku", serial: "serial"}
+			if err := factsSchema.Validate(bytes.NewReader(out.json())); err != nil {
+				t.Errorf("facts schema: %s", err)
+			}
+		})
+	}
+	if err = loadJson(getJson()); err != nil {
+		t.Fatal(err)
+	}
+	if Get("QEMU").ABSlots() {
+		t.Error("default layout has no A/B slots")
+	}
+}
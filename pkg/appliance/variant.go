@@ -89,11 +89,29 @@ type Variant_ struct {
 	RecoveryMedia         recoveryMediaS //in separate struct
 	Lcd                   LcdType        //renamed; now stored as string in json rather than enum value
 	DevCodeName           string         //specific code name - ex: Oxcart-12
-	Partoffset            string         `json:",omitempty"` //unused?
+	Partoffset            string         `json:",omitempty"` //unused; see PartitionLayout
 	Lowmemory             bool           `json:",omitempty"` //true for low-memory devices such as the micro
 	Prototype             bool           `json:",omitempty"` //true for prototypes - relax some restrictions
 	DiskSTol              uint64         `json:",omitempty"` //allowable tolerance between disks in a group
 	DiskTTol              uint64         `json:",omitempty"` //allowable tolerance between any one disk in group and target size
+
+	//partitions to create on data disks and recovery device; if nil,
+	//DefaultLayout() is used
+	PartitionLayout *PartitionLayout `json:",omitempty"`
+
+	//mkfs options by fs type, replacing the defaults; for example
+	//{"xfs": ["-m", "reflink=1"]}
+	MkfsOpts map[string][]string `json:",omitempty"`
+
+	//if set, the primary volume is created in a LUKS2 container
+	Encryption *Encryption `json:",omitempty"`
+
+	//uefi boot backend: efistub (default) or systemd-boot
+	Bootloader string `json:",omitempty"`
+
+	//data erase methods, patterns, and verification; if nil, defaults are
+	//used
+	ErasePolicy *ErasePolicy `json:",omitempty"`
 }
 
 //Variant describes a particular model of appliance.
@@ -51,6 +51,9 @@ type FRData interface {
 	// If true, Delete method will have no effect in this session or any other.
 	// Useful in development.
 	SetPreserve(noDelete bool)
+
+	//Policy used to choose among images. See archive.ParsePolicy.
+	ImgPolicy() string
 }
 
 var fRDataImpl FRData
@@ -176,3 +179,12 @@ func SetPreserve(noDelete bool) {
 		log.Log("FRData impl unset")
 	}
 }
+
+//Policy used to choose among images. See archive.ParsePolicy.
+func ImgPolicy() string {
+	if fRDataImpl != nil {
+		return fRDataImpl.ImgPolicy()
+	}
+	log.Log("FRData impl unset")
+	return ""
+}
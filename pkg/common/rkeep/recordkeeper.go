@@ -52,6 +52,7 @@ type RecordKeeper interface {
 const (
 	PrintedDocUnknown PrintedDocType = "unknown"
 	PrintedDocQAV     PrintedDocType = "QA Verification"
+	PrintedDocErasure PrintedDocType = "Erasure Certificate"
 )
 
 var rkeeper RecordKeeper
@@ -0,0 +1,163 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// Package sig handles detached ed25519 signatures, as used for images,
+// emergency configs, and certificates of erasure.
+//
+// The signature for FILE is stored in FILE.sig, and is the base64-encoded
+// ed25519 signature of the sha256 digest of FILE. Signing the digest rather
+// than the file itself means a multi-GB image need not be held in memory.
+//
+// A keyring is a dir in which each *.pub file holds one base64-encoded
+// ed25519 public key. In both file types, anything following a '#' is a
+// comment.
+package sig
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//Ext is appended to a file's name to get that of its signature
+const Ext = ".sig"
+
+var (
+	ErrUnsigned = errors.New("no signature")
+	ErrNoKeys   = errors.New("no trusted keys")
+)
+
+//LoadKeyring returns all public keys in dir.
+func LoadKeyring(dir string) (keys []ed25519.PublicKey) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Logf("reading keyring %s: %s", dir, err)
+		return nil
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".pub") {
+			continue
+		}
+		k, err := readB64(fp.Join(dir, e.Name()), ed25519.PublicKeySize)
+		if err != nil {
+			log.Logf("keyring: skipping %s: %s", e.Name(), err)
+			continue
+		}
+		keys = append(keys, ed25519.PublicKey(k))
+	}
+	log.Logf("keyring: loaded %d key(s) from %s", len(keys), dir)
+	return
+}
+
+//read a file containing a single base64-encoded value of the given size
+func readB64(path string, size int) ([]byte, error) {
+	lines, err := futil.ReadConfigLines(path, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("%s: no data", path)
+	}
+	data, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("%s: bad length %d, want %d", path, len(data), size)
+	}
+	return data, nil
+}
+
+//sha256 digest of file
+func digest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//Verify checks path's detached signature against keys. Returns ErrUnsigned
+//if there is no signature and ErrNoKeys if there are no keys.
+func Verify(path string, keys []ed25519.PublicKey) error {
+	return verify(path, keys, func() ([]byte, error) { return digest(path) })
+}
+
+//VerifyBytes is like Verify, but checks the signature against data read from
+//path rather than reading it again. Use it when path is on untrusted media, so
+//that the bytes checked are the bytes used.
+func VerifyBytes(path string, data []byte, keys []ed25519.PublicKey) error {
+	sum := sha256.Sum256(data)
+	return VerifyDigest(path, sum[:], keys)
+}
+
+//VerifyDigest is like Verify, given the sha256 digest of path's content - for
+//example, computed while it was being read for some other purpose.
+func VerifyDigest(path string, sum []byte, keys []ed25519.PublicKey) error {
+	return verify(path, keys, func() ([]byte, error) { return sum, nil })
+}
+
+//check path's signature against the digest from sum. The digest is only
+//computed once the signature and keys are known to exist.
+func verify(path string, keys []ed25519.PublicKey, sum func() ([]byte, error)) error {
+	sig, err := readB64(path+Ext, ed25519.SignatureSize)
+	if os.IsNotExist(err) {
+		return ErrUnsigned
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+	d, err := sum()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k, d, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature does not match any trusted key")
+}
+
+//VerifyFile checks the detached signature on path against the keys in
+//keyringDir.
+func VerifyFile(path, keyringDir string) error {
+	return Verify(path, LoadKeyring(keyringDir))
+}
+
+//Sign writes a detached signature for path.
+func Sign(path string, key ed25519.PrivateKey) error {
+	sum, err := digest(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+Ext, []byte(SignDigest(sum, key)+"\n"), 0644)
+}
+
+//SignDigest returns the signature of a sha256 digest, base64-encoded as in a
+//.sig file.
+func SignDigest(sum []byte, key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum))
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package sig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func TestSignatures(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	dir, err := ioutil.TempDir("", "gp-sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keydir := fp.Join(dir, "keys")
+	if err = os.Mkdir(keydir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	enc := "# test key\n" + base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err = ioutil.WriteFile(fp.Join(keydir, "test.pub"), []byte(enc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := LoadKeyring(keydir)
+	if len(keys) != 1 {
+		t.Fatalf("want 1 key, got %d", len(keys))
+	}
+
+	f := fp.Join(dir, "file")
+	if err = ioutil.WriteFile(f, []byte("some data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = Verify(f, keys); err != ErrUnsigned {
+		t.Errorf("unsigned: want %s, got %v", ErrUnsigned, err)
+	}
+	if err = Sign(f, priv); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyFile(f, keydir); err != nil {
+		t.Errorf("signed: %s", err)
+	}
+	if err = Verify(f, nil); err != ErrNoKeys {
+		t.Errorf("no keys: want %s, got %v", ErrNoKeys, err)
+	}
+	if err = Verify(f, []ed25519.PublicKey{otherPub}); err == nil {
+		t.Error("signature verified with wrong key")
+	}
+
+	//modify file; signature must no longer match
+	if err = ioutil.WriteFile(f, []byte("some other data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = Verify(f, keys); err == nil {
+		t.Error("signature verified for modified file")
+	}
+	//only the bytes given are checked, not the file's current content
+	if err = VerifyBytes(f, []byte("some data"), keys); err != nil {
+		t.Errorf("VerifyBytes, signed data: %s", err)
+	}
+	if err = VerifyBytes(f, []byte("some other data"), keys); err == nil {
+		t.Error("VerifyBytes accepted modified data")
+	}
+}
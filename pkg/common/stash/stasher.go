@@ -8,9 +8,12 @@
 package stash
 
 import (
+	"errors"
+
 	"github.com/purecloudlabs/gprovision/pkg/common"
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	steps "github.com/purecloudlabs/gprovision/pkg/mfg/configStep"
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
 )
 
 // Stasher securely stores secrets and allows agonizing abominable alliteration.
@@ -41,6 +44,11 @@ type Stasher interface {
 	ReadBiosPass() (string, error)
 	//Returns IPMI Password.
 	ReadIPMIPass() (string, error)
+	//Returns pem-encoded tls client cert and key, or nils if there are none.
+	ReadClientCert() (cert, key []byte, err error)
+	//Returns key for full-disk encryption of the primary volume, creating it
+	//if necessary.
+	ReadDiskKey() ([]byte, error)
 
 	// Asks user to input shell password. Compares to stored pw. Reboots if no
 	// match - ONLY returns if password matches.
@@ -55,12 +63,15 @@ func SetImpl(s Stasher) {
 		log.Log("Stasher: overwriting non-nil impl")
 	}
 	stasherImpl = s
+	tlscfg.SetCertSource(ReadClientCert)
 }
 
 //set serial number, recovery volume, etc
 func SetUnit(unit common.Unit) {
 	if stasherImpl != nil {
 		stasherImpl.SetUnit(unit)
+		//client cert may now be available
+		tlscfg.Reload()
 	} else {
 		log.Log("Stasher: impl unset")
 	}
@@ -121,6 +132,25 @@ func ReadIPMIPass() (string, error) {
 	return "", nil
 }
 
+//Returns pem-encoded tls client cert and key, or nils if there are none.
+func ReadClientCert() (cert, key []byte, err error) {
+	if stasherImpl != nil {
+		return stasherImpl.ReadClientCert()
+	}
+	log.Log("Stasher: impl unset")
+	return nil, nil, nil
+}
+
+//Returns key for full-disk encryption of the primary volume, creating it if
+//necessary.
+func ReadDiskKey() ([]byte, error) {
+	if stasherImpl != nil {
+		return stasherImpl.ReadDiskKey()
+	}
+	log.Log("Stasher: impl unset")
+	return nil, errors.New("no stasher")
+}
+
 // Asks user to input shell password. Compares to stored pw. Reboots if no
 // match - ONLY returns if password matches.
 func RequestShellPassword() {
@@ -13,6 +13,7 @@ func LogEnv() string             { return EnvPrefix() + "XLOG" }
 func ContinueLoggingEnv() string { return EnvPrefix() + "CONT_LOGGING" }
 func CoreEnv() string            { return EnvPrefix() + "TRACE_ROOT" }
 func MfgTestEnv() string         { return EnvPrefix() + "MFG_TEST" }
+func TLSServersEnv() string      { return EnvPrefix() + "TLS_SERVERS" }
 
 //historically, these two lacked a company/product-specific prefix
 
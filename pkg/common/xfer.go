@@ -28,4 +28,5 @@ type TransferableVerifiableFile interface {
 	Verifyer
 	Basename() string
 	Mode(m os.FileMode)
+	Parallel(n int)
 }
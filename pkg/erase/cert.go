@@ -0,0 +1,210 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/rkeep"
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+/* Certificate of erasure, loosely following the sample in NIST SP 800-88
+   appendix G. Written to the recovery volume and sent to the RecordKeeper as
+   JSON, with a human-readable text version.
+
+   The JSON has a detached signature (NAME.json.sig) in the same format as
+   image signatures: the base64-encoded ed25519 signature of the sha256 digest.
+   It can be checked with sig.VerifyFile. The signing key is read from
+   CertKeyFile; without it, the certificate is still written, but unsigned.
+   The key is the same on every unit, so the signature shows integrity only,
+   not authenticity; see the package doc.
+*/
+
+//NIST SP 800-88 sanitization categories
+const (
+	sanitizeClear = "clear"
+	sanitizePurge = "purge"
+)
+
+//CertKeyFile is a file in the initramfs holding the base64-encoded ed25519
+//private key used to sign certificates. As it is readable by anyone with
+//access to an initramfs, it must not be trusted to prove a certificate's origin.
+var CertKeyFile = "/etc/keys/erase/cert.key"
+
+const certDir = "erasure"
+
+//Certificate records the erasure of a unit.
+type Certificate struct {
+	Serial      string //appliance serial
+	Codename    string
+	Start, End  time.Time
+	CryptErased []string `json:",omitempty"` //LUKS devices whose keys were destroyed
+	Policy      appliance.ErasePolicy
+	Disks       []DiskRecord
+}
+
+//DiskRecord records the erasure of one disk.
+type DiskRecord struct {
+	Dev, Model, Serial string
+	Size               uint64
+	Method             string //e.g. ATA enhanced secure erase
+	Category           string //clear or purge, per NIST SP 800-88
+	Start, End         time.Time
+	Canaries           int    //number of canary values written before erase
+	CanariesRemaining  int    //canaries found afterwards; must be 0
+	ReadBack           string `json:",omitempty"` //read-back after erase, e.g. 10% sample
+	ReadBackFailures   int    `json:",omitempty"` //chunks failing read-back; must be 0
+}
+
+//fill in identifying info for the disk
+func (r *DiskRecord) identify(dev string, size uint64) {
+	r.Dev = dev
+	r.Size = size
+	var err error
+	if r.Model, err = block.ReadModel(fp.Base(dev)); err != nil {
+		log.Logf("%s: reading model: %s", dev, err)
+	}
+	if r.Serial, err = block.ReadSerial(fp.Base(dev)); err != nil {
+		log.Logf("%s: reading serial: %s", dev, err)
+	}
+}
+
+func (c *Certificate) name() string {
+	return fmt.Sprintf("%s_erasure_%s", c.Serial, c.End.UTC().Format("20060102T150405Z"))
+}
+
+func (c *Certificate) json() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+//text is the human-readable version; sig is that of the json version
+func (c *Certificate) text(sig string) []byte {
+	var buf bytes.Buffer
+	tf := "2006-01-02 15:04:05 MST"
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "CERTIFICATE OF DATA ERASURE\n\n")
+	fmt.Fprintf(w, "Appliance serial:\t%s\n", c.Serial)
+	fmt.Fprintf(w, "Model:\t%s\n", c.Codename)
+	fmt.Fprintf(w, "Started:\t%s\n", c.Start.UTC().Format(tf))
+	fmt.Fprintf(w, "Completed:\t%s\n", c.End.UTC().Format(tf))
+	for _, dev := range c.CryptErased {
+		fmt.Fprintf(w, "Encryption keys destroyed:\t%s\n", dev)
+	}
+	fmt.Fprintf(w, "Erase policy:\t%s\n", describePolicy(c.Policy))
+	for i, d := range c.Disks {
+		fmt.Fprintf(w, "\nDisk %d:\t%s\n", i+1, d.Dev)
+		fmt.Fprintf(w, "  Model:\t%s\n", d.Model)
+		fmt.Fprintf(w, "  Serial:\t%s\n", d.Serial)
+		fmt.Fprintf(w, "  Size:\t%d bytes\n", d.Size)
+		fmt.Fprintf(w, "  Method:\t%s (%s)\n", d.Method, d.Category)
+		fmt.Fprintf(w, "  Started:\t%s\n", d.Start.UTC().Format(tf))
+		fmt.Fprintf(w, "  Completed:\t%s\n", d.End.UTC().Format(tf))
+		fmt.Fprintf(w, "  Verification:\t%d of %d canaries remain\n", d.CanariesRemaining, d.Canaries)
+		if d.ReadBack != "" {
+			fmt.Fprintf(w, "  Read-back:\t%s, %d chunks failed\n", d.ReadBack, d.ReadBackFailures)
+		}
+	}
+	if sig == "" {
+		sig = "none"
+	}
+	fmt.Fprintf(w, "\nSignature (of %s.json):\t%s\n", c.name(), sig)
+	_ = w.Flush()
+	return buf.Bytes()
+}
+
+//load the signing key; nil if unavailable
+func certKey(path string) ed25519.PrivateKey {
+	lines, err := futil.ReadConfigLines(path, 1)
+	if err != nil || len(lines) != 1 {
+		log.Logf("no certificate signing key at %s: %v", path, err)
+		return nil
+	}
+	k, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(k) != ed25519.PrivateKeySize {
+		log.Logf("bad certificate signing key at %s: %v", path, err)
+		return nil
+	}
+	return ed25519.PrivateKey(k)
+}
+
+//signature of data in the format used by sig.Sign; empty if key is nil
+func certSig(data []byte, key ed25519.PrivateKey) string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return sig.SignDigest(sum[:], key)
+}
+
+//write the certificate's files to dir, returning their names and contents
+func (c *Certificate) write(dir string, key ed25519.PrivateKey) (map[string][]byte, error) {
+	js, err := c.json()
+	if err != nil {
+		return nil, err
+	}
+	sig := certSig(js, key)
+	name := c.name()
+	files := map[string][]byte{
+		name + ".json": js,
+		name + ".txt":  c.text(sig),
+	}
+	if sig != "" {
+		files[name+".json.sig"] = []byte(sig + "\n")
+	}
+	if dir == "" {
+		return files, nil
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return files, err
+	}
+	for f, data := range files {
+		if e := ioutil.WriteFile(fp.Join(dir, f), data, 0644); e != nil {
+			err = e
+		}
+	}
+	return files, err
+}
+
+//issue the certificate: write to the recovery volume if mounted and send to
+//the RecordKeeper
+func (c *Certificate) issue(recovRoot string) {
+	c.End = time.Now()
+	dir := ""
+	if recovRoot != "" {
+		dir = fp.Join(recovRoot, strs.RecoveryLogDir(), certDir)
+	}
+	files, err := c.write(dir, certKey(CertKeyFile))
+	if err != nil {
+		log.Logf("writing erasure certificate: %s", err)
+	}
+	if dir != "" {
+		log.Logf("erasure certificate written to %s", dir)
+	}
+	if !rkeep.HaveRKeeper() {
+		return
+	}
+	for name, data := range files {
+		rkeep.StoreDocument(name, rkeep.PrintedDocErasure, data)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func TestCertificate(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	tmp, err := ioutil.TempDir("", "gp-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := fp.Join(tmp, "cert.key")
+	err = ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(priv)+" #test key\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := fp.Join(tmp, "keys")
+	if err = os.Mkdir(keyring, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(fp.Join(keyring, "erase.pub"), []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	c := &Certificate{
+		Serial:   "SN123",
+		Codename: "QEMU",
+		Start:    start,
+		End:      start.Add(3 * time.Hour),
+		Policy:   curPolicy,
+		Disks: []DiskRecord{{
+			Dev:      "/dev/sda",
+			Model:    "ST91000640NS",
+			Serial:   "9XG0ABCD",
+			Size:     1000204886016,
+			Method:   "ATA enhanced secure erase",
+			Category: sanitizePurge,
+			Start:    start,
+			End:      start.Add(3 * time.Hour),
+			Canaries: 931,
+		}},
+	}
+	dir := fp.Join(tmp, "erasure")
+	files, err := c.write(dir, certKey(keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("want json, sig, and text; got %d files", len(files))
+	}
+	js := fp.Join(dir, "SN123_erasure_20200304T080607Z.json")
+	if err = sig.VerifyFile(js, keyring); err != nil {
+		t.Errorf("verifying %s: %s", js, err)
+	}
+	var got Certificate
+	data, err := ioutil.ReadFile(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Serial != c.Serial || len(got.Disks) != 1 || got.Disks[0] != c.Disks[0] || got.Policy.Passes != c.Policy.Passes {
+		t.Errorf("want %#v\ngot %#v", c, got)
+	}
+	txt, err := ioutil.ReadFile(fp.Join(dir, "SN123_erasure_20200304T080607Z.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SN123", "9XG0ABCD", "ATA enhanced secure erase (purge)", "0 of 931 canaries remain", "verify canary"} {
+		if !bytes.Contains(txt, []byte(want)) {
+			t.Errorf("text version lacks %q:\n%s", want, txt)
+		}
+	}
+
+	//tampering is detected
+	if err = ioutil.WriteFile(js, bytes.Replace(data, []byte("SN123"), []byte("SN124"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = sig.VerifyFile(js, keyring); err == nil {
+		t.Error("modified certificate verified")
+	}
+
+	//without a key, the certificate is unsigned
+	files, err = c.write("", certKey(fp.Join(tmp, "nonexistent")))
+	if err != nil || len(files) != 2 {
+		t.Errorf("want json and text only, got %d files (err %v)", len(files), err)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	fp "path/filepath"
+	"sync"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/erase/raid"
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+/* Erase progress is checkpointed to the recovery volume, so that an erase
+   interrupted by power loss can be resumed on the next boot (see Interrupted)
+   rather than leaving the unit partially erased.
+
+   The checkpoint is created before anything is erased and removed once the
+   certificate is issued. After an unrecoverable failure it is kept, marked
+   Failed, so that every boot shows the failure rather than erasing again; it
+   is only cleared when the unit is re-provisioned (see ClearCheckpoint).
+   Per disk, it holds the raid metadata backup (which
+   is gone from disk once erase starts), the number of canaries written by
+   prepare, and the erase method. For overwrite, the pass and offset are
+   recorded every checkpointEvery bytes; drive commands (ATA secure erase,
+   NVMe sanitize/format) are simply issued again.
+
+   The canary pattern is kept too, since it includes the time erase began, as
+   is the erase policy, which determines the overwrite passes.
+*/
+
+const checkpointName = "erase_checkpoint.json"
+
+var (
+	//how often, in bytes written, overwrite records its progress
+	checkpointEvery int64 = oneG
+	//size of each write during overwrite
+	overwriteChunk = 4 * oneM
+)
+
+type checkpoint struct {
+	Pattern     string //canary pattern written by prepare
+	Policy      appliance.ErasePolicy
+	Start       time.Time
+	CryptErased []string `json:",omitempty"`
+	Failed      bool     `json:",omitempty"` //unrecoverable failure; don't retry
+	Disks       []*diskProgress
+
+	path string
+	mu   sync.Mutex
+}
+
+type diskProgress struct {
+	Record       DiskRecord //Canaries is 0 until prepare completes
+	RaidType     string     `json:",omitempty"`
+	RaidMetadata []byte     `json:",omitempty"`
+	Pass         int        //overwrite pass in progress
+	Offset       int64      //overwrite offset within pass
+	Erased       bool       //erase complete, verify pending
+}
+
+//current checkpoint, so that unrecoverableFailure can mark it
+var curCheckpoint *checkpoint
+
+func checkpointPath(recovRoot string) string {
+	return fp.Join(recovRoot, strs.RecoveryLogDir(), checkpointName)
+}
+
+//Interrupted returns true if the recovery volume mounted at recovRoot has the
+//checkpoint of an erase that has not finished. Call Main to resume it.
+func Interrupted(recovRoot string) bool {
+	_, err := os.Stat(checkpointPath(recovRoot))
+	return err == nil
+}
+
+//ClearCheckpoint removes any erase checkpoint, including a failed one, from the
+//recovery volume mounted at recovRoot. For use when re-provisioning a unit
+//without recreating its recovery volume.
+func ClearCheckpoint(recovRoot string) error {
+	err := os.Remove(checkpointPath(recovRoot))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//return the checkpoint of an interrupted erase, or nil if there is none
+func loadCheckpoint(recovRoot string) (*checkpoint, error) {
+	if recovRoot == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(checkpointPath(recovRoot))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{path: checkpointPath(recovRoot)}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+//new checkpoint for an erase starting now. If recovRoot is empty, nothing is
+//saved.
+func newCheckpoint(recovRoot string) *checkpoint {
+	cp := &checkpoint{
+		Pattern: prepPattern,
+		Policy:  curPolicy,
+		Start:   time.Now(),
+	}
+	if recovRoot != "" {
+		cp.path = checkpointPath(recovRoot)
+	}
+	return cp
+}
+
+//apply fn to the checkpoint and save it. fn may be nil.
+func (cp *checkpoint) update(fn func()) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+	if cp.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(fp.Dir(cp.path), 0755)
+	}
+	if err == nil {
+		err = futil.WriteFileAtomic(cp.path, data, 0644)
+	}
+	if err != nil {
+		log.Logf("saving erase checkpoint: %s", err)
+	}
+}
+
+func (cp *checkpoint) remove() {
+	if cp.path == "" {
+		return
+	}
+	if err := os.Remove(cp.path); err != nil {
+		log.Logf("removing erase checkpoint: %s", err)
+	}
+}
+
+//find the progress of the given disk, adding it if not present. Disks are
+//matched by serial where possible, as names may change between boots.
+func (cp *checkpoint) disk(dev, model, serial string, size uint64) (dp *diskProgress) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, p := range cp.Disks {
+		if (serial != "" && p.Record.Serial == serial) || (serial == "" && p.Record.Dev == dev) {
+			p.Record.Dev = dev
+			return p
+		}
+	}
+	dp = &diskProgress{Record: DiskRecord{Dev: dev, Model: model, Serial: serial, Size: size}}
+	cp.Disks = append(cp.Disks, dp)
+	return
+}
+
+//find the progress of d, setting its raid metadata if saved
+func (cp *checkpoint) device(d *raid.Device) *diskProgress {
+	size, _ := d.ReadSize()
+	var rec DiskRecord
+	rec.identify(d.Dev(), size)
+	dp := cp.disk(rec.Dev, rec.Model, rec.Serial, rec.Size)
+	if dp.RaidType != "" {
+		if err := d.SetMetadata(dp.RaidType, dp.RaidMetadata); err != nil {
+			log.Logf("%s: restoring saved metadata: %s", d.Dev(), err)
+		}
+	}
+	return dp
+}
+
+//certificate for the erase recorded in the checkpoint
+func (cp *checkpoint) certificate() *Certificate {
+	c := &Certificate{
+		Serial:      Platform.SerNum(),
+		Codename:    Platform.DeviceCodeName(),
+		Start:       cp.Start,
+		CryptErased: cp.CryptErased,
+		Policy:      cp.Policy,
+	}
+	for _, dp := range cp.Disks {
+		c.Disks = append(c.Disks, dp.Record)
+	}
+	return c
+}
+
+//write each pattern over dev, which is size bytes, resuming from the pass and
+//offset in dp and recording progress in cp and ds
+func overwritePasses(dev io.WriteSeeker, size int64, cp *checkpoint, dp *diskProgress, ds *diskStatus) error {
+	buf := make([]byte, overwriteChunk)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	total := uint64(size) * uint64(curPolicy.Passes)
+	ds.written(uint64(int64(dp.Pass)*size+dp.Offset), total)
+	for p := dp.Pass; p < curPolicy.Passes; p++ {
+		pat := passPattern(curPolicy, p)
+		_, fixed := patternByte(pat)
+		fillPattern(buf, pat, rng)
+		var offs int64
+		if p == dp.Pass {
+			offs = dp.Offset
+		}
+		if _, err := dev.Seek(offs, io.SeekStart); err != nil {
+			return err
+		}
+		last := offs
+		for offs < size {
+			n := int64(len(buf))
+			if size-offs < n {
+				n = size - offs
+			}
+			if !fixed {
+				fillPattern(buf[:n], pat, rng)
+			}
+			w, err := dev.Write(buf[:n])
+			offs += int64(w)
+			ds.written(uint64(int64(p)*size+offs), total)
+			if err != nil {
+				return err
+			}
+			if offs-last >= checkpointEvery {
+				cp.update(func() { dp.Pass, dp.Offset = p, offs })
+				last = offs
+			}
+		}
+		cp.update(func() { dp.Pass, dp.Offset = p+1, 0 })
+	}
+	return nil
+}
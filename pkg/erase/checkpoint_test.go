@@ -0,0 +1,139 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+var errPowerLoss = errors.New("power lost")
+
+//file-backed fake disk. Writes fail once limit bytes have been written, if
+//limit is positive.
+type fakeDisk struct {
+	f       *os.File
+	limit   int64
+	written int64
+}
+
+func (d *fakeDisk) Seek(offs int64, whence int) (int64, error) { return d.f.Seek(offs, whence) }
+
+func (d *fakeDisk) Write(b []byte) (int, error) {
+	if d.limit > 0 && d.written+int64(len(b)) > d.limit {
+		n, _ := d.f.Write(b[:d.limit-d.written])
+		d.written += int64(n)
+		return n, errPowerLoss
+	}
+	n, err := d.f.Write(b)
+	d.written += int64(n)
+	return n, err
+}
+
+func TestCheckpointResume(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	defer func(e int64, c int) { checkpointEvery, overwriteChunk = e, c }(checkpointEvery, overwriteChunk)
+	checkpointEvery = 2 * oneM
+	overwriteChunk = oneM
+
+	tmp, err := ioutil.TempDir("", "gp-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	recov := fp.Join(tmp, "recov")
+	size := int64(5*oneM + 512)
+	f, err := os.Create(fp.Join(tmp, "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(bytes.Repeat([]byte{0xee}, int(size))); err != nil {
+		t.Fatal(err)
+	}
+
+	if Interrupted(recov) {
+		t.Error("interrupted before erase began")
+	}
+	cp := newCheckpoint(recov)
+	dp := cp.disk("/dev/sda", "model", "SER1", uint64(size))
+	cp.update(func() { dp.Record.Canaries = 5 })
+	if !Interrupted(recov) {
+		t.Error("erase in progress not detected")
+	}
+
+	//power is lost part way through the third pass
+	disk := &fakeDisk{f: f, limit: 2*size + 3*oneM + 100}
+	ds := newTracker().add("/dev/sda")
+	if err = overwritePasses(disk, size, cp, dp, ds); err != errPowerLoss {
+		t.Fatalf("want power loss, got %v", err)
+	}
+
+	//next boot; disk names changed
+	cp, err = loadCheckpoint(recov)
+	if err != nil || cp == nil {
+		t.Fatalf("loading checkpoint: %v", err)
+	}
+	if cp.Pattern != prepPattern {
+		t.Errorf("want pattern %q, got %q", prepPattern, cp.Pattern)
+	}
+	dp = cp.disk("/dev/sdb", "model", "SER1", uint64(size))
+	if len(cp.Disks) != 1 || dp.Record.Dev != "/dev/sdb" || dp.Record.Canaries != 5 {
+		t.Errorf("disk not matched by serial: %#v", cp.Disks)
+	}
+	if dp.Pass != 2 || dp.Offset != 2*oneM {
+		t.Errorf("want pass 2 offset %d, got pass %d offset %d", 2*oneM, dp.Pass, dp.Offset)
+	}
+	disk = &fakeDisk{f: f}
+	ds = newTracker().add("/dev/sdb")
+	if err = overwritePasses(disk, size, cp, dp, ds); err != nil {
+		t.Fatal(err)
+	}
+	if r := ds.report(time.Now()); r.Done != r.Total || r.Total != uint64(size)*uint64(curPolicy.Passes) {
+		t.Errorf("want %d of %d bytes done, got %d of %d", r.Total, r.Total, r.Done, r.Total)
+	}
+	if want := 2*size - 2*oneM; disk.written != want {
+		t.Errorf("resumed erase wrote %d bytes, want %d", disk.written, want)
+	}
+	if dp.Pass != curPolicy.Passes {
+		t.Errorf("want all passes done, got pass %d", dp.Pass)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size || !bytes.Equal(data, make([]byte, size)) {
+		t.Error("disk not overwritten with final pattern")
+	}
+
+	cp.remove()
+	if Interrupted(recov) {
+		t.Error("checkpoint remains after removal")
+	}
+
+	//a failed checkpoint remains until cleared
+	cp = newCheckpoint(recov)
+	cp.update(func() { cp.Failed = true })
+	if !Interrupted(recov) {
+		t.Error("failed erase not detected")
+	}
+	if err = ClearCheckpoint(recov); err != nil || Interrupted(recov) {
+		t.Errorf("clearing checkpoint: %v", err)
+	}
+	if err = ClearCheckpoint(recov); err != nil {
+		t.Errorf("clearing missing checkpoint: %s", err)
+	}
+}
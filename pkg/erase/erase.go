@@ -7,8 +7,10 @@
 
 // Package erase handles data erase of data on units, for use when a
 // customer's data is sufficiently sensitive and they need to ship a unit
-// without data. It uses the drive's ATA SECURE ERASE command when available,
-// falling back to writing pattterns if that command cannot be used.
+// without data. It uses the drive's ATA SECURE ERASE command, or for NVMe
+// drives the Sanitize or Format NVM command, when available, falling back to
+// writing pattterns if that command cannot be used. If the
+// primary volume is encrypted, its keys are destroyed first.
 //
 // Before erase, canary values are written at predetermined points on disk.
 // After erase, it reads the locations those values were written, verifying
@@ -21,12 +23,24 @@
 // until it has been RMA'd, QA'd, and re-imaged. This is by design, to ensure
 // the problem is resolved; after all, the customer's data was sensitive enough
 // to warrant a multi-hour erase process.
+//
+// Progress is checkpointed to the recovery volume, so that an erase interrupted
+// by power loss resumes where it left off on the next boot.
+//
+// The methods tried, the overwrite patterns, and how much of each disk is read
+// back are selected by the erase policy; see appliance.ErasePolicy.
+//
+// On success, a signed certificate of erasure is written to the recovery
+// volume and sent to the RecordKeeper. The signing key ships in every
+// initramfs, so anyone with a unit can extract it: the signature shows that a
+// certificate has not been altered since it was written (integrity), not that
+// it was written by a genuine unit (authenticity). Where authenticity matters,
+// rely on the copy received by the RecordKeeper.
 package erase
 
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	fp "path/filepath"
@@ -37,6 +51,8 @@
 	"github.com/purecloudlabs/gprovision/pkg/common"
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/erase/raid"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/luks"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/md"
 	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
 	"github.com/purecloudlabs/gprovision/pkg/hw/udev"
 	hk "github.com/purecloudlabs/gprovision/pkg/init/housekeeping"
@@ -46,9 +62,10 @@
 	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
 )
 
-const (
-	nrOverwritePatterns = 3
-)
+//method recorded for overwrite
+func overwriteMethod() string {
+	return fmt.Sprintf("overwrite, %d passes", curPolicy.Passes)
+}
 
 var s cfa.Spinner
 
@@ -112,6 +129,35 @@ func Erase(recov common.FS) {
 	}
 	log.FlushMemLog()
 
+	recovRoot := ""
+	if recov.IsMounted() {
+		recovRoot = recov.Path()
+	}
+	cp, err := loadCheckpoint(recovRoot)
+	if err != nil {
+		log.Logf("reading erase checkpoint: %s", err)
+	}
+	s.Msg = "Erasing..."
+	if cp != nil {
+		log.Msg("Resuming erase...")
+		s.Msg = "Resuming erase..."
+		prepPattern = cp.Pattern
+		curPolicy = withDefaults(cp.Policy)
+		log.Logf("erase policy from checkpoint: %s", describePolicy(curPolicy))
+		curCheckpoint = cp
+		if cp.Failed {
+			unrecoverableFailure(recov, false)
+		}
+	} else {
+		curPolicy = loadPolicy(recovRoot, Platform)
+		cp = newCheckpoint(recovRoot)
+		curCheckpoint = cp
+		cp.update(nil)
+	}
+
+	erased := cryptErase()
+	cp.update(func() { cp.CryptErased = append(cp.CryptErased, erased...) })
+
 	log.Msg("Data erase: locating drives...")
 
 	//find all disks
@@ -119,6 +165,11 @@ func Erase(recov common.FS) {
 	if err != nil {
 		log.Logf("raid.FindDevices: err %s", err)
 	}
+	//on resume, this restores raid metadata saved before erase began
+	progress := make(map[*raid.Device]*diskProgress)
+	for _, d := range devs {
+		progress[d] = cp.device(d)
+	}
 	//sort disks into potential arrays, based on size/metadata type
 	arrs := raid.FindArrays(devs)
 	for i, a := range arrs {
@@ -133,31 +184,36 @@ func Erase(recov common.FS) {
 		log.Logf("wrong number of drives. Want %d, got %d", Platform.DataDisks(), a.Len())
 		unrecoverableFailure(recov, true)
 	}
-	err = a.Backup()
-	if err != nil {
-		log.Msg("error creating backup")
-		log.Logf("Array.Backup: err %s", err)
-		//exit? retry?
+	for _, d := range a.Devices() {
+		dp := progress[d]
+		if dp.RaidType != "" {
+			continue
+		}
+		err = d.Backup()
+		if err != nil {
+			log.Msg("error creating backup")
+			log.Logf("%s: Backup: err %s", d.Dev(), err)
+			//exit? retry?
+		}
+		typ, data := d.Metadata()
+		cp.update(func() { dp.RaidType, dp.RaidMetadata = typ, data })
 	}
 
 	var wg sync.WaitGroup
 
-	s.Msg = "Erasing..."
 	s.Lcd = cfa.DefaultLcd
 	_ = s.Display()
 
-	eraseCh := make(chan time.Duration, 2)
-	defer close(eraseCh)
-	go eraseStatus(eraseCh, 4*time.Hour, &s)
+	t := newTracker()
+	statusDone := make(chan struct{})
+	go eraseStatus(t, statusDone, &s)
 
-	for _, a := range arrs {
-		for _, d := range a.Devices() {
-			wg.Add(1)
-			go eraseDisk(d, &wg, eraseCh, recov)
-		}
+	for _, d := range a.Devices() {
+		wg.Add(1)
+		go eraseDisk(d, progress[d], cp, t.add(d.Dev()), &wg, recov)
 	}
 	wg.Wait()
-	close(eraseCh)
+	close(statusDone)
 
 	log.Msg("Writing RAID config...")
 	for i, a := range arrs {
@@ -166,9 +222,37 @@ func Erase(recov common.FS) {
 			log.Logf("failed to re-write array data for %d: %s", i, e)
 		}
 	}
+	cp.certificate().issue(recovRoot)
+	cp.remove()
 	success(recov)
 }
 
+//If the primary volume is encrypted, destroying the LUKS keyslots renders it
+//unrecoverable before the (much slower) disk erase even starts. Arrays are
+//assembled so that containers on them can be found, then stopped again.
+//Returns the devices whose keys were destroyed.
+func cryptErase() (erased []string) {
+	md.AssembleScan()
+	if luks.IsActive(luks.RootName) {
+		if err := luks.Close(luks.RootName); err != nil {
+			log.Logf("closing %s: %s", luks.RootName, err)
+		}
+	}
+	for _, dev := range luks.Find() {
+		if err := luks.Erase(dev); err != nil {
+			log.Logf("%s: cryptographic erase failed: %s", dev, err)
+			continue
+		}
+		log.Msgf("%s: encryption keys destroyed", dev)
+		erased = append(erased, dev)
+	}
+	stop := exec.Command("mdadm", "--stop", "--scan")
+	if out, err := stop.CombinedOutput(); err != nil {
+		log.Logf("error stopping array(s): %s\nout:\n%s\n", err, out)
+	}
+	return
+}
+
 /*hdparm -I output
  *
 ...
@@ -183,90 +267,158 @@ func Erase(recov common.FS) {
        274min for SECURITY ERASE UNIT. 274min for ENHANCED SECURITY ERASE UNIT.
 *
 */
-func eraseDisk(d *raid.Device, wg *sync.WaitGroup, eraseCh chan<- time.Duration, recov common.FS) {
+//eraseDisk prepares, erases, and verifies d. When resuming, prepare is skipped
+//if it completed, as is erase. An interrupted overwrite continues from its
+//checkpoint; a drive command is issued again.
+func eraseDisk(d *raid.Device, dp *diskProgress, cp *checkpoint, ds *diskStatus, wg *sync.WaitGroup, recov common.FS) {
 	defer wg.Done()
-	prepare(d, recov)
+	defer ds.finish()
+	rec := &dp.Record
+	if rec.Canaries == 0 {
+		n := prepare(d, recov)
+		cp.update(func() { rec.Canaries = n })
+	} else {
+		log.Logf("%s: resuming erase", d.Dev())
+	}
 	d.Close() //not sure what happens if we have an open fd when running hdparm
-	if tryhdp(d, eraseCh) != nil {
-		overwrite(d, eraseCh)
+	if !dp.Erased {
+		if rec.Start.IsZero() {
+			cp.update(func() { rec.Start = time.Now() })
+		}
+		if rec.Category != sanitizeClear {
+			var method string
+			var err error
+			switch {
+			case isNVMe(d.Dev()) && (allowed(curPolicy, appliance.EraseSanitize) || allowed(curPolicy, appliance.EraseFormat)):
+				method, err = tryNVMe(d, ds)
+			case !isNVMe(d.Dev()) && allowed(curPolicy, appliance.EraseATA):
+				method, err = tryhdp(d, ds)
+			default:
+				log.Logf("%s: no drive command allowed by erase policy", d.Dev())
+				err = fmt.Errorf("not allowed")
+			}
+			cp.update(func() {
+				if err == nil {
+					rec.Method, rec.Category = method, sanitizePurge
+				} else {
+					rec.Method, rec.Category = overwriteMethod(), sanitizeClear
+				}
+			})
+		}
+		if rec.Category == sanitizeClear {
+			overwrite(d, dp, cp, ds)
+		}
+		cp.update(func() { dp.Erased, rec.End = true, time.Now() })
+	}
+	remaining := verify(d, recov)
+	cp.update(func() { rec.CanariesRemaining = remaining })
+	if curPolicy.Verify != appliance.VerifyCanary {
+		bad := readBackDisk(d, rec.Category == sanitizeClear, ds)
+		cp.update(func() { rec.ReadBack, rec.ReadBackFailures = describeVerify(curPolicy), bad })
+		if bad != 0 {
+			log.Logf("%s: %d chunks failed read-back", d.Dev(), bad)
+			unrecoverableFailure(recov, true)
+		}
+	}
+}
+
+//read back part or all of d per the policy. If d was overwritten with a
+//fixed final pattern, that pattern must be all that remains.
+func readBackDisk(d *raid.Device, overwritten bool, ds *diskStatus) int {
+	percent := 100
+	if curPolicy.Verify == appliance.VerifySample {
+		percent = curPolicy.VerifyPercent
 	}
-	verify(d, recov)
+	var fill *byte
+	if overwritten {
+		if b, fixed := patternByte(passPattern(curPolicy, curPolicy.Passes-1)); fixed {
+			fill = &b
+		}
+	}
+	size, err := d.ReadSize()
+	if err != nil {
+		log.Logf("%s: reading size: %s", d.Dev(), err)
+		return 1
+	}
+	dev, err := d.Open()
+	if err != nil {
+		log.Logf("%s: open for read-back: %s", d.Dev(), err)
+		return 1
+	}
+	log.Logf("%s: read-back, %s", d.Dev(), describeVerify(curPolicy))
+	ds.begin("read-back")
+	return readBack(dev, int64(size), percent, fill, ds)
 }
 
 // overwrite all data on disk with various patterns
-// use if hdparm fails
-func overwrite(d *raid.Device, eraseCh chan<- time.Duration) {
-	log.Logf("%s: pattern overwrite", d.Dev())
-	buf := make([]byte, 4096*1024)
-	eraseCh <- 12 * time.Hour
-	data := bytes.NewReader(buf)
+// use if hdparm or nvme fails
+func overwrite(d *raid.Device, dp *diskProgress, cp *checkpoint, ds *diskStatus) {
+	log.Logf("%s: pattern overwrite from pass %d offset %d", d.Dev(), dp.Pass, dp.Offset)
+	ds.begin(overwriteMethod())
+	size, err := d.ReadSize()
+	if err != nil {
+		log.Logf("%s: reading size: %s", d.Dev(), err)
+		return
+	}
 	dev, err := d.Open()
 	if err != nil {
+		log.Logf("%s: open: %s", d.Dev(), err)
 		return
 	}
-	//loop over erase patterns
-	var p int
-	var count int
-	for p = 0; p <= nrOverwritePatterns; p++ {
-		fillPattern(buf, p)
-		//loop until entire device has been overwritten
-		if _, err := dev.Seek(0, 0); err != nil {
-			log.Logf("dev seek: %s", err)
-		}
-		for {
-			count++
-			if _, err := data.Seek(0, 0); err != nil {
-				log.Logf("data seek: %s", err)
-			}
-			_, err := io.Copy(dev, data)
-			if err != nil {
-				log.Logf("%s: stopping with %s", d.Dev(), err)
-				break
-			}
-		}
+	if err = overwritePasses(dev, int64(size), cp, dp, ds); err != nil {
+		log.Logf("%s: stopping with %s", d.Dev(), err)
 	}
 }
 
 //use hdparm + ATA SECURE ERASE to erase the disk
-func tryhdp(d *raid.Device, eraseCh chan<- time.Duration) error {
+func tryhdp(d *raid.Device, ds *diskStatus) (method string, err error) {
 	log.Logf("%s: trying ATA SECURE ERASE command", d.Dev())
 	drvInfo := exec.Command("hdparm", "-I", d.Dev())
 	info, err := tryExec(drvInfo, 10, 2*time.Second)
 	if err != nil {
 		log.Logf("error %s executing %#v\noutput:\n%s", err, drvInfo.Args, string(info))
-		return err
+		return
 	}
 	froz := getLine(info, "frozen")
 	supported := bytes.Contains(froz, []byte("not"))
 	if !supported {
 		log.Logf("%s: ATA SECURE ERASE command isn't supported: %s", d.Dev(), info)
-		return fmt.Errorf("unsupported")
+		err = fmt.Errorf("unsupported")
+		return
 	}
 
 	eraseOpt := getLine(info, "enhanced erase")
 	enhancedErase := (len(eraseOpt) > 20) && !bytes.Contains(eraseOpt, []byte("not"))
 
-	//find time data in 'info', communicate it to lcd update thread
-	eraseCh <- getSEtime(info, enhancedErase)
-
-	setPass := exec.Command("hdparm", "--security-set-pass", "fsadfsfd", d.Dev())
-	out, err := tryExec(setPass, 10, 2*time.Second)
-	if err != nil {
-		log.Logf("error %s executing %#v\noutput:\n%s", err, setPass.Args, string(out))
-		return err
+	var out []byte
+	if bytes.Contains(getLine(info, "locked"), []byte("not")) {
+		setPass := exec.Command("hdparm", "--security-set-pass", "fsadfsfd", d.Dev())
+		out, err = tryExec(setPass, 10, 2*time.Second)
+		if err != nil {
+			log.Logf("error %s executing %#v\noutput:\n%s", err, setPass.Args, string(out))
+			return
+		}
+	} else {
+		//erase was interrupted; password is already set
+		log.Logf("%s: drive locked, erasing with existing password", d.Dev())
 	}
 	var eraseArg string
 	if enhancedErase {
 		eraseArg = "--security-erase-enhanced"
+		method = "ATA enhanced secure erase"
 	} else {
 		eraseArg = "--security-erase"
+		method = "ATA secure erase"
 	}
+	ds.begin(method)
+	//find time data in 'info', communicate it to lcd update thread
+	ds.estimate(getSEtime(info, enhancedErase))
 	erase := exec.Command("hdparm", eraseArg, "fsadfsfd", d.Dev())
 	out, err = tryExec(erase, 10, 2*time.Second)
 	if err != nil {
 		log.Logf("error '%s' executing %#v\noutput:\n%s", err, erase.Args, string(out))
 	}
-	return err
+	return
 }
 
 //hdparm seems to fail intermittently, so try to run it several times
@@ -0,0 +1,272 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/erase/raid"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+/* NVMe drives are erased with the drive's own Sanitize or Format NVM command,
+   via nvme-cli. Output is requested in binary form, as the layout of the
+   identify and log structures is fixed by the spec while nvme-cli's json
+   output varies between versions.
+
+   Sanitize runs in the background on the drive; its progress is polled from
+   the sanitize log. Format NVM doesn't return until it is done.
+*/
+
+type nvmeMethod int
+
+const (
+	sanitizeCrypto nvmeMethod = iota
+	sanitizeBlock
+	sanitizeOverwrite
+	formatCrypto
+	formatUserData
+
+	sanitizeResumed //sanitize of unknown type, in progress at startup
+)
+
+func (m nvmeMethod) String() string {
+	switch m {
+	case sanitizeCrypto:
+		return "sanitize (crypto erase)"
+	case sanitizeBlock:
+		return "sanitize (block erase)"
+	case sanitizeOverwrite:
+		return "sanitize (overwrite)"
+	case formatCrypto:
+		return "format (crypto erase)"
+	case formatUserData:
+		return "format (user data erase)"
+	case sanitizeResumed:
+		return "sanitize (resumed)"
+	}
+	return fmt.Sprintf("nvmeMethod(%d)", int(m))
+}
+
+func (m nvmeMethod) isSanitize() bool { return m <= sanitizeOverwrite }
+
+//nvme-cli args to erase dev with method m
+func (m nvmeMethod) args(dev string) []string {
+	switch m {
+	case sanitizeCrypto:
+		return []string{"sanitize", dev, "--sanact=4"}
+	case sanitizeBlock:
+		return []string{"sanitize", dev, "--sanact=2"}
+	case sanitizeOverwrite:
+		//default pass count (0) means 16 passes
+		return []string{"sanitize", dev, "--sanact=3", "--owpass=1"}
+	case formatCrypto:
+		return []string{"format", dev, "--ses=2"}
+	}
+	return []string{"format", dev, "--ses=1"}
+}
+
+//capabilities from the identify controller structure
+type nvmeCaps struct {
+	format       bool   //OACS bit 1
+	formatCrypto bool   //FNA bit 2
+	sanicap      uint32 //bit 0 crypto, bit 1 block, bit 2 overwrite
+}
+
+const idCtrlMinLen = 525 //through FNA
+
+func parseIDCtrl(id []byte) (c nvmeCaps, err error) {
+	if len(id) < idCtrlMinLen {
+		err = fmt.Errorf("identify controller: short read (%d bytes)", len(id))
+		return
+	}
+	c.format = binary.LittleEndian.Uint16(id[256:])&0x2 != 0
+	c.formatCrypto = id[524]&0x4 != 0
+	c.sanicap = binary.LittleEndian.Uint32(id[328:])
+	return
+}
+
+//supported methods, in order of preference. All sanitize operations are
+//preferred to format, as they also erase caches and unallocated areas.
+func (c nvmeCaps) methods() (m []nvmeMethod) {
+	for i, meth := range []nvmeMethod{sanitizeCrypto, sanitizeBlock, sanitizeOverwrite} {
+		if c.sanicap&(1<<uint(i)) != 0 {
+			m = append(m, meth)
+		}
+	}
+	if c.format {
+		if c.formatCrypto {
+			m = append(m, formatCrypto)
+		}
+		m = append(m, formatUserData)
+	}
+	return
+}
+
+//values of the low 3 bits of SSTAT
+const (
+	sstatNever         = 0
+	sstatDone          = 1
+	sstatInProgress    = 2
+	sstatFailed        = 3
+	sstatDoneNoDealloc = 4
+)
+
+type sanitizeLog struct {
+	progress uint16    //SPROG; fraction complete, out of 65536
+	status   uint16    //SSTAT
+	est      [3]uint32 //estimated seconds for overwrite, block, crypto
+}
+
+const sanitizeLogMinLen = 20
+
+func parseSanitizeLog(b []byte) (l sanitizeLog, err error) {
+	if len(b) < sanitizeLogMinLen {
+		err = fmt.Errorf("sanitize log: short read (%d bytes)", len(b))
+		return
+	}
+	l.progress = binary.LittleEndian.Uint16(b)
+	l.status = binary.LittleEndian.Uint16(b[2:])
+	for i := range l.est {
+		l.est[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	return
+}
+
+//estimate total duration of sanitize with method m, which has been running
+//for elapsed. Uses the drive's estimate if it has one.
+func (l sanitizeLog) estimate(m nvmeMethod, elapsed time.Duration) time.Duration {
+	var e uint32
+	switch m {
+	case sanitizeOverwrite:
+		e = l.est[0]
+	case sanitizeBlock:
+		e = l.est[1]
+	case sanitizeCrypto:
+		e = l.est[2]
+	}
+	if e != 0 && e != 0xffffffff {
+		return time.Duration(e) * time.Second
+	}
+	if l.progress == 0 {
+		return 0
+	}
+	return elapsed * 65536 / time.Duration(l.progress)
+}
+
+//nvmeRun runs nvme-cli, returning stdout. Replaced in tests.
+var nvmeRun = func(args ...string) ([]byte, error) {
+	cmd := exec.Command("nvme", args...)
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok {
+		err = fmt.Errorf("%v: %s\n%s", cmd.Args, err, ee.Stderr)
+	}
+	return out, err
+}
+
+var (
+	sanitizePoll    = 10 * time.Second
+	sanitizeTimeout = 24 * time.Hour
+)
+
+func isNVMe(dev string) bool { return strings.HasPrefix(fp.Base(dev), "nvme") }
+
+//use NVMe sanitize or format to erase the disk, trying each supported method
+//in turn. Returns the method used.
+func tryNVMe(d *raid.Device, ds *diskStatus) (method string, err error) {
+	log.Logf("%s: trying NVMe sanitize/format", d.Dev())
+	id, err := nvmeRun("id-ctrl", d.Dev(), "--raw-binary")
+	if err != nil {
+		log.Logf("%s: identify controller: %s", d.Dev(), err)
+		return
+	}
+	caps, err := parseIDCtrl(id)
+	if err != nil {
+		log.Logf("%s: %s", d.Dev(), err)
+		return
+	}
+	//sanitize continues after power loss, and other commands are rejected
+	//until it completes
+	if sl, e := readSanitizeLog(d.Dev()); e == nil && sl.status&0x7 == sstatInProgress {
+		log.Logf("%s: sanitize already in progress, waiting", d.Dev())
+		ds.begin("NVMe " + sanitizeResumed.String())
+		if err = waitSanitize(d.Dev(), sanitizeResumed, ds); err == nil {
+			method = "NVMe " + sanitizeResumed.String()
+			return
+		}
+		log.Logf("%s: sanitize failed: %s", d.Dev(), err)
+	}
+	methods := nvmeOrder(curPolicy, caps.methods())
+	if len(methods) == 0 {
+		log.Logf("%s: no supported sanitize or format method allowed by erase policy", d.Dev())
+		err = fmt.Errorf("unsupported")
+		return
+	}
+	for _, m := range methods {
+		log.Logf("%s: %s", d.Dev(), m)
+		ds.begin("NVMe " + m.String())
+		err = nvmeErase(d.Dev(), m, ds)
+		if err == nil {
+			method = "NVMe " + m.String()
+			return
+		}
+		log.Logf("%s: %s failed: %s", d.Dev(), m, err)
+	}
+	return
+}
+
+func nvmeErase(dev string, m nvmeMethod, ds *diskStatus) error {
+	if _, err := nvmeRun(m.args(dev)...); err != nil {
+		return err
+	}
+	if !m.isSanitize() {
+		return nil
+	}
+	return waitSanitize(dev, m, ds)
+}
+
+func readSanitizeLog(dev string) (sanitizeLog, error) {
+	raw, err := nvmeRun("sanitize-log", dev, "--raw-binary")
+	if err != nil {
+		return sanitizeLog{}, err
+	}
+	return parseSanitizeLog(raw)
+}
+
+//poll the sanitize log until the operation completes, feeding progress to ds
+func waitSanitize(dev string, m nvmeMethod, ds *diskStatus) error {
+	start := time.Now()
+	for time.Since(start) < sanitizeTimeout {
+		time.Sleep(sanitizePoll)
+		sl, err := readSanitizeLog(dev)
+		if err != nil {
+			return err
+		}
+		switch sl.status & 0x7 {
+		case sstatInProgress:
+			ds.progress(float64(sl.progress) / 65536)
+			ds.estimate(sl.estimate(m, time.Since(start)))
+		case sstatDone, sstatDoneNoDealloc:
+			return nil
+		case sstatFailed:
+			//the drive only allows limited commands until failure mode is exited
+			if _, err := nvmeRun("sanitize", dev, "--sanact=1"); err != nil {
+				log.Logf("%s: exiting sanitize failure mode: %s", dev, err)
+			}
+			return fmt.Errorf("sanitize failed")
+		default:
+			return fmt.Errorf("sanitize not started (status %#x)", sl.status)
+		}
+	}
+	return fmt.Errorf("sanitize timed out after %s", sanitizeTimeout)
+}
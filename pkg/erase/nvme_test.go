@@ -0,0 +1,190 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/erase/raid"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func idCtrl(oacs uint16, sanicap uint32, fna byte) []byte {
+	id := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(id[256:], oacs)
+	binary.LittleEndian.PutUint32(id[328:], sanicap)
+	id[524] = fna
+	return id
+}
+
+func sanLog(progress, status uint16) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint16(b, progress)
+	binary.LittleEndian.PutUint16(b[2:], status)
+	for i := 8; i < 20; i++ {
+		b[i] = 0xff //no estimates
+	}
+	return b
+}
+
+//fake nvme device; replies to sanitize-log are consumed in order
+type fakeNVMe struct {
+	id      []byte
+	sanLogs [][]byte
+	fail    map[string]bool //commands (joined args) that fail
+	cmds    []string
+}
+
+func (f *fakeNVMe) run(args ...string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	f.cmds = append(f.cmds, cmd)
+	if f.fail[cmd] {
+		return nil, fmt.Errorf("fake failure")
+	}
+	switch args[0] {
+	case "id-ctrl":
+		return f.id, nil
+	case "sanitize-log":
+		if len(f.sanLogs) == 0 {
+			return nil, fmt.Errorf("no more logs")
+		}
+		l := f.sanLogs[0]
+		f.sanLogs = f.sanLogs[1:]
+		return l, nil
+	}
+	return nil, nil
+}
+
+func TestNVMeMethods(t *testing.T) {
+	for i, td := range []struct {
+		id   []byte
+		want []nvmeMethod
+	}{
+		{idCtrl(0, 0, 0), nil},
+		{idCtrl(0x2, 0, 0), []nvmeMethod{formatUserData}},
+		{idCtrl(0x2, 0, 0x4), []nvmeMethod{formatCrypto, formatUserData}},
+		{idCtrl(0, 0x6, 0x4), []nvmeMethod{sanitizeBlock, sanitizeOverwrite}},
+		{idCtrl(0x2, 0x7, 0), []nvmeMethod{sanitizeCrypto, sanitizeBlock, sanitizeOverwrite, formatUserData}},
+	} {
+		c, err := parseIDCtrl(td.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.methods(); !reflect.DeepEqual(got, td.want) {
+			t.Errorf("%d: want %v, got %v", i, td.want, got)
+		}
+	}
+	if _, err := parseIDCtrl(make([]byte, 100)); err == nil {
+		t.Error("want error for short identify data")
+	}
+}
+
+func TestSanitizeEstimate(t *testing.T) {
+	l, err := parseSanitizeLog(sanLog(16384, sstatInProgress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := l.estimate(sanitizeBlock, time.Minute); e != 4*time.Minute {
+		t.Errorf("want estimate from progress of 4m, got %s", e)
+	}
+	l.est[1] = 600
+	if e := l.estimate(sanitizeBlock, time.Minute); e != 10*time.Minute {
+		t.Errorf("want drive's estimate of 10m, got %s", e)
+	}
+}
+
+func TestTryNVMe(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	defer func(r func(...string) ([]byte, error), p time.Duration) {
+		nvmeRun, sanitizePoll = r, p
+	}(nvmeRun, sanitizePoll)
+	sanitizePoll = 0
+
+	d := raid.NewDevice("/dev/nvme0n1")
+	ds := newTracker().add(d.Dev())
+
+	//crypto sanitize fails part way; failure mode is exited and block
+	//sanitize is used instead
+	f := &fakeNVMe{
+		id: idCtrl(0x2, 0x3, 0),
+		sanLogs: [][]byte{
+			sanLog(0xffff, sstatDone), //from an earlier sanitize
+			sanLog(100, sstatInProgress),
+			sanLog(200, sstatFailed),
+			sanLog(0, sstatInProgress),
+			sanLog(32768, sstatInProgress),
+			sanLog(0xffff, sstatDone),
+		},
+	}
+	nvmeRun = f.run
+	m, err := tryNVMe(&d, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != "NVMe sanitize (block erase)" {
+		t.Errorf("got method %q", m)
+	}
+	if r := ds.report(time.Now()); r.Method != m || r.Fraction < 0.5 {
+		t.Errorf("want progress of %s at least 50%%, got %#v", m, r)
+	}
+	want := []string{
+		"id-ctrl /dev/nvme0n1 --raw-binary",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+		"sanitize /dev/nvme0n1 --sanact=4",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+		"sanitize /dev/nvme0n1 --sanact=1",
+		"sanitize /dev/nvme0n1 --sanact=2",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+		"sanitize-log /dev/nvme0n1 --raw-binary",
+	}
+	if !reflect.DeepEqual(f.cmds, want) {
+		t.Errorf("want commands\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(f.cmds, "\n"))
+	}
+
+	//format is used when all sanitize actions fail
+	f = &fakeNVMe{
+		id:   idCtrl(0x2, 0x1, 0x4),
+		fail: map[string]bool{"sanitize /dev/nvme0n1 --sanact=4": true},
+	}
+	nvmeRun = f.run
+	if _, err = tryNVMe(&d, ds); err != nil {
+		t.Fatal(err)
+	}
+	if last := f.cmds[len(f.cmds)-1]; last != "format /dev/nvme0n1 --ses=2" {
+		t.Errorf("want crypto format, got %s", last)
+	}
+
+	//no capabilities; caller falls back to overwrite
+	nvmeRun = (&fakeNVMe{id: idCtrl(0, 0, 0)}).run
+	if _, err = tryNVMe(&d, ds); err == nil {
+		t.Error("want error without sanitize or format support")
+	}
+	//sanitize in progress after power loss is waited for
+	f = &fakeNVMe{
+		id:      idCtrl(0x2, 0x1, 0x4),
+		sanLogs: [][]byte{sanLog(40000, sstatInProgress), sanLog(0xffff, sstatDone)},
+	}
+	nvmeRun = f.run
+	if m, err = tryNVMe(&d, ds); err != nil || m != "NVMe sanitize (resumed)" {
+		t.Errorf("got %q, %v", m, err)
+	}
+	if len(f.cmds) != 3 {
+		t.Errorf("want no new sanitize, got\n%s", strings.Join(f.cmds, "\n"))
+	}
+	if !isNVMe(d.Dev()) || isNVMe("/dev/sda") {
+		t.Error("isNVMe")
+	}
+}
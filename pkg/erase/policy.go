@@ -0,0 +1,230 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+/* The erase policy selects the drive commands tried, the overwrite patterns
+   used if none succeed, and how much of each disk is read back afterwards.
+   It comes from policyFile on the recovery volume, else from the variant;
+   empty fields are filled in from the defaults, which match the behavior
+   before policies existed. The policy is saved in the checkpoint, so that a
+   resumed erase continues with the same passes, and in the certificate.
+*/
+
+//erase policy file, in the root of the recovery volume
+const policyFile = "erase_policy.json"
+
+var (
+	defaultMethods  = []string{appliance.EraseSanitize, appliance.EraseFormat, appliance.EraseATA}
+	defaultPatterns = []string{"0x55", "0xaa", appliance.PatternZeros}
+)
+
+//with the default patterns, the last is written twice
+const defaultPasses = 4
+
+//size of each read during read-back
+var verifyChunk = oneM
+
+//policy in effect, with defaults filled in
+var curPolicy = withDefaults(appliance.ErasePolicy{})
+
+//load the policy from the recovery volume mounted at recovRoot, else from v.
+//A policy file that can't be used is logged and ignored.
+func loadPolicy(recovRoot string, v *appliance.Variant) appliance.ErasePolicy {
+	var p appliance.ErasePolicy
+	src := "defaults"
+	if v != nil && v.ErasePolicy() != nil {
+		p, src = *v.ErasePolicy(), "variant"
+	}
+	if recovRoot != "" {
+		f := fp.Join(recovRoot, policyFile)
+		data, err := ioutil.ReadFile(f)
+		if err == nil {
+			var rp appliance.ErasePolicy
+			if err = json.Unmarshal(data, &rp); err == nil {
+				err = rp.Validate()
+			}
+			if err == nil {
+				p, src = rp, f
+			}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Logf("ignoring erase policy %s: %s", f, err)
+		}
+	}
+	p = withDefaults(p)
+	log.Logf("erase policy from %s: %s", src, describePolicy(p))
+	return p
+}
+
+//fill in defaults and expand dod
+func withDefaults(p appliance.ErasePolicy) appliance.ErasePolicy {
+	if p.Methods == nil {
+		p.Methods = defaultMethods
+	} else if len(p.Methods) == 0 {
+		//overwrite only. Made explicit, as an empty list would be omitted
+		//from the checkpoint and read back as the defaults.
+		p.Methods = []string{appliance.EraseOverwrite}
+	}
+	if len(p.Patterns) == 0 {
+		p.Patterns = defaultPatterns
+		if p.Passes == 0 {
+			p.Passes = defaultPasses
+		}
+	}
+	var pats []string
+	for _, pat := range p.Patterns {
+		if pat == appliance.PatternDoD {
+			pats = append(pats, appliance.PatternZeros, appliance.PatternOnes, appliance.PatternRandom)
+		} else {
+			pats = append(pats, pat)
+		}
+	}
+	p.Patterns = pats
+	if p.Passes == 0 {
+		p.Passes = len(p.Patterns)
+	}
+	if p.Verify == "" {
+		p.Verify = appliance.VerifyCanary
+	}
+	return p
+}
+
+func describePolicy(p appliance.ErasePolicy) string {
+	var pats []string
+	for i := 0; i < p.Passes; i++ {
+		pats = append(pats, passPattern(p, i))
+	}
+	return fmt.Sprintf("methods %s; %d overwrite passes (%s); verify %s",
+		strings.Join(p.Methods, ", "), p.Passes, strings.Join(pats, ", "), describeVerify(p))
+}
+
+func describeVerify(p appliance.ErasePolicy) string {
+	if p.Verify == appliance.VerifySample {
+		return fmt.Sprintf("%d%% sample", p.VerifyPercent)
+	}
+	return p.Verify
+}
+
+//true if the policy allows drive command m
+func allowed(p appliance.ErasePolicy, m string) bool {
+	for _, pm := range p.Methods {
+		if pm == m {
+			return true
+		}
+	}
+	return false
+}
+
+//supported nvme methods which the policy allows, in the policy's order
+func nvmeOrder(p appliance.ErasePolicy, supported []nvmeMethod) (methods []nvmeMethod) {
+	for _, pm := range p.Methods {
+		for _, m := range supported {
+			if (pm == appliance.EraseSanitize && m.isSanitize()) || (pm == appliance.EraseFormat && !m.isSanitize()) {
+				methods = append(methods, m)
+			}
+		}
+	}
+	return
+}
+
+//pattern for overwrite pass n, counting from 0
+func passPattern(p appliance.ErasePolicy, n int) string {
+	if n < len(p.Patterns) {
+		return p.Patterns[n]
+	}
+	return p.Patterns[len(p.Patterns)-1]
+}
+
+//byte a pattern consists of; false for random
+func patternByte(pat string) (byte, bool) {
+	switch pat {
+	case appliance.PatternZeros:
+		return 0, true
+	case appliance.PatternOnes:
+		return 0xff, true
+	case appliance.PatternRandom:
+		return 0, false
+	}
+	b, err := strconv.ParseUint(strings.TrimPrefix(pat, "0x"), 16, 8)
+	if err != nil {
+		log.Logf("bad pattern %q, using zeros", pat)
+	}
+	return byte(b), true
+}
+
+//write a pattern into the buffer. A random pattern must be refilled for each
+//write.
+func fillPattern(buf []byte, pat string, rng *rand.Rand) {
+	b, fixed := patternByte(pat)
+	if !fixed {
+		rng.Read(buf)
+		return
+	}
+	for i := range buf {
+		buf[i] = b
+	}
+}
+
+//read back percent of dev, which is size bytes, in chunks spread evenly over
+//it. Chunks fail if they can't be read, contain the canary pattern, or - if
+//fill is non-nil - contain anything else. Returns the number that failed.
+func readBack(dev io.ReadSeeker, size int64, percent int, fill *byte, ds *diskStatus) (bad int) {
+	buf := make([]byte, verifyChunk)
+	var want []byte
+	if fill != nil {
+		want = bytes.Repeat([]byte{*fill}, verifyChunk)
+	}
+	canary := []byte(prepPattern)
+	chunks := (size + int64(verifyChunk) - 1) / int64(verifyChunk)
+	for i := int64(0); i < chunks; i++ {
+		//read chunk i if it takes the share read so far to the next percent
+		if (i+1)*int64(percent)/100 == i*int64(percent)/100 {
+			continue
+		}
+		offs := i * int64(verifyChunk)
+		n := int64(verifyChunk)
+		if size-offs < n {
+			n = size - offs
+		}
+		_, err := dev.Seek(offs, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(dev, buf[:n])
+		}
+		switch {
+		case err != nil:
+		case bytes.Contains(buf[:n], canary):
+			err = fmt.Errorf("canary found")
+		case want != nil && !bytes.Equal(buf[:n], want[:n]):
+			err = fmt.Errorf("data does not match final pattern")
+		}
+		if err != nil {
+			if bad < 10 {
+				log.Logf("read-back at offset %d: %s", offs, err)
+			}
+			bad++
+		}
+		ds.written(uint64(offs+n), uint64(size))
+	}
+	return
+}
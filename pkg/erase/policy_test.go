@@ -0,0 +1,179 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func TestPolicy(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	tmp, err := ioutil.TempDir("", "gp-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	want := "methods nvme-sanitize, nvme-format, ata-secure-erase; 4 overwrite passes (0x55, 0xaa, zeros, zeros); verify canary"
+	if got := describePolicy(loadPolicy(tmp, nil)); got != want {
+		t.Errorf("default policy:\nwant %s\ngot  %s", want, got)
+	}
+
+	f := fp.Join(tmp, policyFile)
+	err = ioutil.WriteFile(f, []byte(`{"Methods":["nvme-format","nvme-sanitize"],"Patterns":["dod"],"Passes":4,"Verify":"sample","VerifyPercent":10}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := loadPolicy(tmp, nil)
+	want = "methods nvme-format, nvme-sanitize; 4 overwrite passes (zeros, ones, random, random); verify 10% sample"
+	if got := describePolicy(p); got != want {
+		t.Errorf("policy from file:\nwant %s\ngot  %s", want, got)
+	}
+	if allowed(p, appliance.EraseATA) || !allowed(p, appliance.EraseFormat) {
+		t.Error("allowed methods")
+	}
+	m := nvmeOrder(p, []nvmeMethod{sanitizeCrypto, sanitizeBlock, formatCrypto, formatUserData})
+	if wantM := []nvmeMethod{formatCrypto, formatUserData, sanitizeCrypto, sanitizeBlock}; !reflect.DeepEqual(m, wantM) {
+		t.Errorf("want nvme methods %v, got %v", wantM, m)
+	}
+
+	//empty pattern list is treated as missing
+	for _, td := range []struct {
+		pol  appliance.ErasePolicy
+		want string
+	}{
+		{appliance.ErasePolicy{Patterns: []string{}}, "4 overwrite passes (0x55, 0xaa, zeros, zeros)"},
+		{appliance.ErasePolicy{Patterns: []string{}, Passes: 2}, "2 overwrite passes (0x55, 0xaa)"},
+	} {
+		if got := describePolicy(withDefaults(td.pol)); !strings.Contains(got, td.want) {
+			t.Errorf("%#v:\nwant %s\ngot  %s", td.pol, td.want, got)
+		}
+	}
+
+	//invalid policy is ignored
+	if err = ioutil.WriteFile(f, []byte(`{"Verify":"sample"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p = loadPolicy(tmp, nil); p.Verify != appliance.VerifyCanary {
+		t.Errorf("invalid policy used: %#v", p)
+	}
+}
+
+//an overwrite-only policy must survive the checkpoint
+func TestPolicyResume(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	defer func(p appliance.ErasePolicy) { curPolicy = p }(curPolicy)
+	tmp, err := ioutil.TempDir("", "gp-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err = ioutil.WriteFile(fp.Join(tmp, policyFile), []byte(`{"Methods":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	curPolicy = loadPolicy(tmp, nil)
+	want := "methods overwrite; 4 overwrite passes (0x55, 0xaa, zeros, zeros); verify canary"
+	if got := describePolicy(curPolicy); got != want {
+		t.Errorf("overwrite only:\nwant %s\ngot  %s", want, got)
+	}
+	newCheckpoint(tmp).update(nil)
+
+	//next boot
+	cp, err := loadCheckpoint(tmp)
+	if err != nil || cp == nil {
+		t.Fatalf("loading checkpoint: %v", err)
+	}
+	p := withDefaults(cp.Policy)
+	if !reflect.DeepEqual(p, curPolicy) {
+		t.Errorf("policy changed on resume:\nwant %#v\ngot  %#v", curPolicy, p)
+	}
+	for _, m := range defaultMethods {
+		if allowed(p, m) {
+			t.Errorf("resumed erase allows %s", m)
+		}
+	}
+}
+
+func TestReadBack(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	defer func(p appliance.ErasePolicy, c int) { curPolicy, overwriteChunk = p, c }(curPolicy, overwriteChunk)
+	overwriteChunk = oneM
+
+	tmp, err := ioutil.TempDir("", "gp-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	size := int64(10*oneM + 512)
+	f, err := os.Create(fp.Join(tmp, "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	ds := newTracker().add("/dev/sda")
+	zero := byte(0)
+	if bad := readBack(f, size, 100, &zero, ds); bad != 0 {
+		t.Errorf("zeroed disk: %d chunks failed", bad)
+	}
+
+	//canary in chunk 3, leftover data in chunk 7
+	if _, err = f.WriteAt([]byte(prepPattern), 3*oneM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0xaa}, 7*oneM+100); err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range []struct {
+		percent int
+		fill    *byte
+		bad     int
+	}{
+		{100, &zero, 2},
+		{100, nil, 1},
+		{50, &zero, 2}, //odd chunks
+		{10, &zero, 0}, //chunk 9 only
+	} {
+		if bad := readBack(f, size, td.percent, td.fill, ds); bad != td.bad {
+			t.Errorf("%d%%, fill %v: want %d failures, got %d", td.percent, td.fill != nil, td.bad, bad)
+		}
+	}
+
+	//random final pass
+	curPolicy = withDefaults(appliance.ErasePolicy{Patterns: []string{appliance.PatternDoD}})
+	cp := newCheckpoint("")
+	dp := cp.disk("/dev/sda", "model", "SER1", uint64(size))
+	if err = overwritePasses(f, size, cp, dp, ds); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(data, []byte{0}) > len(data)/100 || bytes.Count(data, []byte{0xff}) > len(data)/100 {
+		t.Error("final pass not random")
+	}
+	if bad := readBack(f, size, 100, nil, ds); bad != 0 {
+		t.Errorf("random disk: %d chunks failed", bad)
+	}
+}
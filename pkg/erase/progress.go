@@ -0,0 +1,150 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"sync"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/erase/status"
+)
+
+//tracks progress of each disk being erased, for eraseStatus
+type tracker struct {
+	mu    sync.Mutex
+	start time.Time
+	disks []*diskStatus
+}
+
+//progress of one disk. Depending on method, progress is known from bytes
+//written (overwrite), from the drive (NVMe sanitize), or only from the drive's
+//estimate of the time required (ATA secure erase).
+type diskStatus struct {
+	mu       sync.Mutex
+	dev      string
+	method   string
+	start    time.Time //when method began
+	est      time.Duration
+	fraction float64
+	done     uint64
+	total    uint64
+	base     uint64 //bytes already done when method began, i.e. on resume
+	finished bool
+}
+
+func newTracker() *tracker { return &tracker{start: time.Now()} }
+
+func (t *tracker) add(dev string) *diskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ds := &diskStatus{dev: dev, start: time.Now()}
+	t.disks = append(t.disks, ds)
+	return ds
+}
+
+func (t *tracker) report() (r status.Report) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	r.Elapsed = int64(now.Sub(t.start).Seconds())
+	for _, ds := range t.disks {
+		r.Disks = append(r.Disks, ds.report(now))
+	}
+	return
+}
+
+//start of an erase method; discards progress of any earlier method
+func (ds *diskStatus) begin(method string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.method = method
+	ds.start = time.Now()
+	ds.est, ds.fraction = 0, 0
+	ds.done, ds.total, ds.base = 0, 0, 0
+}
+
+//drive's estimate of the total time the method requires
+func (ds *diskStatus) estimate(est time.Duration) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.est = est
+}
+
+//fraction complete, as reported by the drive
+func (ds *diskStatus) progress(fraction float64) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.fraction = fraction
+}
+
+//bytes written out of total
+func (ds *diskStatus) written(done, total uint64) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.total == 0 {
+		ds.base = done
+	}
+	ds.done, ds.total = done, total
+}
+
+func (ds *diskStatus) finish() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.finished = true
+}
+
+func (ds *diskStatus) report(now time.Time) status.Disk {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	d := status.Disk{
+		Dev:      ds.dev,
+		Method:   ds.method,
+		Fraction: ds.fraction,
+		Done:     ds.done,
+		Total:    ds.total,
+		Finished: ds.finished,
+	}
+	if ds.finished {
+		d.Fraction = 1
+		return d
+	}
+	elapsed := now.Sub(ds.start)
+	var eta time.Duration
+	switch {
+	case ds.total > 0:
+		d.Fraction = float64(ds.done) / float64(ds.total)
+		if elapsed > 0 && ds.done > ds.base {
+			d.Rate = float64(ds.done-ds.base) / elapsed.Seconds()
+			eta = time.Duration(float64(ds.total-ds.done) / d.Rate * float64(time.Second))
+		}
+	case ds.est > 0:
+		if d.Fraction == 0 {
+			//better to stall at 99% than to claim completion
+			d.Fraction = elapsed.Seconds() / ds.est.Seconds()
+			if d.Fraction > 0.99 {
+				d.Fraction = 0.99
+			}
+		}
+		eta = ds.est - elapsed
+	case ds.fraction > 0:
+		eta = time.Duration(float64(elapsed) * (1 - ds.fraction) / ds.fraction)
+	}
+	if eta > 0 {
+		d.ETA = int64(eta.Seconds())
+	}
+	return d
+}
+
+//message shown on the lcd for d
+func lcdLine(d status.Disk) string {
+	msg := "Erasing " + d.Short()
+	if d.ETA > 0 {
+		msg += ", " + status.Duration(d.ETA) + " left"
+	}
+	return msg
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package erase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/erase/status"
+)
+
+func TestProgress(t *testing.T) {
+	tr := newTracker()
+	sda := tr.add("/dev/sda")
+	sdb := tr.add("/dev/sdb")
+	nvme := tr.add("/dev/nvme0n1")
+
+	//overwrite, resumed at 1G of 4G; 1G written since, in 100s
+	sda.begin(overwriteMethod())
+	sda.written(oneG, 4*oneG)
+	sda.start = sda.start.Add(-100 * time.Second)
+	sda.written(2*oneG, 4*oneG)
+	//ATA secure erase, estimated by the drive at 200m; 50m in
+	sdb.begin("ATA secure erase")
+	sdb.estimate(200 * time.Minute)
+	sdb.start = sdb.start.Add(-50 * time.Minute)
+	//sanitize, 25% done after 10m, no estimate from drive
+	nvme.begin("NVMe sanitize (block erase)")
+	nvme.progress(0.25)
+	nvme.start = nvme.start.Add(-10 * time.Minute)
+
+	r := tr.report()
+	if len(r.Disks) != 3 {
+		t.Fatalf("want 3 disks, got %#v", r)
+	}
+	for i, td := range []struct {
+		fraction float64
+		eta      int64
+		rate     float64
+		lcd      string
+	}{
+		{0.5, 200, oneG / 100, "Erasing sda 50%, 3m left"},
+		{0.25, 150 * 60, 0, "Erasing sdb 25%, 2h30m left"},
+		{0.25, 30 * 60, 0, "Erasing nvme0n1 25%, 30m left"},
+	} {
+		d := r.Disks[i]
+		//allow for time passing during the test
+		if d.Fraction < td.fraction-0.01 || d.Fraction > td.fraction+0.01 ||
+			d.ETA < td.eta-5 || d.ETA > td.eta+5 ||
+			d.Rate < td.rate*0.95 || d.Rate > td.rate*1.05 {
+			t.Errorf("%d: want %.2f, eta %d, rate %.0f; got %#v", i, td.fraction, td.eta, td.rate, d)
+		}
+		if l := lcdLine(d); l != td.lcd {
+			t.Errorf("%d: want lcd %q, got %q", i, td.lcd, l)
+		}
+	}
+
+	sdb.finish()
+	got, ok := status.Parse(tr.report().String())
+	if !ok || len(got.Disks) != 3 {
+		t.Fatalf("parsing report: %#v", got)
+	}
+	if !got.Disks[1].Finished || got.Disks[0].Method != overwriteMethod() {
+		t.Errorf("report not preserved: %#v", got)
+	}
+	if want := "sda 50%, sdb done, nvme0n1 25%, 30m left"; got.Summary() != want {
+		t.Errorf("want summary %q, got %q", want, got.Summary())
+	}
+	if _, ok = status.Parse("erase: something else"); ok {
+		t.Error("parsed non-report")
+	}
+}
@@ -276,6 +276,26 @@ func (d *Device) Restore() (err error) {
 
 }
 
+//return metadata type and data saved by Backup, so they can be kept across an
+//interrupted erase
+func (d *Device) Metadata() (typ string, data []byte) {
+	return d.arrayType.String(), d.arrayMetadata
+}
+
+//set metadata type and data as returned by Metadata, in place of Backup. Use
+//when resuming an erase, as the metadata on disk may already be gone. Must be
+//called before FindArrays.
+func (d *Device) SetMetadata(typ string, data []byte) error {
+	for _, t := range []raidType{unknown, msm, ddf} {
+		if t.String() == typ {
+			d.arrayType = t
+			d.arrayMetadata = data
+			return nil
+		}
+	}
+	return EUnknownRaidFormat
+}
+
 //compare dev size, with tolerance
 func sizeMatch(a, b *Device) bool {
 	var err error
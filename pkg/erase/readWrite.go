@@ -30,27 +30,9 @@ func init() {
 	prepPattern = fmt.Sprintf("~~erase begins %s~~", time.Now())
 }
 
-//write a pattern into the buffer
-//3 patterns - 0x55, 0xAA, 0x00
-func fillPattern(buf []byte, p int) {
-	switch p {
-	case 0:
-		for i := range buf {
-			buf[i] = 0x55
-		}
-	case 1:
-		for i := range buf {
-			buf[i] = 0xAA
-		}
-	default:
-		for i := range buf {
-			buf[i] = 0x00
-		}
-	}
-}
-
 //prepare the disk - write a pattern in certain places. (every 100M?)
-func prepare(d *raid.Device, recov common.Pather) {
+//returns the number of places written
+func prepare(d *raid.Device, recov common.Pather) int {
 	var err error
 	name := d.Dev()
 	name = name[len(name)-3:]
@@ -83,6 +65,7 @@ func prepare(d *raid.Device, recov common.Pather) {
 		log.Logf("prepare %s: writtenCount=%d, patternWriteCount=%d", name, writtenCount, patternWriteCount)
 		unrecoverableFailure(recov, true)
 	}
+	return patternWriteCount
 }
 
 //count the number of occurences of the prep pattern
@@ -122,7 +105,7 @@ func countPrepPattern(d *raid.Device, recov common.Pather) int {
 
 //verify erasure - check if the pattern written by prepare exists anywhere
 //if it does, this is an unrecoverable failure
-func verify(d *raid.Device, recov common.FS) {
+func verify(d *raid.Device, recov common.FS) int {
 	if _, err := d.Open(); err != nil {
 		log.Logf("open for verify: %s", err)
 	}
@@ -131,4 +114,5 @@ func verify(d *raid.Device, recov common.FS) {
 		log.Logf("%s: writtenCount=%d, want 0!", d.Dev(), writtenCount)
 		unrecoverableFailure(recov, true)
 	}
+	return writtenCount
 }
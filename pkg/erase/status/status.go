@@ -0,0 +1,99 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// Package status defines the per-disk progress reports logged during data
+// erase. Reports are ordinary log entries - and thus reach the remote logger -
+// consisting of Prefix followed by json. This package has no dependencies
+// beyond the standard library, so that servers can parse reports without
+// depending on package erase.
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Prefix begins every log entry containing a Report
+const Prefix = "erase progress: "
+
+//Disk is the progress of one disk
+type Disk struct {
+	Dev      string
+	Method   string  `json:",omitempty"`
+	Fraction float64 //0..1; 0 if unknown
+	Done     uint64  `json:",omitempty"` //bytes written, for overwrite
+	Total    uint64  `json:",omitempty"`
+	Rate     float64 `json:",omitempty"` //bytes per second
+	ETA      int64   `json:",omitempty"` //seconds remaining; 0 if unknown
+	Finished bool    `json:",omitempty"`
+}
+
+//Report is the progress of all disks being erased
+type Report struct {
+	Elapsed int64 //seconds since erase began
+	Disks   []Disk
+}
+
+//String returns the log entry for r
+func (r Report) String() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return Prefix + err.Error()
+	}
+	return Prefix + string(data)
+}
+
+//Parse returns the report in a log entry, and whether the entry is a report
+func Parse(entry string) (r Report, ok bool) {
+	if !strings.HasPrefix(entry, Prefix) {
+		return
+	}
+	ok = json.Unmarshal([]byte(strings.TrimPrefix(entry, Prefix)), &r) == nil
+	return
+}
+
+//Summary is a short description of r, such as "sda 42%, sdb 40%, 1h20m left"
+func (r Report) Summary() string {
+	var parts []string
+	var eta int64
+	for _, d := range r.Disks {
+		parts = append(parts, d.Short())
+		if d.ETA > eta {
+			eta = d.ETA
+		}
+	}
+	if eta > 0 {
+		parts = append(parts, Duration(eta)+" left")
+	}
+	return strings.Join(parts, ", ")
+}
+
+//Short describes d's progress, without ETA
+func (d Disk) Short() string {
+	dev := d.Dev[strings.LastIndex(d.Dev, "/")+1:]
+	switch {
+	case d.Finished:
+		return dev + " done"
+	case d.Fraction > 0:
+		return fmt.Sprintf("%s %d%%", dev, int(d.Fraction*100))
+	}
+	return dev
+}
+
+//Duration formats seconds compactly for small displays: 1h20m, 5m, or <1m.
+func Duration(secs int64) string {
+	d := (time.Duration(secs) * time.Second).Round(time.Minute)
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
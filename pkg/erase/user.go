@@ -10,7 +10,6 @@
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io/ioutil"
 	"os"
 	fp "path/filepath"
@@ -51,6 +50,9 @@ func success(recov common.FS) {
 //use LCD to communicate that Data Erase failed (i.e. a drive did not come up)
 //if write is true, patch the boot file to immediately jump here
 func unrecoverableFailure(recov common.Pather, write bool) {
+	if curCheckpoint != nil {
+		curCheckpoint.update(func() { curCheckpoint.Failed = true })
+	}
 	if write && recov != nil {
 		f := fp.Join(recov.Path(), bootFile)
 		go writeErrToBootFile(f)
@@ -89,63 +91,36 @@ func writeErrToBootFile(f string) {
 	}
 }
 
-/* put message on screen periodically
-use:
-	eraseDone = make(chan struct{})
-	defer close(eraseDone)
-	go decompressStatus(eraseDone, est, spinner)
-
-*/
-func tmax(a, b time.Duration) time.Duration {
-	if a < b {
-		return b
-	}
-	return a
-}
+//how often progress of each disk is logged
+var statusLogInterval = time.Minute
 
-//return s, truncated after first occurrence of r or at len==max, whichever is first
-func truncate(s string, r rune, max int) string {
-	i := strings.IndexRune(s, r) + 1
-	if i == 0 {
-		i = len(s)
-	}
-	if i > max {
-		i = max
-	}
-	return s[:i]
-}
-
-func eraseStatus(times chan time.Duration, est time.Duration, s *cfa.Spinner) {
-	start := time.Now()
-	finishEst := start.Add(est)
-	var estimate time.Duration
-	counter := 0
-	const incr = 3
-	spin := true
+//show progress on the lcd, rotating through the disks, and log it
+//periodically until done is closed
+func eraseStatus(t *tracker, done <-chan struct{}, s *cfa.Spinner) {
+	const incr = 3 * time.Second
+	tick := time.NewTicker(incr)
+	defer tick.Stop()
+	var lastLog time.Time
+	counter, shown := 0, -1
 	for {
-		time.Sleep(incr * time.Second)
 		select {
-		case val, more := <-times:
-			if !more {
-				return
-			}
-			estimate = tmax(estimate, val)
-			finishEst = start.Add(estimate)
-		default:
+		case <-done:
+			log.Log(t.report().String())
+			return
+		case <-tick.C:
 		}
-		//at first, rewrite each time. after that, rewrite once per minute
-		//this is to get a good estimate onto the display within reasonable time
-		if (counter < 30/incr) || (counter%(60/incr) == 0) {
-			now := time.Now().Add(-1 * time.Minute) //better to overshoot than undershoot
-			if spin && now.Before(finishEst) {
-				est := finishEst.Sub(now).String()
-				s.Msg = fmt.Sprintf("Erasing... %s (est) to go", truncate(est, 'm', 6))
-				_ = s.Display()
-			} else {
-				spin = false
-				log.Msgf("Erasing... elapsed time %s", now.Sub(start))
-			}
-		} else if spin {
+		r := t.report()
+		if time.Since(lastLog) >= statusLogInterval {
+			log.Log(r.String())
+			lastLog = time.Now()
+		}
+		//each disk is shown for two increments, with the spinner advancing
+		//in between
+		if counter%2 == 0 && len(r.Disks) > 0 {
+			shown = (shown + 1) % len(r.Disks)
+			s.Msg = lcdLine(r.Disks[shown])
+			_ = s.Display()
+		} else {
 			s.Next()
 		}
 		counter++
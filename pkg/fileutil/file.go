@@ -90,6 +90,45 @@ func RenameUnique(old, newPfx string) (success bool) {
 	return err == nil
 }
 
+//WriteFileAtomic writes data to a temp file in the same dir, syncs it, and
+//renames it over name. Either the old or the new content will be found at
+//name, even if power is lost.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(fp.Dir(name), fp.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(fp.Dir(name))
+}
+
+//SyncDir syncs a directory, making renames within it durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 // WaitFor waits for a file to appear or times out. Returns true if file appears,
 // false otherwise. Sleeps .1s between checks.
 func WaitFor(path string, timeout time.Duration) (found bool) {
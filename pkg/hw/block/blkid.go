@@ -75,6 +75,8 @@ func parseBlkidOut(out []byte) (binfo BlkInfo, err error) {
 	FsNtfs
 	FsFat
 	FsExfat
+	FsXfs
+	FsBtrfs
 )
 
 func FsFromStr(s string) FsType {
@@ -96,6 +98,10 @@ func FsFromStr(s string) FsType {
 		return FsFat
 	case "exfat":
 		return FsExfat
+	case "xfs":
+		return FsXfs
+	case "btrfs":
+		return FsBtrfs
 	}
 	return FsUnknown
 }
@@ -111,6 +117,10 @@ func (f FsType) String() (t string) {
 		t = "vfat"
 	case FsExfat:
 		t = "exfat"
+	case FsXfs:
+		t = "xfs"
+	case FsBtrfs:
+		t = "btrfs"
 	default:
 		t = "fsType VALUE OUT OF RANGE"
 	}
@@ -118,7 +128,7 @@ func (f FsType) String() (t string) {
 }
 
 func (f FsType) Recognized() bool {
-	return f == FsExt4 || f == FsNtfs || f == FsFat || f == FsExfat
+	return f == FsExt4 || f == FsNtfs || f == FsFat || f == FsExfat || f == FsXfs || f == FsBtrfs
 }
 
 type BlkInfo struct {
@@ -169,6 +169,32 @@ func ReadVendor(dev string) (v string, err error) {
 	return
 }
 
+//given a dev like 'sda', find its serial number. nvme exposes this in sysfs;
+//for others, it comes from the udev database.
+func ReadSerial(dev string) (s string, err error) {
+	f, err := ioutil.ReadFile(fp.Join("/sys/block", dev, "device", "serial"))
+	if err == nil {
+		s = strings.TrimSpace(string(f))
+		return
+	}
+	majMin, err := ioutil.ReadFile(fp.Join("/sys/block", dev, "dev"))
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile("/run/udev/data/b" + strings.TrimSpace(string(majMin)))
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(l, "E:ID_SERIAL_SHORT=") {
+			s = strings.TrimPrefix(l, "E:ID_SERIAL_SHORT=")
+			return
+		}
+	}
+	err = fmt.Errorf("%s: no serial in udev data", dev)
+	return
+}
+
 //IsDev returns true if given dev represents a physical device
 func IsDev(dev string) bool {
 	_, err := os.Stat(fp.Join("/sys/class/block", dev, "device"))
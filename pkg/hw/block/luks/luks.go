@@ -0,0 +1,171 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+//Package luks manages LUKS2 containers via cryptsetup, for full-disk
+//encryption of the primary volume.
+//
+//The container holding the primary volume is labeled (see RootLabel), so it
+//can be located without kernel args or crypttab. When opened, it is mapped
+//as /dev/mapper/<RootName>.
+package luks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//RootName is the device mapper name of the opened primary volume container.
+const RootName = "cryptroot"
+
+//RootLabel is the LUKS2 label of the container holding the primary volume.
+//It must differ from the label of the fs inside.
+func RootLabel() string { return strs.PriVolName() + "_luks" }
+
+//RootDev is the path at which udev exposes the (locked) container.
+func RootDev() string { return "/dev/disk/by-label/" + RootLabel() }
+
+//Mapped returns the path of the opened container with the given name.
+func Mapped(name string) string { return "/dev/mapper/" + name }
+
+//Format creates a LUKS2 container on dev, with the given label and key in
+//keyslot 0. ANY EXISTING DATA IS LOST.
+func Format(dev, label string, key []byte) error {
+	_, err := run(key, formatArgs(dev, label)...)
+	return err
+}
+
+func formatArgs(dev, label string) []string {
+	return []string{"luksFormat", "--type", "luks2", "--batch-mode", "--label", label, "--key-file", "-", dev}
+}
+
+//Open unlocks dev with key, mapping it as name. If discard is true, discards
+//are allowed and the flag is persisted in the header so that later opens
+//(i.e. by init) use it too.
+func Open(dev, name string, key []byte, discard bool) (string, error) {
+	_, err := run(key, openArgs(dev, name, discard)...)
+	if err != nil {
+		return "", err
+	}
+	return Mapped(name), nil
+}
+
+func openArgs(dev, name string, discard bool) []string {
+	args := []string{"open", "--type", "luks2", "--key-file", "-"}
+	if discard {
+		args = append(args, "--allow-discards", "--persistent")
+	}
+	return append(args, dev, name)
+}
+
+//OpenToken unlocks dev using a token in the header (such as one created by
+//EnrollTPM2), without a key.
+func OpenToken(dev, name string) (string, error) {
+	_, err := run(nil, "open", "--type", "luks2", "--token-only", dev, name)
+	if err != nil {
+		return "", err
+	}
+	return Mapped(name), nil
+}
+
+//Close removes the mapping with the given name.
+func Close(name string) error {
+	_, err := run(nil, "close", name)
+	return err
+}
+
+//IsActive returns true if a mapping with the given name exists.
+func IsActive(name string) bool {
+	_, err := os.Stat(Mapped(name))
+	return err == nil
+}
+
+//UUID returns the uuid in the container's header.
+func UUID(dev string) (string, error) {
+	out, err := run(nil, "luksUUID", dev)
+	return strings.TrimSpace(string(out)), err
+}
+
+//Erase destroys all keyslots on dev, leaving its contents unrecoverable.
+func Erase(dev string) error {
+	_, err := run(nil, "luksErase", "--batch-mode", dev)
+	return err
+}
+
+//Find returns the devices that blkid identifies as LUKS containers.
+func Find() []string {
+	out, err := exec.Command("blkid", "-t", "TYPE=crypto_LUKS", "-o", "device").Output()
+	if err != nil {
+		//blkid exits non-zero if nothing matches
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+//EnrollTPM2 adds a keyslot holding a random key sealed by the TPM against the
+//given PCRs (such as "7"), so that the container can be opened with OpenToken
+//on this unit. Requires systemd-cryptenroll. Key is an existing key.
+func EnrollTPM2(dev string, key []byte, pcrs string) error {
+	kf, err := ioutil.TempFile("", "luks")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(kf.Name())
+	_, err = kf.Write(key)
+	if e := kf.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	enroll := exec.Command("systemd-cryptenroll", enrollArgs(dev, kf.Name(), pcrs)...)
+	out, err := enroll.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s\n%s", enroll.Args, err, out)
+	}
+	return nil
+}
+
+func enrollArgs(dev, keyFile, pcrs string) []string {
+	args := []string{"--unlock-key-file=" + keyFile, "--tpm2-device=auto"}
+	if pcrs != "" {
+		args = append(args, "--tpm2-pcrs="+pcrs)
+	}
+	return append(args, dev)
+}
+
+//CrypttabEntry returns a line for /etc/crypttab.
+func CrypttabEntry(name, uuid string, discard, tpm2 bool) string {
+	opts := "luks"
+	if discard {
+		opts += ",discard"
+	}
+	if tpm2 {
+		opts += ",tpm2-device=auto"
+	}
+	return fmt.Sprintf("%s UUID=%s none %s\n", name, uuid, opts)
+}
+
+//run cryptsetup. if key is non-nil, it is passed on stdin.
+func run(key []byte, args ...string) ([]byte, error) {
+	cs := exec.Command("cryptsetup", args...)
+	if key != nil {
+		cs.Stdin = bytes.NewReader(key)
+	}
+	out, err := cs.CombinedOutput()
+	if err != nil {
+		log.Logf("exec %v: %s\n%s", cs.Args, err, out)
+		return out, fmt.Errorf("cryptsetup %s: %s", args[0], err)
+	}
+	return out, nil
+}
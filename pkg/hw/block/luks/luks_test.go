@@ -0,0 +1,45 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package luks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	for _, td := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"format", formatArgs("/dev/md0", "lbl"), "luksFormat --type luks2 --batch-mode --label lbl --key-file - /dev/md0"},
+		{"open", openArgs("/dev/md0", RootName, false), "open --type luks2 --key-file - /dev/md0 cryptroot"},
+		{"openDiscard", openArgs("/dev/sda2", RootName, true), "open --type luks2 --key-file - --allow-discards --persistent /dev/sda2 cryptroot"},
+		{"enroll", enrollArgs("/dev/md0", "/tmp/k", "0+7"), "--unlock-key-file=/tmp/k --tpm2-device=auto --tpm2-pcrs=0+7 /dev/md0"},
+		{"enrollDefaultPcrs", enrollArgs("/dev/md0", "/tmp/k", ""), "--unlock-key-file=/tmp/k --tpm2-device=auto /dev/md0"},
+	} {
+		if got := strings.Join(td.args, " "); got != td.want {
+			t.Errorf("%s:\nwant %s\ngot  %s", td.name, td.want, got)
+		}
+	}
+}
+
+func TestCrypttabEntry(t *testing.T) {
+	got := CrypttabEntry(RootName, "abc", false, false)
+	if want := "cryptroot UUID=abc none luks\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	got = CrypttabEntry(RootName, "abc", true, true)
+	if want := "cryptroot UUID=abc none luks,discard,tpm2-device=auto\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if RootLabel() == "" || !strings.HasSuffix(RootDev(), "/"+RootLabel()) {
+		t.Errorf("bad label/dev %s %s", RootLabel(), RootDev())
+	}
+}
@@ -6,27 +6,32 @@
 //
 
 // Package partitioning allows creation of MBR and GPT partition
-// tables and partitions, DESTROYING ANY EXISTING DATA.
+// tables and partitions, DESTROYING ANY EXISTING DATA. Tables are read and
+// written natively, so block devices and plain image files both work.
 //
 // Note that it does *not* support:
 //   * conversion between MBR & GPT,
 //   * resizing existing partitions,
 //   * adding partitions to an existing table,
 //   * specifying gaps,
+//   * more than 4 MBR partitions (extended/logical),
 //   * etc.
 package partitioning
 
 import (
+	"encoding/json"
 	"fmt"
-	"os/exec"
+	"strings"
 
 	"github.com/purecloudlabs/gprovision/pkg/hw/uefi"
 	"github.com/purecloudlabs/gprovision/pkg/log"
+
+	"github.com/google/uuid"
 )
 
 type Partitioner interface {
 	Commit() error                                               //write changes to disk
-	Add(sizeMegs uint64, ptype partType, boot bool, name string) //add a partition
+	Add(sizeMegs uint64, ptype PartType, boot bool, name string) //add a partition
 }
 
 //determines best type of partition table to use, returns a Partitioner to do so
@@ -37,10 +42,12 @@ func NewPTable(dev string) Partitioner {
 	return NewMbr(dev)
 }
 
-type partType int
+//PartType is the type of a partition, independent of partition table format.
+//In json, it is represented by name: unused, fat32, ntfs, linux, raid, esp.
+type PartType int
 
 const (
-	Unused partType = iota
+	Unused PartType = iota
 	FAT32
 	NTFS
 	Linux
@@ -48,7 +55,7 @@ func NewPTable(dev string) Partitioner {
 	ESP
 )
 
-func (t partType) String() string {
+func (t PartType) String() string {
 	switch t {
 	case Unused:
 		return "unused partition"
@@ -66,11 +73,73 @@ func (t partType) String() string {
 	return "partition type out of range"
 }
 
+var partTypeNames = map[PartType]string{
+	Unused:    "unused",
+	FAT32:     "fat32",
+	NTFS:      "ntfs",
+	Linux:     "linux",
+	LinuxRaid: "raid",
+	ESP:       "esp",
+}
+
+//ParsePartType returns the PartType with the given json name.
+func ParsePartType(name string) (PartType, error) {
+	for t, n := range partTypeNames {
+		if strings.EqualFold(n, name) {
+			return t, nil
+		}
+	}
+	return Unused, fmt.Errorf("unknown partition type %q", name)
+}
+
+func (t PartType) MarshalJSON() ([]byte, error) {
+	n, ok := partTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal %T value %#v", t, t)
+	}
+	return json.Marshal(n)
+}
+
+func (t *PartType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	pt, err := ParsePartType(s)
+	if err != nil {
+		return err
+	}
+	*t = pt
+	return nil
+}
+
+//Partition describes a partition to be created, or one returned by Read.
 type Partition struct {
 	sizeMegs uint64 //a size of 0 indicates "use all available space"
 	boot     bool
-	ptype    partType
+	ptype    PartType
 	name     string
+
+	//set by Read, and by Commit
+	Num        int    //1-based
+	Start, End uint64 //first and last sector, inclusive
+	SectorSize uint64
+	TypeGUID   uuid.UUID //gpt only
+	GUID       uuid.UUID //gpt only; unique to this partition
+	Attributes uint64    //gpt only; see AttrRequired etc
+	MbrType    byte      //mbr only
+}
+
+func (p Partition) Name() string   { return p.name }
+func (p Partition) Type() PartType { return p.ptype }
+func (p Partition) Boot() bool     { return p.boot }
+
+//SizeBytes returns the size of a partition which has been read or committed.
+func (p Partition) SizeBytes() uint64 {
+	if p.End < p.Start {
+		return 0
+	}
+	return (p.End - p.Start + 1) * p.SectorSize
 }
 
 func (p Partition) String() string {
@@ -78,15 +147,85 @@ func (p Partition) String() string {
 	if p.sizeMegs != 0 {
 		size = fmt.Sprintf("%dMB", p.sizeMegs)
 	}
-	return fmt.Sprintf("Partition: name='%s' size=%s boot=%t type=%s", p.name, size, p.boot, p.ptype)
+	s := fmt.Sprintf("Partition: name='%s' size=%s boot=%t type=%s", p.name, size, p.boot, p.ptype)
+	if p.Num > 0 {
+		s += fmt.Sprintf(" num=%d sectors=%d-%d", p.Num, p.Start, p.End)
+	}
+	return s
+}
+
+//Read returns the partitions on dev, which may be a block device or an image
+//file. GPT is used if the MBR is protective; if the primary GPT is damaged,
+//the backup is used.
+func Read(dev string) ([]Partition, error) {
+	d, err := openDev(dev, false)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+	sec, err := d.read(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if sec[510] != 0x55 || sec[511] != 0xaa {
+		return nil, fmt.Errorf("%s: no partition table", dev)
+	}
+	parts, isGpt := readMbr(d, sec)
+	if isGpt {
+		return readGpt(d)
+	}
+	return parts, nil
 }
 
+//List returns a human-readable description of the partitions on dev.
 func List(dev string) string {
-	list := exec.Command("fdisk", "-l", dev)
-	out, err := list.CombinedOutput()
+	parts, err := Read(dev)
 	if err != nil {
-		log.Logf("executing %v: error %s\nout:\n%s", list.Args, err, out)
+		log.Logf("reading partitions on %s: %s", dev, err)
 		return ""
 	}
-	return string(out)
+	scheme := "mbr"
+	if len(parts) > 0 && parts[0].TypeGUID != uuid.Nil {
+		scheme = "gpt"
+	}
+	out := fmt.Sprintf("%s: %s, %d partition(s)\n", dev, scheme, len(parts))
+	for _, p := range parts {
+		out += fmt.Sprintf("  %s\n", p)
+	}
+	return out
+}
+
+//partitions are aligned to 1MiB, as with most modern tools
+const alignBytes = 1024 * 1024
+
+//round lba up to alignment
+func align(lba, sectorSize uint64) uint64 {
+	a := alignBytes / sectorSize
+	if a < 1 {
+		return lba
+	}
+	return (lba + a - 1) / a * a
+}
+
+//assign start and end sectors to parts, between first and last inclusive
+func place(parts []*Partition, first, last, sectorSize uint64) error {
+	next := align(first, sectorSize)
+	for i, p := range parts {
+		p.Num = i + 1
+		p.SectorSize = sectorSize
+		p.Start = next
+		if p.sizeMegs == 0 {
+			if i != len(parts)-1 {
+				return fmt.Errorf("partition %d: only the last partition may use all available space", p.Num)
+			}
+			p.End = last
+		} else {
+			p.End = p.Start + p.sizeMegs*1024*1024/sectorSize - 1
+		}
+		if p.Start > last || p.End > last {
+			return fmt.Errorf("partition %d (%dMB) does not fit on disk", p.Num, p.sizeMegs)
+		}
+		next = align(p.End+1, sectorSize)
+	}
+	return nil
 }
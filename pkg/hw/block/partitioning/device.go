@@ -0,0 +1,98 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package partitioning
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/purecloudlabs/gprovision/pkg/hw/ioctl"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//a block device or image file
+type device struct {
+	f          *os.File
+	sectorSize uint64
+	sectors    uint64
+	isBlock    bool
+	writable   bool
+}
+
+//smallest device we'll partition: room for gpt structures and one aligned partition
+const minDevBytes = 2 * alignBytes
+
+func openDev(name string, write bool) (*device, error) {
+	flag := os.O_RDONLY
+	if write {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(name, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d := &device{f: f, sectorSize: 512, writable: write}
+	size := uint64(fi.Size())
+	if fi.Mode()&os.ModeDevice != 0 {
+		d.isBlock = true
+		if ss, err := ioctl.BlkGetSectorSize(f); err == nil && ss >= 512 {
+			d.sectorSize = ss
+		}
+		size, err = ioctl.BlkGetSize64(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("size of %s: %s", name, err)
+		}
+	}
+	d.sectors = size / d.sectorSize
+	if size < minDevBytes {
+		f.Close()
+		return nil, fmt.Errorf("%s: too small (%d bytes)", name, size)
+	}
+	return d, nil
+}
+
+func (d *device) read(lba, count uint64) ([]byte, error) {
+	buf := make([]byte, count*d.sectorSize)
+	_, err := d.f.ReadAt(buf, int64(lba*d.sectorSize))
+	return buf, err
+}
+
+func (d *device) write(lba uint64, data []byte) error {
+	_, err := d.f.WriteAt(data, int64(lba*d.sectorSize))
+	return err
+}
+
+//zero count sectors, starting at lba
+func (d *device) zero(lba, count uint64) error {
+	return d.write(lba, make([]byte, count*d.sectorSize))
+}
+
+//close. if writable, syncs first; for block devices, also asks the kernel to
+//re-read the partition table.
+func (d *device) close() error {
+	if !d.writable {
+		return d.f.Close()
+	}
+	err := d.f.Sync()
+	if err == nil && d.isBlock {
+		if e := ioctl.BlkRereadPart(d.f); e != nil {
+			//kernel may still be using old partitions; not fatal, but likely to cause problems
+			log.Logf("re-reading partition table on %s: %s", d.f.Name(), e)
+		}
+	}
+	if e := d.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
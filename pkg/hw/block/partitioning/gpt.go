@@ -8,10 +8,17 @@
 package partitioning
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"os/exec"
+	"hash/crc32"
+	"unicode/utf16"
 
+	"github.com/purecloudlabs/gprovision/pkg/guid"
 	"github.com/purecloudlabs/gprovision/pkg/log"
+
+	"github.com/google/uuid"
 )
 
 type gpt struct {
@@ -22,16 +29,70 @@ type gpt struct {
 
 var _ Partitioner = &gpt{}
 
-var gptTypes map[partType]uint16
+//GPT partition attributes
+const (
+	AttrRequired       uint64 = 1 << 0 //required by platform
+	AttrNoBlockIO      uint64 = 1 << 1 //efi firmware must not produce block io protocol
+	AttrLegacyBootable uint64 = 1 << 2 //legacy bios bootable
+)
+
+var gptTypes map[PartType]uuid.UUID
 
 func init() {
-	gptTypes = make(map[partType]uint16)
-	gptTypes[Unused] = 0x00      //"00000000-0000-0000-0000-000000000000"
-	gptTypes[FAT32] = 0x0c00     //"EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
-	gptTypes[NTFS] = 0x0700      //"EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
-	gptTypes[Linux] = 0x8300     //"0FC63DAF-8483-4772-8E79-3D69D8477DE4"
-	gptTypes[LinuxRaid] = 0xfd00 //"A19D880F-05FC-4D3B-A006-743F0F84911E"
-	gptTypes[ESP] = 0xef00       //"C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	gptTypes = make(map[PartType]uuid.UUID)
+	gptTypes[Unused] = uuid.Nil
+	gptTypes[FAT32] = uuid.MustParse("EBD0A0A2-B9E5-4433-87C0-68B6B72699C7")
+	gptTypes[NTFS] = gptTypes[FAT32] //both are "basic data"
+	gptTypes[Linux] = uuid.MustParse("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+	gptTypes[LinuxRaid] = uuid.MustParse("A19D880F-05FC-4D3B-A006-743F0F84911E")
+	gptTypes[ESP] = uuid.MustParse("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+}
+
+//reverse lookup; basic data is reported as FAT32
+func gptPartType(u uuid.UUID) PartType {
+	for _, t := range []PartType{FAT32, Linux, LinuxRaid, ESP} {
+		if gptTypes[t] == u {
+			return t
+		}
+	}
+	return Unused
+}
+
+const (
+	gptSig        = "EFI PART"
+	gptRevision   = 0x00010000
+	gptHeaderSize = 92
+	gptEntries    = 128
+	gptEntrySize  = 128
+	gptNameLen    = 36 //utf-16 code units
+)
+
+//on-disk gpt header, little endian
+type gptHeader struct {
+	Signature      [8]byte
+	Revision       uint32
+	HeaderSize     uint32
+	HeaderCRC      uint32
+	Reserved       uint32
+	MyLBA          uint64
+	AlternateLBA   uint64
+	FirstUsableLBA uint64
+	LastUsableLBA  uint64
+	DiskGUID       guid.MixedGuid
+	EntriesLBA     uint64
+	NumEntries     uint32
+	EntrySize      uint32
+	EntriesCRC     uint32
+}
+
+//on-disk gpt partition entry, little endian
+type gptEntry struct {
+	Type       guid.MixedGuid
+	GUID       guid.MixedGuid
+	FirstLBA   uint64
+	LastLBA    uint64
+	Attributes uint64
+	Name       [gptNameLen]uint16
 }
 
 func NewGpt(dev string) Partitioner {
@@ -47,21 +108,22 @@ func (g *gpt) Commit() error {
 	for _, d := range g.partitions {
 		log.Logf("  - %s", d)
 	}
-
-	//to erase existing gpt and mbr records, must use both --clear and --mbrtogpt
-	sgdisk := exec.Command("sgdisk", "--clear", "--mbrtogpt")
-	args := g.assembleArgs()
-	sgdisk.Args = append(sgdisk.Args, args...)
-	sgdisk.Args = append(sgdisk.Args, g.device)
-	out, err := sgdisk.CombinedOutput()
+	d, err := openDev(g.device, true)
+	if err != nil {
+		return err
+	}
+	err = g.write(d)
+	if e := d.close(); err == nil {
+		err = e
+	}
 	if err != nil {
-		log.Logf("executing %s: %s\nout %s", sgdisk.Args, err, string(out))
+		log.Logf("writing GPT to %s: %s", g.device, err)
 		return err
 	}
 	g.committed = true
 	return nil
 }
-func (g *gpt) Add(sizeMegs uint64, ptype partType, boot bool, name string) {
+func (g *gpt) Add(sizeMegs uint64, ptype PartType, boot bool, name string) {
 	if g.committed {
 		log.Fatalf("cannot add partition after partitions are written to disk")
 	}
@@ -69,20 +131,201 @@ func (g *gpt) Add(sizeMegs uint64, ptype partType, boot bool, name string) {
 	g.partitions = append(g.partitions, p)
 }
 
-func (g *gpt) assembleArgs() (args []string) {
-	for i, p := range g.partitions {
+//sectors needed for the partition entry array
+func gptEntrySectors(sectorSize uint64) uint64 {
+	return (gptEntries*gptEntrySize + sectorSize - 1) / sectorSize
+}
+
+//assign sectors, guids, and attributes to partitions
+func (g *gpt) layout(sectors, sectorSize uint64) error {
+	if len(g.partitions) > gptEntries {
+		return fmt.Errorf("too many partitions (%d)", len(g.partitions))
+	}
+	es := gptEntrySectors(sectorSize)
+	//pmbr, header, entries ... entries, backup header
+	if err := place(g.partitions, 2+es, sectors-2-es, sectorSize); err != nil {
+		return err
+	}
+	for _, p := range g.partitions {
 		if p.boot != (p.ptype == ESP) {
 			log.Logf("WARNING: UEFI always only boots ESP partitions; mismatch between boot flag and ptype")
 		}
-		var size string
-		if p.sizeMegs > 0 {
-			size = fmt.Sprintf("+%dM", p.sizeMegs)
+		p.TypeGUID = gptTypes[p.ptype]
+		if p.GUID == uuid.Nil {
+			p.GUID = uuid.New()
+		}
+		p.Attributes = 0
+		if p.boot && p.ptype != ESP {
+			p.Attributes |= AttrLegacyBootable
+		}
+	}
+	return nil
+}
+
+//encoded partition entry array
+func (g *gpt) entries(sectorSize uint64) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, p := range g.partitions {
+		e := gptEntry{
+			Type:       guid.FromStdEnc(p.TypeGUID),
+			GUID:       guid.FromStdEnc(p.GUID),
+			FirstLBA:   p.Start,
+			LastLBA:    p.End,
+			Attributes: p.Attributes,
+		}
+		name := utf16.Encode([]rune(p.name))
+		if len(name) > gptNameLen {
+			return nil, fmt.Errorf("partition %d: name too long: %s", p.Num, p.name)
+		}
+		copy(e.Name[:], name)
+		if err := binary.Write(buf, binary.LittleEndian, e); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]byte, gptEntrySectors(sectorSize)*sectorSize)
+	copy(out, buf.Bytes())
+	return out, nil
+}
+
+//encode header, computing its crc
+func (h *gptHeader) encode(sectorSize uint64) []byte {
+	h.HeaderCRC = 0
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, h) //cannot fail for fixed-size struct
+	h.HeaderCRC = crc32.ChecksumIEEE(buf.Bytes()[:gptHeaderSize])
+	out := make([]byte, sectorSize)
+	copy(out, buf.Bytes())
+	binary.LittleEndian.PutUint32(out[16:], h.HeaderCRC)
+	return out
+}
+
+//write protective mbr, primary gpt and backup gpt
+func (g *gpt) write(d *device) error {
+	if err := g.layout(d.sectors, d.sectorSize); err != nil {
+		return err
+	}
+	ents, err := g.entries(d.sectorSize)
+	if err != nil {
+		return err
+	}
+	es := gptEntrySectors(d.sectorSize)
+	last := d.sectors - 1
+	primary := gptHeader{
+		Revision:       gptRevision,
+		HeaderSize:     gptHeaderSize,
+		MyLBA:          1,
+		AlternateLBA:   last,
+		FirstUsableLBA: 2 + es,
+		LastUsableLBA:  last - 1 - es,
+		DiskGUID:       guid.FromStdEnc(uuid.New()),
+		EntriesLBA:     2,
+		NumEntries:     gptEntries,
+		EntrySize:      gptEntrySize,
+		EntriesCRC:     crc32.ChecksumIEEE(ents[:gptEntries*gptEntrySize]),
+	}
+	copy(primary.Signature[:], gptSig)
+	backup := primary
+	backup.MyLBA, backup.AlternateLBA = last, 1
+	backup.EntriesLBA = last - es
+
+	pmbr, err := protectiveMbr(d)
+	if err != nil {
+		return err
+	}
+	for _, w := range []struct {
+		lba  uint64
+		data []byte
+	}{
+		{0, pmbr},
+		{1, primary.encode(d.sectorSize)},
+		{2, ents},
+		{backup.EntriesLBA, ents},
+		{last, backup.encode(d.sectorSize)},
+	} {
+		if err = d.write(w.lba, w.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errNoGpt = errors.New("no valid gpt")
+
+//read gpt at lba, verifying crcs
+func readGptAt(d *device, lba uint64) ([]Partition, error) {
+	sec, err := d.read(lba, 1)
+	if err != nil {
+		return nil, err
+	}
+	var h gptHeader
+	if err = binary.Read(bytes.NewReader(sec), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if string(h.Signature[:]) != gptSig || h.HeaderSize < gptHeaderSize || uint64(h.HeaderSize) > d.sectorSize {
+		return nil, errNoGpt
+	}
+	crc := h.HeaderCRC
+	binary.LittleEndian.PutUint32(sec[16:], 0)
+	if crc32.ChecksumIEEE(sec[:h.HeaderSize]) != crc {
+		return nil, fmt.Errorf("gpt header at %d: bad crc", lba)
+	}
+	if h.EntrySize < gptEntrySize || h.NumEntries > 4096 {
+		return nil, fmt.Errorf("gpt header at %d: bad entry size/count", lba)
+	}
+	arrBytes := uint64(h.NumEntries) * uint64(h.EntrySize)
+	arr, err := d.read(h.EntriesLBA, (arrBytes+d.sectorSize-1)/d.sectorSize)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(arr[:arrBytes]) != h.EntriesCRC {
+		return nil, fmt.Errorf("gpt entries at %d: bad crc", h.EntriesLBA)
+	}
+	var parts []Partition
+	for i := uint64(0); i < uint64(h.NumEntries); i++ {
+		var e gptEntry
+		off := i * uint64(h.EntrySize)
+		if err = binary.Read(bytes.NewReader(arr[off:off+gptEntrySize]), binary.LittleEndian, &e); err != nil {
+			return nil, err
+		}
+		typ := e.Type.ToStdEnc()
+		if typ == uuid.Nil {
+			continue
+		}
+		name := e.Name[:]
+		for n, c := range name {
+			if c == 0 {
+				name = name[:n]
+				break
+			}
 		}
-		args = append(args, fmt.Sprintf("--new=%d::%s", i+1, size))
-		args = append(args, fmt.Sprintf("--typecode=%d:%04x", i+1, gptTypes[p.ptype]))
-		if p.name != "" {
-			args = append(args, fmt.Sprintf("--change-name=%d:%s", i+1, p.name))
+		p := Partition{
+			ptype:      gptPartType(typ),
+			name:       string(utf16.Decode(name)),
+			Num:        int(i) + 1,
+			Start:      e.FirstLBA,
+			End:        e.LastLBA,
+			SectorSize: d.sectorSize,
+			TypeGUID:   typ,
+			GUID:       e.GUID.ToStdEnc(),
+			Attributes: e.Attributes,
 		}
+		p.boot = p.ptype == ESP || p.Attributes&AttrLegacyBootable != 0
+		p.sizeMegs = p.SizeBytes() / (1024 * 1024)
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+//read primary gpt, falling back to backup
+func readGpt(d *device) ([]Partition, error) {
+	parts, err := readGptAt(d, 1)
+	if err == nil {
+		return parts, nil
+	}
+	log.Logf("%s: primary gpt: %s; trying backup", d.f.Name(), err)
+	parts, berr := readGptAt(d, d.sectors-1)
+	if berr != nil {
+		return nil, fmt.Errorf("primary: %s; backup: %s", err, berr)
 	}
-	return
+	return parts, nil
 }
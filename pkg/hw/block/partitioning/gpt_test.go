@@ -8,26 +8,36 @@
 package partitioning
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
 )
 
-//func (g *Gpt) assembleArgs() (args []string)
-func TestGPTAssembleArgs(t *testing.T) {
+//func (g *gpt) layout(sectors, sectorSize uint64) error
+func TestGPTLayout(t *testing.T) {
 	tlog := testlog.NewTestLog(t, true, false)
 	p := NewGpt("/dev/null")
 	p.Add(99, ESP, true, "a")
 	p.Add(0, LinuxRaid, false, "b")
 	g := p.(*gpt)
-	a := g.assembleArgs()
-	want := `[--new=1::+99M --typecode=1:ef00 --change-name=1:a --new=2:: --typecode=2:fd00 --change-name=2:b]`
-	got := fmt.Sprintf("%v", a)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
+	const sectors = 1024 * 1024 //512M
+	check := func() {
+		t.Helper()
+		if err := g.layout(sectors, 512); err != nil {
+			t.Fatal(err)
+		}
+		a, b := g.partitions[0], g.partitions[1]
+		if a.Start != 2048 || a.End != 2048+99*2048-1 || a.TypeGUID != gptTypes[ESP] {
+			t.Errorf("bad first partition %s %s", a, a.TypeGUID)
+		}
+		if b.Start != 2048+99*2048 || b.End != sectors-34 || b.TypeGUID != gptTypes[LinuxRaid] {
+			t.Errorf("bad second partition %s %s", b, b.TypeGUID)
+		}
 	}
+	check()
 	tlog.Freeze()
 	l := tlog.Buf.String()
 	if l != "" {
@@ -36,11 +46,7 @@ func TestGPTAssembleArgs(t *testing.T) {
 
 	tlog = testlog.NewTestLog(t, true, false)
 	g.partitions[0].boot = false
-	a = g.assembleArgs()
-	got = fmt.Sprintf("%v", a)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
-	}
+	check()
 	tlog.Freeze()
 	l = tlog.Buf.String()
 	if !strings.Contains(l, "WARNING: UEFI always only boots ESP partitions") {
@@ -50,16 +56,15 @@ func TestGPTAssembleArgs(t *testing.T) {
 	tlog = testlog.NewTestLog(t, true, false)
 	g.partitions[0].boot = true
 	g.partitions[1].boot = true
-	a = g.assembleArgs()
-	got = fmt.Sprintf("%v", a)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
-	}
+	check()
 	tlog.Freeze()
 	l = tlog.Buf.String()
 	if !strings.Contains(l, "WARNING: UEFI always only boots ESP partitions") {
 		t.Errorf("expected warning in log, got %s", l)
 	}
+	if g.partitions[1].Attributes != AttrLegacyBootable {
+		t.Errorf("want legacy bootable attribute, got %x", g.partitions[1].Attributes)
+	}
 	tlog = testlog.NewTestLog(t, true, false)
 	g.committed = true
 	tlog.FatalIsNotErr = true
@@ -70,4 +75,81 @@ func TestGPTAssembleArgs(t *testing.T) {
 	} else {
 		t.Errorf("did not fail to add partition after commit: %#v", g)
 	}
+
+	g = NewGpt("/dev/null").(*gpt)
+	g.Add(600, Linux, false, "too big")
+	if err := g.layout(sectors, 512); err == nil {
+		t.Error("partition larger than disk should fail")
+	}
+}
+
+//write to an image file, read back, then damage primary gpt and read again
+func TestGPTImage(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	img := tempImg(t, 64)
+	defer os.Remove(img)
+
+	p := NewGpt(img)
+	p.Add(20, ESP, true, "ESP")
+	p.Add(10, Linux, true, "g4d")
+	p.Add(0, LinuxRaid, false, "ρoot") //non-ascii name
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		parts, err := Read(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != 3 {
+			t.Fatalf("want 3 partitions, got %v", parts)
+		}
+		want := p.(*gpt).partitions
+		for i, got := range parts {
+			w := want[i]
+			if got.Name() != w.name || got.Type() != w.ptype || got.Boot() != w.boot ||
+				got.Start != w.Start || got.End != w.End || got.GUID != w.GUID || got.Attributes != w.Attributes {
+				t.Errorf("partition %d: want %s, got %s", i+1, w, got)
+			}
+		}
+		if parts[1].SizeBytes() != 10*1024*1024 {
+			t.Errorf("bad size %d", parts[1].SizeBytes())
+		}
+	}
+	check()
+	if l := List(img); !strings.Contains(l, "gpt, 3 partition(s)") {
+		t.Errorf("unexpected List output: %s", l)
+	}
+
+	//corrupt primary header; backup should be used
+	f, err := os.OpenFile(img, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte("garbage!"), 512+24); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	check()
+}
+
+//create a sparse image file of the given size
+func tempImg(t *testing.T, megs int64) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "partitioning_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(megs * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
 }
@@ -8,9 +8,10 @@
 package partitioning
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
-	"os/exec"
-	"strings"
 
 	"github.com/purecloudlabs/gprovision/pkg/log"
 )
@@ -23,10 +24,10 @@ type mbr struct {
 
 var _ Partitioner = &mbr{}
 
-var mbrTypes map[partType]byte
+var mbrTypes map[PartType]byte
 
 func init() {
-	mbrTypes = make(map[partType]byte)
+	mbrTypes = make(map[PartType]byte)
 	mbrTypes[Unused] = 0
 	mbrTypes[FAT32] = 0x0c
 	mbrTypes[NTFS] = 0x07
@@ -34,6 +35,27 @@ func init() {
 	mbrTypes[LinuxRaid] = 0xfd
 	mbrTypes[ESP] = 0xef
 }
+
+//reverse lookup
+func mbrPartType(b byte) PartType {
+	for t, v := range mbrTypes {
+		if v == b {
+			return t
+		}
+	}
+	return Unused
+}
+
+const (
+	mbrSigOffset   = 440 //disk signature
+	mbrTableOffset = 446
+	mbrEntrySize   = 16
+	mbrEntries     = 4
+	mbrBootFlag    = 0x80
+	mbrProtective  = 0xee //type of entry in protective mbr
+	mbrMaxLBA      = 0xffffffff
+)
+
 func NewMbr(dev string) Partitioner {
 	return &mbr{device: dev}
 }
@@ -47,19 +69,23 @@ func (m *mbr) Commit() error {
 	for _, d := range m.partitions {
 		log.Logf("  - %s", d)
 	}
-
-	sfdisk := exec.Command("sfdisk", "--wipe", "always", "--wipe-partitions", "always", m.device)
-	sfdisk.Stdin = strings.NewReader(m.commands())
-	out, err := sfdisk.CombinedOutput()
+	d, err := openDev(m.device, true)
 	if err != nil {
-		log.Logf("executing %s: %s\n===\nin: %s\n===\nout: %s", sfdisk.Args, err, m.commands(), string(out))
+		return err
+	}
+	err = m.write(d)
+	if e := d.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		log.Logf("writing MBR to %s: %s", m.device, err)
 		return err
 	}
 	m.committed = true
 	return nil
 }
 
-func (m *mbr) Add(sizeMegs uint64, ptype partType, boot bool, name string) {
+func (m *mbr) Add(sizeMegs uint64, ptype PartType, boot bool, name string) {
 	if m.committed {
 		log.Fatalf("cannot add partition after partitions are written to disk")
 	}
@@ -71,18 +97,128 @@ func (m *mbr) Add(sizeMegs uint64, ptype partType, boot bool, name string) {
 	m.partitions = append(m.partitions, p)
 }
 
-func (m *mbr) commands() (cmds string) {
+//assign sectors to partitions
+func (m *mbr) layout(sectors, sectorSize uint64) error {
+	if len(m.partitions) > mbrEntries {
+		return fmt.Errorf("too many partitions (%d) for mbr", len(m.partitions))
+	}
+	last := sectors - 1
+	if last > mbrMaxLBA {
+		log.Logf("disk too large for mbr; only using first %d sectors", uint64(mbrMaxLBA)+1)
+		last = mbrMaxLBA
+	}
+	if err := place(m.partitions, 1, last, sectorSize); err != nil {
+		return err
+	}
 	for _, p := range m.partitions {
-		var size, bootable string
-		if p.sizeMegs > 0 {
-			size = fmt.Sprintf("%dM", p.sizeMegs)
+		p.MbrType = mbrTypes[p.ptype]
+		if p.End-p.Start+1 > mbrMaxLBA {
+			return fmt.Errorf("partition %d too large for mbr", p.Num)
 		}
+	}
+	return nil
+}
+
+//the 4 partition entries
+func (m *mbr) table() []byte {
+	tbl := make([]byte, mbrEntries*mbrEntrySize)
+	for i, p := range m.partitions {
+		var flag byte
 		if p.boot {
-			bootable = "*"
+			flag = mbrBootFlag
 		}
-		cmds += fmt.Sprintf(",%s,%x,%s\n", size, mbrTypes[p.ptype], bootable)
+		mbrEntry(tbl[i*mbrEntrySize:], flag, p.MbrType, p.Start, p.End)
 	}
-	return
+	return tbl
+}
+
+//fill in one 16-byte entry. lba values must already be range checked.
+func mbrEntry(e []byte, flag, ptype byte, start, end uint64) {
+	e[0] = flag
+	copy(e[1:4], chs(start))
+	e[4] = ptype
+	copy(e[5:8], chs(end))
+	binary.LittleEndian.PutUint32(e[8:], uint32(start))
+	binary.LittleEndian.PutUint32(e[12:], uint32(end-start+1))
+}
+
+//cylinder-head-sector address, using the usual 255 head/63 sector geometry.
+//addresses beyond what chs can represent are clamped, as other tools do.
+func chs(lba uint64) []byte {
+	const heads, spt = 255, 63
+	c := lba / (heads * spt)
+	if c > 1023 {
+		return []byte{254, 0xff, 0xff}
+	}
+	h := (lba / spt) % heads
+	s := lba%spt + 1
+	return []byte{byte(h), byte(s) | byte((c>>2)&0xc0), byte(c)}
+}
+
+//read sector 0 and replace everything after the boot code. creates a disk
+//signature if there isn't one.
+func mbrSector(d *device, tbl []byte) ([]byte, error) {
+	sec, err := d.read(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	for i := mbrTableOffset; i < len(sec); i++ {
+		sec[i] = 0
+	}
+	if binary.LittleEndian.Uint32(sec[mbrSigOffset:]) == 0 {
+		//must differ between disks, e.g. raid members, as it is part of PARTUUID
+		if _, err = rand.Read(sec[mbrSigOffset : mbrSigOffset+4]); err != nil {
+			return nil, err
+		}
+		sec[mbrSigOffset] |= 1 //never 0
+	}
+	copy(sec[mbrTableOffset:], tbl)
+	sec[510], sec[511] = 0x55, 0xaa
+	return sec, nil
+}
+
+//protective mbr for gpt; a single entry covering the disk
+func protectiveMbr(d *device) ([]byte, error) {
+	end := d.sectors - 1
+	if end > mbrMaxLBA {
+		end = mbrMaxLBA
+	}
+	tbl := make([]byte, mbrEntries*mbrEntrySize)
+	mbrEntry(tbl, 0, mbrProtective, 1, end)
+	sec, err := mbrSector(d, tbl)
+	if err != nil {
+		return nil, err
+	}
+	//spec requires disk signature of 0 for protective mbr
+	binary.LittleEndian.PutUint32(sec[mbrSigOffset:], 0)
+	return sec, nil
+}
+
+//write mbr, removing any gpt headers so that they aren't found instead
+func (m *mbr) write(d *device) error {
+	if err := m.layout(d.sectors, d.sectorSize); err != nil {
+		return err
+	}
+	sec, err := mbrSector(d, m.table())
+	if err != nil {
+		return err
+	}
+	if err = d.write(0, sec); err != nil {
+		return err
+	}
+	//primary gpt is before first partition and can always be zeroed
+	if err = d.zero(1, 1+gptEntrySectors(d.sectorSize)); err != nil {
+		return err
+	}
+	last := d.sectors - 1
+	hdr, err := d.read(last, 1)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(hdr, []byte(gptSig)) {
+		return d.zero(last, 1)
+	}
+	return nil
 }
 
 func (m *mbr) haveBootable() bool {
@@ -93,3 +229,30 @@ func (m *mbr) haveBootable() bool {
 	}
 	return false
 }
+
+//decode partition entries in sector 0. isGpt is true if this is a protective mbr.
+func readMbr(d *device, sec []byte) (parts []Partition, isGpt bool) {
+	for i := 0; i < mbrEntries; i++ {
+		e := sec[mbrTableOffset+i*mbrEntrySize:]
+		if e[4] == 0 {
+			continue
+		}
+		if e[4] == mbrProtective {
+			return nil, true
+		}
+		start := uint64(binary.LittleEndian.Uint32(e[8:]))
+		size := uint64(binary.LittleEndian.Uint32(e[12:]))
+		p := Partition{
+			boot:       e[0] == mbrBootFlag,
+			ptype:      mbrPartType(e[4]),
+			Num:        i + 1,
+			Start:      start,
+			End:        start + size - 1,
+			SectorSize: d.sectorSize,
+			MbrType:    e[4],
+		}
+		p.sizeMegs = p.SizeBytes() / (1024 * 1024)
+		parts = append(parts, p)
+	}
+	return parts, false
+}
@@ -8,24 +8,33 @@
 package partitioning
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
 )
 
-//func (m *mbr) commands() (cmds string)
-func TestMBRCommands(t *testing.T) {
+//func (m *mbr) table() []byte
+func TestMBRTable(t *testing.T) {
 	tlog := testlog.NewTestLog(t, true, false)
 	p := NewMbr("/dev/null")
 	p.Add(99, ESP, true, "a")
 	p.Add(0, LinuxRaid, false, "b")
 	m := p.(*mbr)
-	cmds := m.commands()
-	want := ",99M,ef,*\n,,fd,\n"
-	got := fmt.Sprintf("%v", cmds)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
+	if err := m.layout(1024*1024, 512); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x80, 0x20, 0x21, 0x00, 0xef, 0xbe, 0x32, 0x0c, 0x00, 0x08, 0, 0, 0x00, 0x18, 0x03, 0,
+		0x00, 0xbe, 0x33, 0x0c, 0xfd, 0x45, 0x04, 0x41, 0x00, 0x20, 0x03, 0, 0x00, 0xe0, 0x0c, 0,
+	}
+	got := m.table()
+	if !bytes.Equal(got[:32], want) || !bytes.Equal(got[32:], make([]byte, 32)) {
+		t.Errorf("\nwant %x\ngot  %x", want, got)
 	}
 	tlog.Freeze()
 	l := tlog.Buf.String()
@@ -38,27 +47,27 @@ func TestMBRCommands(t *testing.T) {
 	p.Add(99, ESP, true, "c")
 	p.Add(0, LinuxRaid, true, "d")
 	m = p.(*mbr)
-	cmds = m.commands()
-	got = fmt.Sprintf("%v", cmds)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
+	if err := m.layout(1024*1024, 512); err != nil {
+		t.Fatal(err)
+	}
+	got = m.table()
+	if !bytes.Equal(got[:32], want) {
+		t.Errorf("\nwant %x\ngot  %x", want, got)
 	}
 	tlog.Freeze()
 	l = tlog.Buf.String()
-	if t.Failed() {
-		t.Logf("log output: %s", l)
+	if !strings.Contains(l, "ignoring boot flag for partition #2") {
+		t.Errorf("expected message in log, got %q", l)
 	}
 
 	tlog = testlog.NewTestLog(t, true, false)
 	p = NewMbr("/dev/null")
-	p.Add(99, ESP, false, "e")
-	p.Add(0, LinuxRaid, false, "f")
+	for i := 0; i < 5; i++ {
+		p.Add(1, Linux, false, "")
+	}
 	m = p.(*mbr)
-	cmds = m.commands()
-	want = ",99M,ef,\n,,fd,\n"
-	got = fmt.Sprintf("%v", cmds)
-	if want != got {
-		t.Errorf("\nwant %s\ngot  %s", want, got)
+	if err := m.layout(1024*1024, 512); err == nil {
+		t.Error("5 partitions should fail")
 	}
 
 	m.committed = true
@@ -74,5 +83,129 @@ func TestMBRCommands(t *testing.T) {
 	if t.Failed() {
 		t.Logf("log output: %s", l)
 	}
+}
 
+//func chs(lba uint64) []byte
+func TestCHS(t *testing.T) {
+	for _, td := range []struct {
+		lba  uint64
+		want []byte
+	}{
+		{0, []byte{0, 1, 0}},
+		{2048, []byte{0x20, 0x21, 0}},
+		{16450559, []byte{254, 0xff, 0xff}}, //last addressable
+		{1 << 30, []byte{254, 0xff, 0xff}},
+	} {
+		if got := chs(td.lba); !bytes.Equal(got, td.want) {
+			t.Errorf("%d: want %x, got %x", td.lba, td.want, got)
+		}
+	}
+}
+
+//overwrite gpt with mbr in an image file, and read back
+func TestMBRImage(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	img := tempImg(t, 32)
+	defer os.Remove(img)
+
+	g := NewGpt(img)
+	g.Add(0, Linux, false, "gpt")
+	if err := g.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	p := NewMbr(img)
+	p.Add(10, Linux, true, "")
+	p.Add(5, FAT32, false, "")
+	p.Add(0, LinuxRaid, false, "")
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := Read(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("want 3 partitions, got %v", parts)
+	}
+	for i, got := range parts {
+		w := p.(*mbr).partitions[i]
+		if got.Type() != w.ptype || got.Boot() != w.boot || got.Start != w.Start || got.End != w.End {
+			t.Errorf("partition %d: want %s, got %s", i+1, w, got)
+		}
+	}
+	if parts[2].End != 32*2048-1 {
+		t.Errorf("last partition should end at end of disk, got %d", parts[2].End)
+	}
+	if l := List(img); !strings.Contains(l, "mbr, 3 partition(s)") {
+		t.Errorf("unexpected List output: %s", l)
+	}
+}
+
+//rewriting the mbr must keep an existing disk signature
+func TestMBRSignature(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	img := tempImg(t, 8)
+	defer os.Remove(img)
+
+	sig := []byte{0xef, 0xbe, 0xad, 0xde}
+	f, err := os.OpenFile(img, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(sig, mbrSigOffset)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewMbr(img)
+	p.Add(0, Linux, true, "")
+	if err = p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := make([]byte, len(sig))
+	if _, err = f.ReadAt(got, mbrSigOffset); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, sig) {
+		t.Errorf("disk signature changed: want %x, got %x", sig, got)
+	}
+
+	//blank disks get distinct, non-zero signatures
+	var sigs [2]uint32
+	for i := range sigs {
+		blank := tempImg(t, 8)
+		defer os.Remove(blank)
+		p = NewMbr(blank)
+		p.Add(0, Linux, true, "")
+		if err = p.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(blank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs[i] = binary.LittleEndian.Uint32(b[mbrSigOffset:])
+	}
+	if sigs[0] == 0 || sigs[0] == sigs[1] {
+		t.Errorf("want distinct non-zero signatures, got %x and %x", sigs[0], sigs[1])
+	}
 }
@@ -53,6 +53,10 @@ func TestBlkIdParse(t *testing.T) {
 			BlkInfo{FsExfat, "magic", true, "majik", "", "none", ""}, false},
 		{`/dev/nvme0n1p1: SEC_TYPE="msdos" LABEL_FATBOOT="ESP" LABEL="ESP" UUID="3AB2-ADD3" TYPE="vfat" PARTLABEL="ESP" PARTUUID="81635ccd-1b4f-4d3f-b7b7-f78a5b029f35"`,
 			BlkInfo{FsFat, "3AB2-ADD3", true, "81635ccd-1b4f-4d3f-b7b7-f78a5b029f35", "ESP", "filesystem", ""}, false},
+		{`/dev/sda2: LABEL="data" UUID="0b2e4c1a-6f38-4d0e-9a51-2f1d6a7c8e90" TYPE="xfs" PARTLABEL="data" PARTUUID="5d1c6b0e-02"`,
+			BlkInfo{FsXfs, "0b2e4c1a-6f38-4d0e-9a51-2f1d6a7c8e90", true, "5d1c6b0e-02", "data", "filesystem", ""}, false},
+		{`/dev/md0: LABEL="root" UUID="8f3a9d52-1c47-4b2e-a6d0-7e5f0c9b1a23" UUID_SUB="2a7e" TYPE="btrfs"`,
+			BlkInfo{FsBtrfs, "8f3a9d52-1c47-4b2e-a6d0-7e5f0c9b1a23", true, "", "root", "filesystem", ""}, false},
 	}
 	for i, o := range testData {
 		binfo, err := parseBlkidOut([]byte(o.line))
@@ -46,6 +46,21 @@ func Mock(m Model, wg *sync.WaitGroup, useHex bool) (sd *SerialDev) {
 	return
 }
 
+//MockLcd connects to a mock lcd, for use in other packages' tests. keys are
+//queued as if pressed by the user, and are consumed by Menu, YesNo, etc.
+func MockLcd(m Model, wg *sync.WaitGroup, keys ...KeyActivity) (*Lcd, error) {
+	sd := Mock(m, wg, false)
+	sd.MinPktInterval = time.Microsecond
+	if len(keys) > cap(sd.Events) {
+		sd.Close()
+		return nil, fmt.Errorf("too many keys (%d), max %d", len(keys), cap(sd.Events))
+	}
+	for _, k := range keys {
+		sd.Events <- k
+	}
+	return connectTo(sd)
+}
+
 type mockPort struct {
 	closed     bool
 	buf        *bytes.Buffer
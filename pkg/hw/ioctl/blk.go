@@ -19,3 +19,10 @@ func BlkGetSize64(f FDer) (uint64, error) {
 	BLKGETSIZE64 := 0x80081272
 	return Ioctl1(f.Fd(), BLKGETSIZE64)
 }
+
+//BLKRRPART - ask kernel to re-read partition table
+func BlkRereadPart(f FDer) error {
+	BLKRRPART := 0x125f
+	_, err := Ioctl1(f.Fd(), BLKRRPART)
+	return err
+}
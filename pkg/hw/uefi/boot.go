@@ -22,9 +22,10 @@
 type BootLabel string
 
 const (
-	BootLabelFR    BootLabel = "Forced Factory Restore"
-	BootLabelNorm            = "Normal Boot"
-	BootLabelErase           = "Data Erase (DANGER!)"
+	BootLabelFR     BootLabel = "Forced Factory Restore"
+	BootLabelNorm             = "Normal Boot"
+	BootLabelErase            = "Data Erase (DANGER!)"
+	BootLabelSdBoot           = "Boot Menu (systemd-boot)"
 )
 
 type BootEntry struct {
@@ -34,6 +35,10 @@ type BootEntry struct {
 	AbsPath  string
 	Args     string
 	autoBoot bool
+
+	//if set, entries are systemd-boot loader entries rather than firmware
+	//boot entries; see SdBoot
+	SdBoot *SdBoot
 }
 
 /* use forward slashes in -l path.
@@ -94,6 +99,16 @@ func RemoveBootEntry(num uint16) error {
 	return err
 }
 
+//SetBootNext makes the firmware use the given entry for the next boot only.
+func SetBootNext(num uint16) error {
+	next := exec.Command("efibootmgr", "--bootnext", fmt.Sprintf("%04X", num))
+	out, err := next.CombinedOutput()
+	if err != nil {
+		log.Logf("executing %v: error %s\nout: %s", next.Args, err, string(out))
+	}
+	return err
+}
+
 /*
 efibootmgr's output is thoroughly horrible
 read efi variables directly instead of using efibootmgr to show entries
@@ -102,16 +117,28 @@ func RemoveBootEntry(num uint16) error {
 //for uefi, do we care about having multiple partitions each containing norm_boot?
 //secure boot?
 
-func (entries BootEntryVars) OursPresent() bool {
+//OursPresent returns true if all of our entries are present for the given
+//layout - three firmware entries if sd is nil, or one firmware entry for
+//systemd-boot and three loader entries otherwise.
+func (entries BootEntryVars) OursPresent(sd *SdBoot) bool {
 	ours := entries.Ours()
-	if len(ours) != 3 {
+	if sd != nil {
+		if len(ours) != 1 || BootLabel(ours[0].Description) != BootLabelSdBoot {
+			return false
+		}
+	} else if len(ours) != 3 {
 		return false
 	}
-	norm, fr, erase := ours.CheckMissing()
+	norm, fr, erase := ours.CheckMissing(sd)
 	return norm && fr && erase
 }
 
-func (entries BootEntryVars) CheckMissing() (haveNormal, haveFR, haveErase bool) {
+//CheckMissing determines which of our entries exist. If sd is non-nil, these
+//are systemd-boot loader entries and the firmware entries are ignored.
+func (entries BootEntryVars) CheckMissing(sd *SdBoot) (haveNormal, haveFR, haveErase bool) {
+	if sd != nil {
+		return sd.Present(BootLabelNorm), sd.Present(BootLabelFR), sd.Present(BootLabelErase)
+	}
 	for _, e := range entries {
 		switch BootLabel(e.Description) {
 		case BootLabelFR:
@@ -125,14 +152,74 @@ func (entries BootEntryVars) CheckMissing() (haveNormal, haveFR, haveErase bool)
 	return
 }
 
+//OtherLayout returns our entries which don't belong to the layout selected by
+//sd, i.e. those left over from a different boot backend.
+func (entries BootEntryVars) OtherLayout(sd *SdBoot) (other BootEntryVars) {
+	for _, e := range entries.Ours() {
+		if (BootLabel(e.Description) == BootLabelSdBoot) != (sd != nil) {
+			other = append(other, e)
+		}
+	}
+	return
+}
+
+//NormalEntry returns our entry used for normal boot, which is systemd-boot's
+//if present, or nil if there is none.
+func (entries BootEntryVars) NormalEntry() *BootEntryVar {
+	var norm *BootEntryVar
+	for _, e := range entries.Ours() {
+		switch BootLabel(e.Description) {
+		case BootLabelSdBoot:
+			return e
+		case BootLabelNorm:
+			norm = e
+		}
+	}
+	return norm
+}
+
+func (entries BootEntryVars) has(l BootLabel) bool {
+	for _, e := range entries {
+		if BootLabel(e.Description) == l {
+			return true
+		}
+	}
+	return false
+}
+
+//FixMissing adds any missing entries. If baseEntry.SdBoot is set, systemd-boot
+//is installed if its firmware entry is missing, and missing loader entries
+//are added.
 func (entries BootEntryVars) FixMissing(baseEntry BootEntry, extraOpts string) {
-	n, f, e := entries.CheckMissing()
+	n, f, e := entries.CheckMissing(baseEntry.SdBoot)
+	if baseEntry.SdBoot != nil {
+		fixMissingSdBoot(baseEntry, entries.has(BootLabelSdBoot), n, f, e, extraOpts)
+		return
+	}
 	fixMissing(baseEntry, n, f, e, extraOpts)
 }
+
+//kernel args for the entry with the given label
+func entryArgs(l BootLabel, baseArgs, extraOpts string) string {
+	switch l {
+	case BootLabelErase:
+		return strs.EraseEnv() + "=1"
+	case BootLabelNorm:
+		/* for legacy units, we pass the root volume's uuid as a boot arg. however,
+		   that uuid will change with each factory restore as the fs is created anew.
+		   if the uefi boot entry is updated, that means the "nvram" (actually flash)
+		   is written, and getting into a factory restore loop could wear out the flash.
+		   to avoid this, we use LABEL=... instead
+		*/
+		return strings.Join([]string{baseArgs, "real_root=LABEL=" + strs.PriVolName(), extraOpts}, " ")
+	}
+	return baseArgs
+}
+
 func fixMissing(baseEntry BootEntry, haveNormal, haveFR, haveErase bool, extraOpts string) {
 	if !haveErase {
 		b := baseEntry
-		b.Args = strs.EraseEnv() + "=1"
+		b.Args = entryArgs(BootLabelErase, b.Args, extraOpts)
 		b.Label = BootLabelErase
 		AddBootEntry(b)
 	}
@@ -143,15 +230,35 @@ func fixMissing(baseEntry BootEntry, haveNormal, haveFR, haveErase bool, extraOp
 	}
 	if !haveNormal {
 		b := baseEntry
-		/* for legacy units, we pass the root volume's uuid as a boot arg. however,
-		   that uuid will change with each factory restore as the fs is created anew.
-		   if the uefi boot entry is updated, that means the "nvram" (actually flash)
-		   is written, and getting into a factory restore loop could wear out the flash.
-		   to avoid this, we use LABEL=... instead
-		*/
-		b.Args = strings.Join([]string{b.Args, "real_root=LABEL=" + strs.PriVolName(), extraOpts}, " ")
+		b.Args = entryArgs(BootLabelNorm, b.Args, extraOpts)
 		b.Label = BootLabelNorm
 		b.autoBoot = true
 		AddBootEntry(b)
 	}
 }
+
+func fixMissingSdBoot(baseEntry BootEntry, haveLoader, haveNormal, haveFR, haveErase bool, extraOpts string) {
+	sd := baseEntry.SdBoot
+	for l, have := range map[BootLabel]bool{BootLabelErase: haveErase, BootLabelFR: haveFR, BootLabelNorm: haveNormal} {
+		if have {
+			continue
+		}
+		if err := sd.AddEntry(l, entryArgs(l, baseEntry.Args, extraOpts)); err != nil {
+			log.Logf("cannot add loader entry %s: %s", l, err)
+			log.Fatalf("failed to add loader entry")
+		}
+	}
+	if haveLoader {
+		return
+	}
+	if err := sd.Install(); err != nil {
+		log.Logf("cannot install systemd-boot: %s", err)
+		log.Fatalf("failed to install systemd-boot")
+	}
+	b := baseEntry
+	b.Label = BootLabelSdBoot
+	b.AbsPath = SdBootPath
+	b.Args = ""
+	b.autoBoot = true
+	AddBootEntry(b)
+}
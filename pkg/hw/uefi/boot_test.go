@@ -14,16 +14,31 @@
 //func OursPresent(entries BootEntryVars)bool
 func TestOursPresent(t *testing.T) {
 	entries := AllBootEntryVars()
-	h := entries.OursPresent()
+	h := entries.OursPresent(nil)
 	if h {
 		t.Error("expect false")
 	}
 	old := efiVarDir
 	efiVarDir = "testdata/sys_firmware_efi_vars_2"
 	entries = AllBootEntryVars()
-	h = entries.OursPresent()
+	h = entries.OursPresent(nil)
 	if !h {
 		t.Error("expect true")
 	}
 	efiVarDir = old
 }
+
+func TestNormalEntry(t *testing.T) {
+	norm := &BootEntryVar{Number: 1, EfiLoadOption: EfiLoadOption{Description: BootLabelNorm}}
+	fr := &BootEntryVar{Number: 2, EfiLoadOption: EfiLoadOption{Description: string(BootLabelFR)}}
+	loader := &BootEntryVar{Number: 3, EfiLoadOption: EfiLoadOption{Description: BootLabelSdBoot}}
+	if e := (BootEntryVars{fr}).NormalEntry(); e != nil {
+		t.Errorf("want nil, got %v", e)
+	}
+	if e := (BootEntryVars{fr, norm}).NormalEntry(); e != norm {
+		t.Errorf("want normal entry, got %v", e)
+	}
+	if e := (BootEntryVars{norm, loader, fr}).NormalEntry(); e != loader {
+		t.Errorf("want systemd-boot entry, got %v", e)
+	}
+}
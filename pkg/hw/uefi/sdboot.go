@@ -0,0 +1,159 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package uefi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+/* With systemd-boot, there is a single firmware boot entry (BootLabelSdBoot),
+   pointing at systemd-boot on the ESP. Normal boot, factory restore, and data
+   erase are systemd-boot loader entries, each of which boots a Unified Kernel
+   Image (kernel, optional initramfs, and cmdline in one PE binary) built with
+   ukify. Since the cmdline is inside the image, it can't be edited at
+   boot time.
+
+   ESP layout:
+     /EFI/systemd/systemd-bootx64.efi
+     /EFI/Linux/<id>.efi
+     /loader/loader.conf
+     /loader/entries/<id>.conf
+*/
+
+//SdBoot describes a systemd-boot install on an ESP. Set BootEntry.SdBoot to
+//use it rather than booting the kernel's EFI stub directly.
+type SdBoot struct {
+	ESP    string //mount point of the ESP
+	Kernel string //kernel from which UKIs are built
+	Initrd string //optional initramfs to include in UKIs
+	Stub   string //uki stub; DefaultUKIStub if empty
+	Loader string //systemd-boot binary to install; DefaultSdBootBin if empty
+}
+
+const (
+	DefaultSdBootBin = "/usr/lib/systemd/boot/efi/systemd-bootx64.efi"
+	DefaultUKIStub   = "/usr/lib/systemd/boot/efi/linuxx64.efi.stub"
+	SdBootPath       = "/EFI/systemd/systemd-bootx64.efi" //relative to ESP
+)
+
+//loader entry id (file name without extension) for each of our boot labels
+var sdBootIDs = map[BootLabel]string{
+	BootLabelNorm:  "norm_boot",
+	BootLabelFR:    "factory_restore",
+	BootLabelErase: "data_erase",
+}
+
+//UKI returns the path of the UKI for the given label, relative to the ESP.
+func (sd *SdBoot) UKI(l BootLabel) string {
+	return "/EFI/Linux/" + sdBootIDs[l] + ".efi"
+}
+
+func (sd *SdBoot) entryPath(l BootLabel) string {
+	return fp.Join(sd.ESP, "loader", "entries", sdBootIDs[l]+".conf")
+}
+
+//Present returns true if the loader entry for l and its UKI exist, and the UKI
+//is not older than the kernel. A stale UKI is treated as missing so that it is
+//rebuilt when the kernel is updated.
+func (sd *SdBoot) Present(l BootLabel) bool {
+	if _, err := os.Stat(sd.entryPath(l)); err != nil {
+		return false
+	}
+	uki, err := os.Stat(fp.Join(sd.ESP, sd.UKI(l)))
+	if err != nil {
+		return false
+	}
+	if k, err := os.Stat(sd.Kernel); err == nil && k.ModTime().After(uki.ModTime()) {
+		log.Logf("%s is older than %s", sd.UKI(l), sd.Kernel)
+		return false
+	}
+	return true
+}
+
+//Install copies systemd-boot to the ESP and writes loader.conf, with normal
+//boot as the default.
+func (sd *SdBoot) Install() error {
+	src := sd.Loader
+	if src == "" {
+		src = DefaultSdBootBin
+	}
+	dest := fp.Join(sd.ESP, SdBootPath)
+	if err := os.MkdirAll(fp.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := futil.CopyFile(src, dest, 0); err != nil {
+		return err
+	}
+	return sd.writeLoaderConf()
+}
+
+func (sd *SdBoot) writeLoaderConf() error {
+	dir := fp.Join(sd.ESP, "loader")
+	if err := os.MkdirAll(fp.Join(dir, "entries"), 0755); err != nil {
+		return err
+	}
+	//menu is hidden unless a key is pressed; the editor would allow the
+	//cmdline to be changed
+	conf := fmt.Sprintf("default %s.conf\ntimeout 0\neditor no\n", sdBootIDs[BootLabelNorm])
+	return ioutil.WriteFile(fp.Join(dir, "loader.conf"), []byte(conf), 0644)
+}
+
+//AddEntry builds the UKI for l with the given cmdline and writes its loader
+//entry, replacing any existing ones.
+func (sd *SdBoot) AddEntry(l BootLabel, cmdline string) error {
+	uki := fp.Join(sd.ESP, sd.UKI(l))
+	if err := os.MkdirAll(fp.Dir(uki), 0755); err != nil {
+		return err
+	}
+	build := exec.Command("ukify", sd.ukifyArgs(uki, cmdline)...)
+	out, err := build.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s\n%s", build.Args, err, out)
+	}
+	if err = os.MkdirAll(fp.Dir(sd.entryPath(l)), 0755); err != nil {
+		return err
+	}
+	log.Logf("adding loader entry for %s", string(l))
+	return ioutil.WriteFile(sd.entryPath(l), sd.loaderEntry(l), 0644)
+}
+
+func (sd *SdBoot) ukifyArgs(out, cmdline string) []string {
+	stub := sd.Stub
+	if stub == "" {
+		stub = DefaultUKIStub
+	}
+	args := []string{"build", "--linux=" + sd.Kernel, "--stub=" + stub, "--cmdline=" + cmdline}
+	if sd.Initrd != "" {
+		args = append(args, "--initrd="+sd.Initrd)
+	}
+	return append(args, "--output="+out)
+}
+
+//loader entry pointing at a UKI; cmdline is in the UKI
+func (sd *SdBoot) loaderEntry(l BootLabel) []byte {
+	return []byte(fmt.Sprintf("title %s\nefi %s\n", string(l), sd.UKI(l)))
+}
+
+//RemoveEntries removes our loader entries and UKIs, forcing them to be
+//recreated by FixMissing.
+func (sd *SdBoot) RemoveEntries() {
+	for l := range sdBootIDs {
+		for _, f := range []string{sd.entryPath(l), fp.Join(sd.ESP, sd.UKI(l))} {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				log.Logf("removing %s: %s", f, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package uefi
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+//write the files for a loader entry, without running ukify
+func fakeEntry(t *testing.T, sd *SdBoot, l BootLabel) {
+	uki := fp.Join(sd.ESP, sd.UKI(l))
+	for _, f := range []string{uki, sd.entryPath(l)} {
+		if err := os.MkdirAll(fp.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(uki, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sd.entryPath(l), sd.loaderEntry(l), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSdBootCheckMissing(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	tmp, err := ioutil.TempDir("", "gp-sdboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	sd := &SdBoot{ESP: tmp, Kernel: fp.Join(tmp, "norm_boot")}
+	if err = ioutil.WriteFile(sd.Kernel, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(sd.Kernel, old, old); err != nil {
+		t.Fatal(err)
+	}
+	loader := &BootEntryVar{EfiLoadOption: EfiLoadOption{Description: BootLabelSdBoot}}
+	stub := &BootEntryVar{EfiLoadOption: EfiLoadOption{Description: BootLabelNorm}}
+	entries := BootEntryVars{loader}
+
+	fakeEntry(t, sd, BootLabelNorm)
+	fakeEntry(t, sd, BootLabelErase)
+	n, f, e := entries.CheckMissing(sd)
+	if !n || f || !e {
+		t.Errorf("want normal and erase, got %t %t %t", n, f, e)
+	}
+	if entries.OursPresent(sd) {
+		t.Error("factory restore entry is missing")
+	}
+	fakeEntry(t, sd, BootLabelFR)
+	if !entries.OursPresent(sd) {
+		t.Error("want all present")
+	}
+	if entries.OursPresent(nil) {
+		t.Error("stub entries are not present")
+	}
+	if other := append(entries, stub).OtherLayout(sd); len(other) != 1 || other[0] != stub {
+		t.Errorf("want stub entry in other layout, got %v", other)
+	}
+	if other := append(entries, stub).OtherLayout(nil); len(other) != 1 || other[0] != loader {
+		t.Errorf("want loader entry in other layout, got %v", other)
+	}
+
+	//updated kernel makes UKIs stale
+	if err = os.Chtimes(sd.Kernel, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n, f, e = entries.CheckMissing(sd); n || f || e {
+		t.Errorf("stale UKIs should be missing, got %t %t %t", n, f, e)
+	}
+	sd.RemoveEntries()
+	if _, err = os.Stat(fp.Join(tmp, sd.UKI(BootLabelNorm))); !os.IsNotExist(err) {
+		t.Errorf("UKI not removed: %v", err)
+	}
+	tlog.Freeze()
+}
+
+func TestSdBootFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gp-sdboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	sd := &SdBoot{ESP: tmp, Kernel: "/k", Initrd: "/i"}
+	args := strings.Join(sd.ukifyArgs("/out.efi", "a=b c"), " ")
+	want := "build --linux=/k --stub=" + DefaultUKIStub + " --cmdline=a=b c --initrd=/i --output=/out.efi"
+	if args != want {
+		t.Errorf("ukify args:\nwant %s\ngot  %s", want, args)
+	}
+	entry := string(sd.loaderEntry(BootLabelErase))
+	if entry != "title Data Erase (DANGER!)\nefi /EFI/Linux/data_erase.efi\n" {
+		t.Errorf("bad loader entry %q", entry)
+	}
+	if err = sd.writeLoaderConf(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := ioutil.ReadFile(fp.Join(tmp, "loader", "loader.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(conf), "default norm_boot.conf\n") {
+		t.Errorf("bad loader.conf %q", conf)
+	}
+	if got := entryArgs(BootLabelNorm, "quiet", "x=y"); !strings.HasPrefix(got, "quiet real_root=LABEL=") || !strings.HasSuffix(got, " x=y") {
+		t.Errorf("bad normal args %q", got)
+	}
+}
@@ -297,6 +297,8 @@ func (b BootEntryVar) IsOurs() bool {
 		return true
 	case BootLabelErase:
 		return true
+	case BootLabelSdBoot:
+		return true
 	}
 	return false
 }
@@ -0,0 +1,69 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// +build !mfg
+
+package init
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/ab"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+//If the unit has A/B root slots, returns the device of the slot to boot and
+//whether it exists, counting a boot attempt of a pending slot and falling back
+//from it as needed. Otherwise, returns rootDev and found unchanged. update is
+//true if the OS requested an update, in which case the request is cleared
+//(so a failed update isn't retried forever) and no slot is chosen; requests
+//are left alone unless allowUpdate is true.
+//
+//Slot B's fs only exists once an update has been written to it, so until
+//then, slot A is booted as on any other unit.
+func abRoot(rootDev string, found, allowUpdate bool) (dev string, exists, update bool) {
+	if _, err := os.Stat(ab.B.Dev()); err != nil {
+		return rootDev, found, false
+	}
+	var slot ab.Slot
+	err := withRecovery(func(u common.Unit) error {
+		rpath := u.Rec.Path()
+		st, err := ab.Load(rpath)
+		if err != nil {
+			return err
+		}
+		if st.UpdateRequested && allowUpdate {
+			update = true
+			st.UpdateRequested = false
+			return st.Write(rpath)
+		}
+		var failed string
+		slot, failed = st.Next()
+		if failed != "" {
+			history.SetRoot(rpath)
+			note := fmt.Sprintf("not confirmed after %d boots; rolled back to slot %s", ab.MaxAttempts, slot)
+			history.RecordBootState(failed, false, 1, time.Now(), note)
+		}
+		return st.Write(rpath)
+	})
+	if err != nil {
+		log.Logf("reading slot state, booting %s: %s", rootDev, err)
+		return rootDev, found, false
+	}
+	if update {
+		return rootDev, found, true
+	}
+	if verbose {
+		log.Logf("booting slot %s", slot)
+	}
+	_, err = os.Stat(slot.Dev())
+	return slot.Dev(), err == nil, false
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+// +build !mfg
+
+package init
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common"
+	"github.com/purecloudlabs/gprovision/pkg/common/stash"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/luks"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
+)
+
+//max time to wait for either root or an encrypted container to appear
+var cryptWait = 3 * time.Second
+
+//If the primary volume is in a LUKS container, unlock it so that rootDev can
+//appear. Tries a tpm2 token first, then the key from the Stasher. Returns
+//early if rootDev appears, as it will if there is no encryption.
+func unlockRoot(rootDev string) {
+	cryptDev := luks.RootDev()
+	deadline := time.Now().Add(cryptWait)
+	for {
+		if _, err := os.Stat(rootDev); err == nil {
+			return
+		}
+		if _, err := os.Stat(cryptDev); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(time.Second / 4)
+	}
+	if luks.IsActive(luks.RootName) {
+		return
+	}
+	if verbose {
+		log.Logf("unlocking %s", cryptDev)
+	}
+	_, err := luks.OpenToken(cryptDev, luks.RootName)
+	if err == nil {
+		return
+	}
+	log.Logf("unlock %s with tpm2: %s", cryptDev, err)
+	key, err := diskKey()
+	if err != nil {
+		log.Logf("reading disk key: %s", err)
+		return
+	}
+	if _, err = luks.Open(cryptDev, luks.RootName, key, false); err != nil {
+		log.Logf("unlock %s: %s", cryptDev, err)
+	}
+}
+
+//get the disk key from the Stasher, which requires the recovery volume
+func diskKey() (key []byte, err error) {
+	err = withRecovery(func(u common.Unit) error {
+		stash.SetUnit(u)
+		key, err = stash.ReadDiskKey()
+		return err
+	})
+	return
+}
+
+//recovery volume mounted by withRecovery, shared by the checks made before
+//booting (interrupted erase, disk key, A/B slot) so that it is mounted once
+var (
+	sharedRecov *disk.Filesystem
+	sharedUnit  common.Unit
+)
+
+//identify the platform and mount the recovery volume, if not already mounted,
+//then run fn. The volume stays mounted until releaseRecovery is called.
+func withRecovery(fn func(u common.Unit) error) error {
+	if sharedRecov == nil {
+		plat, err := appliance.IdentifyWithFallback(disk.PlatIdentFromRecovery)
+		if err != nil {
+			return err
+		}
+		recov := disk.FindRecovery(plat)
+		if recov == nil {
+			return errors.New("no recovery volume")
+		}
+		if _, err = recov.MountErr(); err != nil {
+			return err
+		}
+		sharedRecov = recov
+		sharedUnit = common.Unit{Platform: plat, Rec: recov}
+	}
+	return fn(sharedUnit)
+}
+
+//unmount the volume mounted by withRecovery, if any. Must be called before
+//switching root or handing off to code that mounts the volume itself.
+func releaseRecovery() {
+	if sharedRecov == nil {
+		return
+	}
+	sharedRecov.Umount()
+	sharedRecov, sharedUnit = nil, common.Unit{}
+}
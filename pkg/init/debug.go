@@ -38,10 +38,6 @@ func testOpts() {
 	switch os.Getenv(strs.IntegEnv()) {
 	case "ck":
 		log.Logf("INTEG_TEST: ck")
-		if fsKey != nil {
-			//load fs encryption key
-			fsKey.LoadKey()
-		}
 	default:
 		return
 	}
@@ -213,10 +213,3 @@ func commonEnvVars() {
 	}
 	os.Setenv("PATH", "/sbin:/bin:/usr/bin:/usr/sbin")
 }
-
-//Loads an encryption key into the kernel keyring
-type KeyLoader interface {
-	LoadKey()
-}
-
-var fsKey KeyLoader
@@ -17,6 +17,7 @@
 	"strings"
 	"time"
 
+	"github.com/purecloudlabs/gprovision/pkg/common"
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/erase"
 	"github.com/purecloudlabs/gprovision/pkg/hw/block"
@@ -127,6 +128,15 @@ func tryNormalBoot(real_root string, uproc *os.Process) {
 			log.Logf("error %s locating lcd", err)
 		}
 	}
+	if eraseInterrupted() {
+		if verbose {
+			log.Logf("resuming erase")
+		}
+		_, _ = cfa.DefaultLcd.Msg("Resuming erase...")
+		releaseRecovery()
+		erase.Main()
+		power.RebootSuccess()
+	}
 	if verbose {
 		log.Logf("md assemble")
 	}
@@ -135,6 +145,7 @@ func tryNormalBoot(real_root string, uproc *os.Process) {
 		log.Logf("search for root dev")
 	}
 	rootDev := getRoot(real_root)
+	unlockRoot(rootDev)
 	if verbose {
 		log.Logf("user input")
 		log.Logf("will search for root device %s", rootDev)
@@ -145,13 +156,15 @@ func tryNormalBoot(real_root string, uproc *os.Process) {
 		precedence:
 			- user button press
 			- emergency file
-			- real root
+			- A/B slot update requested by the OS
+			- real root (or A/B slot)
 		if nothing is found, return - which triggers factory restore
 	*/
 	if pressed {
 		if verbose {
 			log.Logf("boot menu")
 		}
+		releaseRecovery()
 		bootMenu(rootDev, foundRoot, uproc)
 	} else if len(emergencyFiles) != 0 {
 		if verbose {
@@ -159,24 +172,66 @@ func tryNormalBoot(real_root string, uproc *os.Process) {
 		}
 
 		_, _ = cfa.DefaultLcd.Msg("Emergency-mode file found. Processing...")
+		releaseRecovery()
 		recovery.WithEmergencyFile(emergencyFiles)
 		power.RebootSuccess()
-	} else if foundRoot {
-		if verbose {
-			log.Logf("switch root")
+	} else {
+		//only read slot state here, so that a boot attempt is counted (or
+		//an update request cleared) only when actually booting
+		var update bool
+		rootDev, foundRoot, update = abRoot(rootDev, foundRoot, true)
+		releaseRecovery()
+		if update {
+			if verbose {
+				log.Logf("slot update")
+			}
+			_, _ = cfa.DefaultLcd.Msg("Updating...")
+			recovery.WithSlotUpdate()
+			power.RebootSuccess()
+		} else if foundRoot {
+			if verbose {
+				log.Logf("switch root")
+			}
+			_, _ = cfa.DefaultLcd.Msg("Continuing normal boot...")
+			switchroot(rootDev, uproc)
 		}
-		_, _ = cfa.DefaultLcd.Msg("Continuing normal boot...")
-		switchroot(rootDev, uproc)
 	}
 }
 
+//boot the A/B slot due, if any, else rootDev. Leaves any update request for
+//the next boot.
+func switchSlot(rootDev string, foundRoot bool, uproc *os.Process) {
+	rootDev, _, _ = abRoot(rootDev, foundRoot, false)
+	releaseRecovery()
+	switchroot(rootDev, uproc)
+}
+
+//An erase interrupted by power loss must be finished before anything else, as
+//the unit may be partially erased.
+func eraseInterrupted() (found bool) {
+	err := withRecovery(func(u common.Unit) error {
+		found = erase.Interrupted(u.Rec.Path())
+		return nil
+	})
+	if err != nil && verbose {
+		log.Logf("checking for interrupted erase: %s", err)
+	}
+	return
+}
+
 func switchroot(rootDev string, uproc *os.Process) {
 	//mount root on /newroot
 	err := os.Mkdir(consts.NewRoot, 0755)
 	if err != nil && !os.IsExist(err) {
 		log.Logf("failed to create newroot: %s", err)
 	}
-	err = mount.Mount(rootDev, consts.NewRoot, "ext4", "", unix.MS_RDONLY)
+	//root may be ext4, xfs, or btrfs; see appliance.PartitionLayout
+	for _, fsType := range []string{"ext4", "xfs", "btrfs"} {
+		err = mount.Mount(rootDev, consts.NewRoot, fsType, "", unix.MS_RDONLY)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		log.Logf("failed to mount newroot: %s", err)
 		return
@@ -192,11 +247,6 @@ func switchroot(rootDev string, uproc *os.Process) {
 		}
 		return
 	}
-	if fsKey != nil {
-		//load fs encryption key
-		fsKey.LoadKey()
-	}
-
 	//spawn a process that draws spinner on lcd while systemd gets going
 	progress.Fork()
 
@@ -368,7 +418,7 @@ func bootMenu(rootDev string, foundRoot bool, uproc *os.Process) {
 			fallthrough
 		case Choice_resume:
 			_, _ = cfa.DefaultLcd.Msg("Continuing normal boot...")
-			switchroot(rootDev, uproc)
+			switchSlot(rootDev, foundRoot, uproc)
 			return
 		case Choice_poweroff:
 			_, _ = cfa.DefaultLcd.Msg("Powering off...")
@@ -395,7 +445,7 @@ func bootMenu(rootDev string, foundRoot bool, uproc *os.Process) {
 		default:
 			if len(bootMenuItems[choice]) == 0 {
 				_, _ = cfa.DefaultLcd.Msg("Invalid selection, continuing normal boot...")
-				switchroot(rootDev, uproc)
+				switchSlot(rootDev, foundRoot, uproc)
 			}
 		}
 	}
@@ -110,6 +110,21 @@ type PlatformConfig struct {
 }
 type PlatformConfigs []PlatformConfig
 
+//CheckDigests returns an error if any step's file lacks a usable digest. See
+//xfer.TVFile.CheckDigest.
+func (configs PlatformConfigs) CheckDigests(allowSha1 bool) error {
+	for _, cfg := range configs {
+		for _, s := range cfg.ConfigSteps {
+			for i := range s.Files {
+				if err := s.Files[i].CheckDigest(allowSha1); err != nil {
+					return fmt.Errorf("%s step %s: %s", cfg.DevCodeName, s.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (configs PlatformConfigs) Find(codeName string) (cs ConfigSteps) {
 	for _, cfg := range configs {
 		if cfg.DevCodeName == codeName {
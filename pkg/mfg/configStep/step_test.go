@@ -13,6 +13,7 @@
 
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/net/xfer"
 )
 
 //func (s *step) Run() (err error)
@@ -143,3 +144,22 @@ func init() {
 		{"h", "{{.OSPass}}", CommonTemplateData.OSPass, false},
 	}
 }
+
+func TestCheckDigests(t *testing.T) {
+	sha1 := strings.Repeat("a", 40)
+	sha256 := strings.Repeat("b", 64)
+	configs := PlatformConfigs{{
+		DevCodeName: "plat",
+		ConfigSteps: ConfigSteps{
+			{Name: "strong", Files: []xfer.TVFile{{Src: "http://x/a", Sha1: sha1, Sha256: sha256}}},
+			{Name: "weak", Files: []xfer.TVFile{{Src: "http://x/b", Sha1: sha1}}},
+		},
+	}}
+	if err := configs.CheckDigests(true); err != nil {
+		t.Errorf("legacy: %s", err)
+	}
+	err := configs.CheckDigests(false)
+	if err == nil || !strings.Contains(err.Error(), "weak") {
+		t.Errorf("want error for step weak, got %v", err)
+	}
+}
@@ -25,6 +25,7 @@
 	"github.com/purecloudlabs/gprovision/pkg/common/rlog"
 	"github.com/purecloudlabs/gprovision/pkg/common/stash"
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/erase"
 	"github.com/purecloudlabs/gprovision/pkg/hw/block/partitioning"
 	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
 	"github.com/purecloudlabs/gprovision/pkg/hw/ipmi"
@@ -186,6 +187,10 @@ func Manuf(mfgDataUrl string) {
 			log.Fatalf("can't find recovery")
 		}
 		recov.Mount()
+		//a failed erase would otherwise block the unit after provisioning
+		if err := erase.ClearCheckpoint(recov.Path()); err != nil {
+			log.Logf("clearing erase checkpoint: %s", err)
+		}
 	} else {
 		if os.Getenv(strs.ContinueLoggingEnv()) != "" {
 			bootArgs = fmt.Sprintf("%s=%s", strs.LogEnv(), mfgData.LogEndpoint)
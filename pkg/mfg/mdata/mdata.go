@@ -35,6 +35,8 @@ type MfgDataStruct struct {
 	StashFiles         []*xfer.TVFile //list of files for use in Stasher impl. implementation-defined.
 	ValidationData     []qa.Specs
 	CustomPlatCfgSteps steps.PlatformConfigs
+	DownloadChunks     int  `json:",omitempty"` //if > 1, Files are downloaded in this many parallel chunks
+	AllowSha1          bool `json:",omitempty"` //legacy: accept files whose only digest is sha1
 }
 
 func Parse(url string) (mds *MfgDataStruct) {
@@ -53,6 +55,11 @@ func Parse(url string) (mds *MfgDataStruct) {
 		log.Logln(err)
 		log.Fatalf("error unmarshalling mfg data")
 	}
+	err = mds.checkDigests()
+	if err != nil {
+		log.Logln(err)
+		log.Fatalf("mfg data lacks adequate checksums")
+	}
 	if mds.ApplianceJsonUrl != "" {
 		appliance.LoadJson(mds.ApplianceJsonUrl)
 	}
@@ -85,6 +92,7 @@ func (m *MfgDataStruct) WriteFiles(r *disk.Filesystem) {
 			f.Dest = fp.Join(r.Path(), f.Dest)
 		}
 		checkDest(f.Dest)
+		f.Parallel(m.DownloadChunks)
 		f.UseIntermediateDir("/tmp/")
 		err = f.GetWithRetry()
 		if err == nil {
@@ -99,6 +107,18 @@ func (m *MfgDataStruct) WriteFiles(r *disk.Filesystem) {
 	}
 }
 
+//checks that all files to be downloaded have an adequate digest
+func (m *MfgDataStruct) checkDigests() error {
+	for _, list := range [][]*xfer.TVFile{m.Files, m.StashFiles} {
+		for _, f := range list {
+			if err := f.CheckDigest(m.AllowSha1); err != nil {
+				return err
+			}
+		}
+	}
+	return m.CustomPlatCfgSteps.CheckDigests(m.AllowSha1)
+}
+
 func isImage(name string) bool {
 	return strings.HasPrefix(name, strs.ImgPrefix()) && strings.HasSuffix(name, ".upd")
 }
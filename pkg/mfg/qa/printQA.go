@@ -114,7 +114,7 @@ func init() {
 	q, err := Asset("qa.tmpl.html")
 	if err != nil {
 		q = []byte(`example qa template (see source):
-{{.SN}} {{ .Img.Sha1 }}
+{{.SN}} {{ .Img.Checksum }}
 {{.Model}} {{ .Cpus.Cores }}
 {{ .NumPci }} {{ .NumUsb }}
 {{ range .CfgSteps -}}{{ . }}{{ end -}}
@@ -0,0 +1,200 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+//Package tlscfg provides the tls configuration shared by all provisioning
+//network traffic - file transfers, remote logging, and appliance json.
+//
+//Servers are authenticated using a CA bundle in the initramfs; the presence
+//of the bundle is what enables tls. If a client certificate is available
+//(from the Stasher), it is presented to servers. Server names may be pinned
+//via an env var, in which case the server's certificate must be valid for one
+//of those names regardless of the host name or ip used to connect.
+//
+//Misconfiguration fails closed: if the bundle is present but unusable, or the
+//client certificate can't be loaded, no connection is made.
+package tlscfg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//location of CA bundle in the initramfs
+var CABundle = "/etc/ssl/provisioning/ca.pem"
+
+//CertSource returns a pem-encoded client certificate and key. If no
+//certificate is available, both are nil and err is nil.
+type CertSource func() (cert, key []byte, err error)
+
+var (
+	mu      sync.Mutex
+	certSrc CertSource
+	cached  *tls.Config
+)
+
+//SetCertSource sets the source of the client certificate. Normally called by
+//the stash package.
+func SetCertSource(src CertSource) {
+	mu.Lock()
+	defer mu.Unlock()
+	certSrc = src
+	cached = nil
+}
+
+//Reload causes the configuration to be re-read on next use, i.e. after a
+//client certificate becomes available.
+func Reload() {
+	mu.Lock()
+	defer mu.Unlock()
+	cached = nil
+}
+
+//Enabled returns true if tls is required for connections to provisioning
+//servers. An unreadable bundle still counts as enabled.
+func Enabled() bool {
+	_, err := os.Stat(CABundle)
+	return !os.IsNotExist(err)
+}
+
+//Get returns the client tls configuration, or nil if tls is not enabled. On
+//error, a message is shown on the lcd; callers must not fall back to an
+//insecure connection.
+func Get() (*tls.Config, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	if !Enabled() {
+		return nil, nil
+	}
+	cfg, err := load()
+	if err != nil {
+		log.Msg("TLS config error")
+		log.Logf("tls: %s", err)
+		return nil, fmt.Errorf("tls: %s", err)
+	}
+	cached = cfg
+	return cfg, nil
+}
+
+func load() (*tls.Config, error) {
+	pool, err := readPool(CABundle)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if certSrc != nil {
+		cert, key, err := certSrc()
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate: %s", err)
+		}
+		if len(cert) > 0 || len(key) > 0 {
+			kp, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("client certificate: %s", err)
+			}
+			cfg.Certificates = []tls.Certificate{kp}
+		}
+	}
+	if names := pinnedNames(); len(names) > 0 {
+		//the usual verification checks the name used to connect; replace it
+		//with our own, which checks the pinned names instead
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPinned(raw, pool, names)
+		}
+	}
+	return cfg, nil
+}
+
+func readPool(bundle string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", bundle)
+	}
+	return pool, nil
+}
+
+//server names from env, comma-separated
+func pinnedNames() (names []string) {
+	for _, n := range strings.Split(os.Getenv(strs.TLSServersEnv()), ",") {
+		n = strings.TrimSpace(n)
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return
+}
+
+//verify the chain and check that the leaf is valid for one of the names
+func verifyPinned(raw [][]byte, roots *x509.CertPool, names []string) error {
+	if len(raw) == 0 {
+		return errors.New("no server certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, r := range raw {
+		c, err := x509.ParseCertificate(r)
+		if err != nil {
+			return err
+		}
+		certs[i] = c
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: inter})
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if certs[0].VerifyHostname(n) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("server certificate not valid for pinned names %s", strings.Join(names, ","))
+}
+
+//ServerConfig returns a tls configuration for a provisioning server. If
+//clientCA is not empty, clients must present a certificate signed by a CA in
+//that bundle.
+func ServerConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	kp, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{kp},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if clientCA != "" {
+		cfg.ClientCAs, err = readPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
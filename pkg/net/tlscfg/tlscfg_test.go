@@ -0,0 +1,186 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package tlscfg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+//create a cert signed by parent, or self-signed if parent is nil
+func newCert(t *testing.T, cn string, parent *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dnsNames,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func write(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestTLS(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "tlscfg_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newCert(t, "ca", nil)
+	srvCert := newCert(t, "server", ca, "prov.example")
+	cliCert := newCert(t, "client", ca)
+	otherCA := newCert(t, "other ca", nil)
+
+	caFile := write(t, fp.Join(dir, "ca.pem"), ca.certPEM)
+	srvCfg, err := ServerConfig(
+		write(t, fp.Join(dir, "srv.crt"), srvCert.certPEM),
+		write(t, fp.Join(dir, "srv.key"), srvCert.keyPEM),
+		caFile,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = srvCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	origBundle := CABundle
+	defer func() {
+		CABundle = origBundle
+		SetCertSource(nil)
+		os.Unsetenv(strs.TLSServersEnv())
+	}()
+	clientCert := func() ([]byte, []byte, error) { return cliCert.certPEM, cliCert.keyPEM, nil }
+
+	for _, td := range []struct {
+		name       string
+		bundle     []byte //nil: no bundle
+		src        CertSource
+		pinned     string
+		wantEnable bool
+		wantCfgErr bool
+		wantConnOk bool
+	}{
+		{name: "disabled"},
+		{name: "badBundle", bundle: []byte("junk"), wantEnable: true, wantCfgErr: true},
+		{name: "noClientCert", bundle: ca.certPEM, pinned: "prov.example", wantEnable: true},
+		{name: "notPinned", bundle: ca.certPEM, src: clientCert, wantEnable: true},
+		{name: "pinned", bundle: ca.certPEM, src: clientCert, pinned: "other.example, prov.example", wantEnable: true, wantConnOk: true},
+		{name: "wrongPin", bundle: ca.certPEM, src: clientCert, pinned: "other.example", wantEnable: true},
+		{name: "wrongCA", bundle: otherCA.certPEM, src: clientCert, pinned: "prov.example", wantEnable: true},
+		{name: "certSrcErr", bundle: ca.certPEM, src: func() ([]byte, []byte, error) { return nil, nil, errors.New("fail") },
+			wantEnable: true, wantCfgErr: true},
+		{name: "certNoKey", bundle: ca.certPEM, src: func() ([]byte, []byte, error) { return cliCert.certPEM, nil, nil },
+			wantEnable: true, wantCfgErr: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			CABundle = fp.Join(dir, td.name+".pem")
+			if td.bundle != nil {
+				write(t, CABundle, td.bundle)
+			}
+			os.Setenv(strs.TLSServersEnv(), td.pinned)
+			SetCertSource(td.src)
+
+			if Enabled() != td.wantEnable {
+				t.Errorf("want enabled=%t", td.wantEnable)
+			}
+			cfg, err := Get()
+			if (err != nil) != td.wantCfgErr {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if !td.wantEnable || td.wantCfgErr {
+				if cfg != nil {
+					t.Error("want nil config")
+				}
+				return
+			}
+			if again, _ := Get(); again != cfg {
+				t.Error("config should be cached")
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			res, err := c.Get(srv.URL)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err == nil) != td.wantConnOk {
+				t.Errorf("unexpected connection result %v", err)
+			}
+		})
+	}
+}
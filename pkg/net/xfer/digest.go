@@ -0,0 +1,142 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+var errNoDigest = errors.New("no digest")
+
+//supported hash algorithms, weakest first
+var algos = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha1", sha1.New},
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+}
+
+//returned when content doesn't match the expected digest
+type errBadHash struct {
+	algo, want, got string
+}
+
+func (e errBadHash) Error() string {
+	return fmt.Sprintf("bad %s.\nwant %s\ngot  %s\n", e.algo, e.want, e.got)
+}
+
+//computes a digest and compares it with the expected value
+type hasher struct {
+	hash.Hash
+	algo, want string
+}
+
+func (h *hasher) check() error {
+	computed := fmt.Sprintf("%x", h.Sum(nil))
+	if h.want != computed {
+		return errBadHash{algo: h.algo, want: h.want, got: computed}
+	}
+	return nil
+}
+
+//digests returns all digests specified for the file, keyed by algorithm.
+func (tvf *TVFile) digests() (map[string]string, error) {
+	d := make(map[string]string)
+	add := func(algo, sum string) error {
+		if sum == "" {
+			return nil
+		}
+		sum = strings.ToLower(sum)
+		if prev, ok := d[algo]; ok && prev != sum {
+			return fmt.Errorf("%s: conflicting %s digests", tvf.Basename(), algo)
+		}
+		d[algo] = sum
+		return nil
+	}
+	_ = add("sha1", tvf.Sha1)
+	_ = add("sha256", tvf.Sha256)
+	_ = add("sha512", tvf.Sha512)
+	if tvf.Digest != "" {
+		parts := strings.SplitN(tvf.Digest, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: malformed digest %q, want algo:hex", tvf.Basename(), tvf.Digest)
+		}
+		//accept sha-256 as well as sha256
+		algo := strings.Replace(strings.ToLower(parts[0]), "-", "", -1)
+		known := false
+		for _, a := range algos {
+			known = known || a.name == algo
+		}
+		if !known {
+			return nil, fmt.Errorf("%s: unsupported digest algorithm %s", tvf.Basename(), parts[0])
+		}
+		if err := add(algo, parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+//strongest returns the strongest algorithm for which a digest is specified,
+//and that digest.
+func (tvf *TVFile) strongest() (algo, sum string, err error) {
+	d, err := tvf.digests()
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(algos) - 1; i >= 0; i-- {
+		if sum, ok := d[algos[i].name]; ok {
+			return algos[i].name, sum, nil
+		}
+	}
+	return "", "", errNoDigest
+}
+
+func (tvf *TVFile) newHasher() (*hasher, error) {
+	algo, sum, err := tvf.strongest()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range algos {
+		if a.name == algo {
+			return &hasher{Hash: a.new(), algo: algo, want: sum}, nil
+		}
+	}
+	panic("unreachable")
+}
+
+//Checksum returns the strongest digest specified for the file, in the form
+//algo:hex. Returns an empty string if there is none.
+func (tvf *TVFile) Checksum() string {
+	algo, sum, err := tvf.strongest()
+	if err != nil {
+		return ""
+	}
+	return algo + ":" + sum
+}
+
+//CheckDigest returns an error if the file lacks a usable digest. Unless
+//allowSha1 is true, a sha1 digest alone is not sufficient.
+func (tvf *TVFile) CheckDigest(allowSha1 bool) error {
+	algo, _, err := tvf.strongest()
+	if err != nil {
+		return fmt.Errorf("%s: %s", tvf.Basename(), err)
+	}
+	if algo == "sha1" && !allowSha1 {
+		return fmt.Errorf("%s: only has a sha1 digest; sha256 or better required", tvf.Basename())
+	}
+	return nil
+}
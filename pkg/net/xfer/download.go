@@ -0,0 +1,264 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+/* Resumable and parallel downloads.
+ *
+ * A sequential download resumes from the end of a partial file left by an
+ * earlier attempt at the same transfer, if the transport allows it (i.e. with
+ * an http Range request). The partial content is hashed first, so that the
+ * hash can be verified as the remainder is streamed to disk. While a download
+ * is incomplete, a marker alongside the file records the digest it is for;
+ * a file without a matching marker is stale or unrelated, and is truncated.
+ *
+ * A parallel download splits the file into chunks which are fetched
+ * concurrently, if the transport is a RangeTransport. Chunk progress is kept in
+ * the TVFile, so a retry only fetches what is missing. Chunks are hashed in
+ * order as they complete, while later chunks are still downloading.
+ *
+ * If ranges aren't supported, the file is downloaded from the start.
+ * If the hash doesn't match, the file is truncated so that a retry starts over.
+ */
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//chunks smaller than this aren't worth a separate request
+const minChunkSize = 4 * 1024 * 1024
+
+var progressInterval = 5 * time.Second
+
+//a byte range of a file being downloaded in parallel
+type chunk struct {
+	off, len int64
+	done     int64 //bytes written so far
+}
+
+func (c *chunk) complete() bool { return c.done == c.len }
+
+//writes to f at an offset, advancing the offset and counters as it goes
+type offsetWriter struct {
+	f       *os.File
+	c       *chunk
+	written *int64 //total across all chunks; access atomically
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.c.off+w.c.done)
+	w.c.done += int64(n)
+	atomic.AddInt64(w.written, int64(n))
+	return n, err
+}
+
+//counts bytes written through it
+type countingWriter struct {
+	written *int64 //access atomically
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.written, int64(len(p)))
+	return len(p), nil
+}
+
+//download sequentially, resuming from the end of dst if possible
+func (tvf *TVFile) getSequential(t Transport, src string, dst *os.File, written *int64) error {
+	h, err := tvf.newHasher()
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	off, err := io.Copy(h, dst)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(written, off)
+	if off > 0 {
+		log.Logf("resuming %s at %dM", tvf.Basename(), off/(1024*1024))
+	}
+	rc, start, err := t.Fetch(src, off)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if start != off {
+		log.Logf("%s: unable to resume, starting over", tvf.Basename())
+		if err = restart(dst); err != nil {
+			return err
+		}
+		h.Reset()
+		atomic.StoreInt64(written, 0)
+	}
+	_, err = io.Copy(io.MultiWriter(dst, h, countingWriter{written}), rc)
+	if err != nil {
+		return err
+	}
+	return h.check()
+}
+
+//download in parallel chunks, if the transport allows it
+func (tvf *TVFile) getParallel(t Transport, src string, dst *os.File, written *int64) error {
+	h, err := tvf.newHasher()
+	if err != nil {
+		return err
+	}
+	rt, ok := t.(RangeTransport)
+	if !ok {
+		if tvf.parts != nil {
+			//partial content isn't contiguous
+			tvf.parts = nil
+			if err = restart(dst); err != nil {
+				return err
+			}
+		}
+		return tvf.getSequential(t, src, dst, written)
+	}
+	if tvf.parts == nil {
+		var size int64
+		size, err = rt.Size(src)
+		if err != nil {
+			log.Logf("%s: %s, not downloading in parallel", tvf.Basename(), err)
+			return tvf.getSequential(t, src, dst, written)
+		}
+		if err = dst.Truncate(size); err != nil {
+			return err
+		}
+		tvf.parts = split(size, tvf.chunks)
+	}
+	atomic.StoreInt64(written, 0)
+	for i := range tvf.parts {
+		atomic.AddInt64(written, tvf.parts[i].done)
+	}
+
+	errs := make([]error, len(tvf.parts))
+	done := make([]chan struct{}, len(tvf.parts))
+	var wg sync.WaitGroup
+	for i := range tvf.parts {
+		done[i] = make(chan struct{})
+		if tvf.parts[i].complete() {
+			close(done[i])
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			errs[i] = getChunk(rt, src, dst, &tvf.parts[i], written)
+		}(i)
+	}
+
+	//hash each chunk as soon as it and all before it are complete
+	var herr error
+	for i := range tvf.parts {
+		<-done[i]
+		if errs[i] != nil {
+			break
+		}
+		c := &tvf.parts[i]
+		if _, herr = io.Copy(h, io.NewSectionReader(dst, c.off, c.len)); herr != nil {
+			break
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if herr != nil {
+		return herr
+	}
+	return h.check()
+}
+
+//fetch the missing part of a chunk
+func getChunk(rt RangeTransport, src string, dst *os.File, c *chunk, written *int64) error {
+	rc, err := rt.FetchRange(src, c.off+c.done, c.len-c.done)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.CopyN(&offsetWriter{f: dst, c: c, written: written}, rc, c.len-c.done)
+	return err
+}
+
+//split size bytes into at most n chunks
+func split(size int64, n int) (parts []chunk) {
+	csize := (size + int64(n) - 1) / int64(n)
+	if csize < minChunkSize {
+		csize = minChunkSize
+	}
+	for off := int64(0); off < size; off += csize {
+		l := csize
+		if off+l > size {
+			l = size - off
+		}
+		parts = append(parts, chunk{off: off, len: l})
+	}
+	return
+}
+
+//suffix of the marker recording which transfer a partial file belongs to
+const partialSuffix = ".partial"
+
+//open dest for writing, truncating it unless its marker shows it was left by
+//an earlier attempt at this transfer. Get removes the marker on success.
+func (tvf *TVFile) openDest(dest string) (*os.File, error) {
+	marker := dest + partialSuffix
+	flags := os.O_RDWR | os.O_CREATE
+	if m, err := ioutil.ReadFile(marker); err != nil || string(m) != tvf.Checksum() {
+		flags |= os.O_TRUNC
+		tvf.parts = nil
+	}
+	dst, err := os.OpenFile(dest, flags, tvf.mode)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(marker, []byte(tvf.Checksum()), 0644); err != nil {
+		//download can proceed, but won't be resumed
+		log.Logf("writing %s: %s", marker, err)
+	}
+	return dst, nil
+}
+
+//discard any content in f, so that the download starts over
+func restart(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+//periodically display amount downloaded, until done is closed
+func showProgress(done chan struct{}, written *int64) {
+	if cfa.DefaultLcd == nil {
+		<-done
+		return
+	}
+	tick := time.NewTicker(progressInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			log.Msgf("Downloading... %dM", atomic.LoadInt64(written)/(1024*1024))
+		}
+	}
+}
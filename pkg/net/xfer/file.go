@@ -9,11 +9,9 @@
 package xfer
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	fp "path/filepath"
 	"strings"
@@ -22,22 +20,31 @@
 
 	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
 	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
 )
 
-//retrieves file, either on local fs or via http/https
+//retrieves file, either on local fs or via any registered transport. A url
+//without a scheme is treated as a local path. Since the content isn't
+//verified, insecure transports are refused when tls is enabled.
 func GetFile(url string) (content []byte, err error) {
-	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
-		log.Logf("downloading %s", url)
-		var res *http.Response
-		res, err = http.Get(url)
-		if err == nil {
-			defer res.Body.Close()
-			content, err = ioutil.ReadAll(res.Body)
-		}
-	} else {
-		content, err = ioutil.ReadFile(url)
+	if !strings.Contains(url, "://") {
+		return ioutil.ReadFile(url)
 	}
-	return
+	t, err := transportFor(url)
+	if err != nil {
+		return nil, err
+	}
+	if tlscfg.Enabled() && !secureScheme(url) {
+		log.Msg("TLS required, insecure URL")
+		return nil, fmt.Errorf("tls is required; refusing to retrieve %s", url)
+	}
+	log.Logf("downloading %s", url)
+	rc, _, err := t.Fetch(url, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
 type VerifiableFile interface {
@@ -50,20 +57,40 @@ type TransferrableFile interface {
 var _ TransferrableFile = &TVFile{}
 var _ VerifiableFile = &TVFile{}
 
+//TVFile is a file which is transferred and then verified. The strongest of
+//the specified digests is used for verification.
 type TVFile struct {
 	Dest             string
 	Src              string
+	Mirrors          []string `json:",omitempty"` //urls tried in order before Src, i.e. a local cache
 	Sha1             string
-	useIntermediate  bool //copy to temporary/staging location first
+	Sha256           string `json:",omitempty"`
+	Sha512           string `json:",omitempty"`
+	Digest           string `json:",omitempty"` //algo:hex, i.e. sha256:abc...
+	useIntermediate  bool   //copy to temporary/staging location first
 	intermediateFile string
 	finalized        bool
 	mode             os.FileMode
+	chunks           int     //if > 1, download in this many parallel chunks
+	parts            []chunk //progress of parallel download, kept across retries
 }
 
 func (tvf *TVFile) Basename() string {
+	if tvf.Src == "" && len(tvf.Mirrors) > 0 {
+		return fp.Base(tvf.Mirrors[0])
+	}
 	return fp.Base(tvf.Src)
 }
 
+//urls to try, in order
+func (tvf *TVFile) sources() []string {
+	srcs := append([]string{}, tvf.Mirrors...)
+	if tvf.Src != "" {
+		srcs = append(srcs, tvf.Src)
+	}
+	return srcs
+}
+
 /* When copying over network, write to intermediate location and verify
    hash before writing to final dest. Useful when dest is slow (usb flash)
    to avoid rewrites in the face of checksum failures.
@@ -88,7 +115,8 @@ func (tvf *TVFile) GetIntermediate() string {
 	return tvf.intermediateFile
 }
 
-//Verify SHA1 in most recent location (intermediate or final)
+//Verify file in most recent location (intermediate or final) against the
+//strongest digest given (sha512, sha256, or sha1)
 func (tvf *TVFile) Verify() (err error) {
 	fname := tvf.Dest
 	if tvf.useIntermediate && !tvf.finalized {
@@ -106,29 +134,29 @@ func (tvf *TVFile) Verify() (err error) {
 	if err != nil {
 		return
 	}
-	return verify(fname, tvf.Sha1)
+	h, err := tvf.newHasher()
+	if err != nil {
+		return
+	}
+	return verify(fname, h)
 }
-func verify(fname, sha string) (err error) {
+func verify(fname string, h *hasher) (err error) {
 	f, err := os.Open(fname)
 	if err != nil {
 		return
 	}
 	defer f.Close()
 
-	hasher := sha1.New()
-	_, err = io.Copy(hasher, f)
+	_, err = io.Copy(h, f)
 	if err != nil {
 		return
 	}
-
-	computed := fmt.Sprintf("%x", hasher.Sum(nil))
-	if sha != computed {
-		err = fmt.Errorf("bad sha1.\nwant %s\ngot  %s\n", sha, computed)
-	}
-	return
+	return h.check()
 }
 
-//get a file from url, verifying integrity with sha1
+//get a file from url, verifying integrity with the strongest digest given
+//(sha512, sha256, or sha1). Resumes if a previous attempt at the same
+//transfer left a partial file; the digest is computed as data is written.
 func (tvf *TVFile) Get() (err error) {
 	dest := tvf.Dest
 	if tvf.useIntermediate {
@@ -136,38 +164,57 @@ func (tvf *TVFile) Get() (err error) {
 	}
 	err = os.MkdirAll(fp.Dir(dest), 0777)
 	if err != nil {
-		log.Logf("failed to create dir: %s")
+		log.Logf("failed to create dir: %s", err)
 	}
 
-	if !strings.HasPrefix(tvf.Src, "http://") && !strings.HasPrefix(tvf.Src, "https://") {
-		return fmt.Errorf("Error: url '%s' must be http or https", dest)
+	if len(tvf.sources()) == 0 {
+		return fmt.Errorf("no source for %s", dest)
 	}
-	log.Logf("downloading %s", tvf.Basename())
-
-	var res *http.Response
-	res, err = http.Get(tvf.Src)
-	if err != nil {
+	if err = tvf.CheckDigest(true); err != nil {
 		return err
 	}
-	defer res.Body.Close()
+	log.Logf("downloading %s", tvf.Basename())
+
 	if tvf.mode == 0 {
 		tvf.mode = 0666
 	}
-	dst, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, tvf.mode)
+	dst, err := tvf.openDest(dest)
 	if err != nil {
 		return err
 	}
 	defer dst.Close()
 
+	var written int64
 	writeDone := make(chan struct{})
-	go futil.ShowProgress(writeDone, "Downloading", dest)
+	go showProgress(writeDone, &written)
 	defer close(writeDone)
 
-	_, err = io.Copy(dst, res.Body)
-	if err != nil {
-		return
+	for _, src := range tvf.sources() {
+		var t Transport
+		t, err = transportFor(src)
+		if err == nil {
+			if tvf.chunks > 1 {
+				err = tvf.getParallel(t, src, dst, &written)
+			} else {
+				err = tvf.getSequential(t, src, dst, &written)
+			}
+		}
+		if _, bad := err.(errBadHash); bad {
+			//content can't be trusted, start over
+			tvf.parts = nil
+			if terr := restart(dst); terr != nil {
+				log.Logf("truncating %s: %s", dest, terr)
+			}
+		}
+		if err == nil {
+			if e := os.Remove(dest + partialSuffix); e != nil && !os.IsNotExist(e) {
+				log.Logf("removing %s: %s", dest+partialSuffix, e)
+			}
+			return nil
+		}
+		log.Logf("retrieving %s from %s: %s", tvf.Basename(), src, err)
 	}
-	return verify(dest, tvf.Sha1)
+	return err
 }
 
 //set mode with which file is to be created
@@ -175,6 +222,12 @@ func (tvf *TVFile) Mode(m os.FileMode) {
 	tvf.mode = m
 }
 
+//Parallel causes Get to download in up to n chunks concurrently, if the
+//server supports range requests.
+func (tvf *TVFile) Parallel(n int) {
+	tvf.chunks = n
+}
+
 //if file is in intermediate location, moves to final location
 func (tvf *TVFile) Finalize() (err error) {
 	current := tvf.Dest
@@ -213,7 +266,7 @@ func (tvf *TVFile) GetWithRetry() error {
 			success = true
 			break
 		}
-		log.Msgf("failed to retrieve %s", fp.Base(tvf.Src))
+		log.Msgf("failed to retrieve %s", tvf.Basename())
 		log.Logf("retrieval error %s", err)
 		if retries > 0 {
 			log.Msgf("sleep %s, retry", sleepTime)
@@ -222,7 +275,7 @@ func (tvf *TVFile) GetWithRetry() error {
 		}
 	}
 	if !success {
-		return fmt.Errorf("gave up retrieving %s", tvf.Src)
+		return fmt.Errorf("gave up retrieving %s", tvf.Basename())
 	}
 	return nil
 }
@@ -0,0 +1,253 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+//serves content, counting bytes sent and requests with a Range header
+type testServer struct {
+	content []byte
+	sent    int64
+	ranges  int64
+	noRange bool
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Range") != "" {
+		if s.noRange {
+			r.Header.Del("Range")
+		} else {
+			atomic.AddInt64(&s.ranges, 1)
+		}
+	}
+	cw := &countingResponse{ResponseWriter: w, n: &s.sent}
+	if s.noRange {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(s.content)))
+		if r.Method != http.MethodHead {
+			_, _ = cw.Write(s.content)
+		}
+		return
+	}
+	http.ServeContent(cw, r, "img.upd", time.Time{}, bytes.NewReader(s.content))
+}
+
+type countingResponse struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (c *countingResponse) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.n, int64(len(p)))
+	return c.ResponseWriter.Write(p)
+}
+
+func TestGet(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "xfer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 10*1024*1024+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	sum := fmt.Sprintf("%x", sha1.Sum(content))
+	size := int64(len(content))
+
+	for _, td := range []struct {
+		name     string
+		parallel int
+		partial  int64 //bytes already present
+		stale    bool  //partial content is from another transfer
+		noRange  bool
+		sha      string
+		wantSent int64 //max bytes sent
+		wantErr  bool
+	}{
+		{name: "simple", sha: sum, wantSent: size},
+		{name: "resume", partial: size / 2, sha: sum, wantSent: size - size/2},
+		{name: "noRange", partial: size / 2, noRange: true, sha: sum, wantSent: size},
+		{name: "complete", partial: size, sha: sum, wantSent: 64}, //only an error message
+		{name: "stale", partial: size / 2, stale: true, sha: sum, wantSent: size},
+		{name: "parallel", parallel: 3, sha: sum, wantSent: size},
+		{name: "parallelNoRange", parallel: 3, noRange: true, sha: sum, wantSent: size},
+		{name: "badHash", sha: strings.Repeat("0", 40), wantSent: size, wantErr: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			srv := &testServer{content: content, noRange: td.noRange}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			tvf := &TVFile{
+				Src:  ts.URL + "/img.upd",
+				Dest: fp.Join(dir, td.name, "img.upd"),
+				Sha1: td.sha,
+			}
+			tvf.Parallel(td.parallel)
+			if td.partial > 0 {
+				if err := os.MkdirAll(fp.Dir(tvf.Dest), 0755); err != nil {
+					t.Fatal(err)
+				}
+				partial := content[:td.partial]
+				if td.stale {
+					//unrelated file; resuming from it would fail the hash
+					partial = bytes.Repeat([]byte{0xaa}, int(td.partial))
+				} else if err := ioutil.WriteFile(tvf.Dest+partialSuffix, []byte(tvf.Checksum()), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(tvf.Dest, partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tvf.Get()
+			if td.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if fi, err := os.Stat(tvf.Dest); err != nil || fi.Size() != 0 {
+					t.Errorf("file with bad hash should be truncated: %v %v", fi, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tvf.Verify(); err != nil {
+				t.Error(err)
+			}
+			if srv.sent > td.wantSent {
+				t.Errorf("sent %d bytes, want at most %d", srv.sent, td.wantSent)
+			}
+			if _, err = os.Stat(tvf.Dest + partialSuffix); !os.IsNotExist(err) {
+				t.Errorf("marker should be removed: %v", err)
+			}
+			if td.parallel > 1 && !td.noRange && srv.ranges != 3 {
+				t.Errorf("want 3 range requests, got %d", srv.ranges)
+			}
+		})
+	}
+}
+
+func TestGetIntermediate(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "xfer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("some image content")
+	ts := httptest.NewServer(&testServer{content: content})
+	defer ts.Close()
+
+	tvf := &TVFile{
+		Src:  ts.URL + "/img.upd",
+		Dest: fp.Join(dir, "dest", "img.upd"),
+		Sha1: fmt.Sprintf("%x", sha1.Sum(content)),
+	}
+	tvf.UseIntermediateDir(dir)
+	if err = tvf.GetWithRetry(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(tvf.Dest); !os.IsNotExist(err) {
+		t.Errorf("dest should not exist before Finalize: %v", err)
+	}
+	if err = tvf.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tvf.Verify(); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(tvf.GetIntermediate()); !os.IsNotExist(err) {
+		t.Errorf("intermediate should be removed: %v", err)
+	}
+}
+
+func TestDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xfer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := []byte("some content")
+	fname := fp.Join(dir, "file")
+	if err = ioutil.WriteFile(fname, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s1 := fmt.Sprintf("%x", sha1.Sum(content))
+	s256 := fmt.Sprintf("%x", sha256.Sum256(content))
+	s512 := fmt.Sprintf("%x", sha512.Sum512(content))
+
+	for _, td := range []struct {
+		name       string
+		tvf        TVFile
+		want       string //Checksum()
+		strong     bool   //CheckDigest(false) succeeds
+		verifyFail bool
+	}{
+		{name: "none"},
+		{name: "sha1", tvf: TVFile{Sha1: s1}, want: "sha1:" + s1},
+		{name: "sha256", tvf: TVFile{Sha1: s1, Sha256: s256}, want: "sha256:" + s256, strong: true},
+		{name: "sha512", tvf: TVFile{Sha256: s256, Sha512: s512}, want: "sha512:" + s512, strong: true},
+		{name: "generic", tvf: TVFile{Sha1: s1, Digest: "SHA-256:" + strings.ToUpper(s256)}, want: "sha256:" + s256, strong: true},
+		//weaker digest is not used, so it isn't verified
+		{name: "badSha1", tvf: TVFile{Sha1: "bad", Sha256: s256}, want: "sha256:" + s256, strong: true},
+		{name: "badSha256", tvf: TVFile{Sha1: s1, Sha256: "bad"}, want: "sha256:bad", strong: true, verifyFail: true},
+		{name: "conflict", tvf: TVFile{Sha256: s256, Digest: "sha256:bad"}},
+		{name: "unknownAlgo", tvf: TVFile{Digest: "md5:abc"}},
+		{name: "malformed", tvf: TVFile{Digest: s256}},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			tvf := td.tvf
+			tvf.Dest = fname
+			if got := tvf.Checksum(); got != td.want {
+				t.Errorf("Checksum: want %q, got %q", td.want, got)
+			}
+			if err := tvf.CheckDigest(false); (err == nil) != td.strong {
+				t.Errorf("CheckDigest(false): unexpected result %v", err)
+			}
+			if err := tvf.CheckDigest(true); (err == nil) != (td.want != "") {
+				t.Errorf("CheckDigest(true): unexpected result %v", err)
+			}
+			err := tvf.Verify()
+			if wantErr := td.want == "" || td.verifyFail; (err != nil) != wantErr {
+				t.Errorf("Verify: unexpected result %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+/* Minimal tftp client (RFC 1350), supporting only reads in octet mode. The
+ * blksize option (RFC 2348) is requested, but servers lacking support for it
+ * are handled. Block numbers are allowed to roll over, so files larger than
+ * 32M can be retrieved from servers which support that.
+ *
+ * tftp can't start a transfer at an offset, so resuming isn't possible.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tftpRRQ   = 1
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+
+	tftpDefaultBlkSize = 512
+	tftpBlkSize        = 1428 //requested block size, fits in a 1500 byte mtu
+)
+
+var (
+	tftpTimeout = 5 * time.Second
+	tftpRetries = 5
+)
+
+type tftpTransport struct{}
+
+func (tftpTransport) Fetch(u string, _ int64) (io.ReadCloser, int64, error) {
+	r, err := tftpOpen(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, 0, nil
+}
+
+//reads a file from a tftp server
+type tftpReader struct {
+	conn    *net.UDPConn
+	server  *net.UDPAddr //initially the server's well-known port; then its TID
+	gotTID  bool
+	blksize int
+	block   uint16 //last block received
+	pending []byte //last packet sent, for retransmission
+	pkt     []byte
+	data    []byte //unread data from current block
+	done    bool
+}
+
+func tftpOpen(u string) (*tftpReader, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "69")
+	}
+	server, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &tftpReader{
+		conn:    conn,
+		server:  server,
+		blksize: tftpDefaultBlkSize,
+		pkt:     make([]byte, 4+tftpBlkSize),
+	}
+	var rrq bytes.Buffer
+	_ = binary.Write(&rrq, binary.BigEndian, uint16(tftpRRQ))
+	for _, s := range []string{strings.TrimPrefix(parsed.Path, "/"), "octet", "blksize", strconv.Itoa(tftpBlkSize)} {
+		rrq.WriteString(s)
+		rrq.WriteByte(0)
+	}
+	if err = r.send(rrq.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *tftpReader) send(pkt []byte) error {
+	r.pending = pkt
+	_, err := r.conn.WriteToUDP(pkt, r.server)
+	return err
+}
+
+func (r *tftpReader) ack(block uint16) error {
+	pkt := make([]byte, 4)
+	binary.BigEndian.PutUint16(pkt, tftpACK)
+	binary.BigEndian.PutUint16(pkt[2:], block)
+	return r.send(pkt)
+}
+
+//receive the next block of data, handling retransmission
+func (r *tftpReader) next() error {
+	for tries := 0; ; {
+		if err := r.conn.SetReadDeadline(time.Now().Add(tftpTimeout)); err != nil {
+			return err
+		}
+		n, addr, err := r.conn.ReadFromUDP(r.pkt)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			tries++
+			if tries > tftpRetries {
+				return fmt.Errorf("tftp: timeout")
+			}
+			if _, err = r.conn.WriteToUDP(r.pending, r.server); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !r.gotTID {
+			if !addr.IP.Equal(r.server.IP) {
+				continue
+			}
+			//server responds from a new port, used for the rest of the transfer
+			r.server = addr
+			r.gotTID = true
+		} else if addr.Port != r.server.Port || !addr.IP.Equal(r.server.IP) {
+			continue
+		}
+		if n < 4 {
+			continue
+		}
+		pkt := r.pkt[:n]
+		switch binary.BigEndian.Uint16(pkt) {
+		case tftpERROR:
+			return fmt.Errorf("tftp: error %d: %s", binary.BigEndian.Uint16(pkt[2:]), bytes.TrimRight(pkt[4:], "\x00"))
+		case tftpOACK:
+			if r.block != 0 {
+				continue
+			}
+			opts := bytes.Split(bytes.TrimRight(pkt[2:], "\x00"), []byte{0})
+			for i := 0; i+1 < len(opts); i += 2 {
+				if strings.ToLower(string(opts[i])) == "blksize" {
+					bs, err := strconv.Atoi(string(opts[i+1]))
+					if err != nil || bs < 8 || bs > tftpBlkSize {
+						return fmt.Errorf("tftp: bad blksize %q", opts[i+1])
+					}
+					r.blksize = bs
+				}
+			}
+			if err = r.ack(0); err != nil {
+				return err
+			}
+		case tftpDATA:
+			block := binary.BigEndian.Uint16(pkt[2:])
+			if block == r.block+1 {
+				r.block = block
+				r.data = pkt[4:]
+				r.done = len(r.data) < r.blksize
+				return r.ack(block)
+			}
+			if block == r.block {
+				//our ack was lost
+				if _, err = r.conn.WriteToUDP(r.pending, r.server); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (r *tftpReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *tftpReader) Close() error { return r.conn.Close() }
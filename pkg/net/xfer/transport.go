@@ -0,0 +1,238 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
+)
+
+//A Transport retrieves content for urls with a particular scheme.
+type Transport interface {
+	//Fetch returns content of the file at url, starting at offset off. If the
+	//transport is unable to start at off, it returns content from the
+	//beginning of the file and start is 0.
+	Fetch(url string, off int64) (rc io.ReadCloser, start int64, err error)
+}
+
+//A RangeTransport can also fetch arbitrary parts of a file, allowing them
+//to be retrieved in parallel.
+type RangeTransport interface {
+	Transport
+	//Size returns the size of the file, or an error if ranges can't be
+	//fetched for this url.
+	Size(url string) (int64, error)
+	//FetchRange returns n bytes of content starting at offset off.
+	FetchRange(url string, off, n int64) (io.ReadCloser, error)
+}
+
+var transports = map[string]Transport{
+	"http":  httpTransport{},
+	"https": httpTransport{},
+	"file":  fileTransport{},
+	"tftp":  tftpTransport{},
+}
+
+//RegisterTransport makes t available for urls with the given scheme,
+//replacing any existing transport for that scheme. Not safe for concurrent
+//use with transfers; call from init().
+func RegisterTransport(scheme string, t Transport) {
+	transports[strings.ToLower(scheme)] = t
+}
+
+//find the transport for a url's scheme
+func transportFor(u string) (Transport, error) {
+	i := strings.Index(u, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("url '%s' lacks a scheme", u)
+	}
+	t, ok := transports[strings.ToLower(u[:i])]
+	if !ok {
+		return nil, fmt.Errorf("url '%s': unsupported scheme", u)
+	}
+	return t, nil
+}
+
+//true for schemes which authenticate the server, or which are local
+func secureScheme(u string) bool {
+	lower := strings.ToLower(u)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "file://")
+}
+
+//http and https, using Range requests
+type httpTransport struct{}
+
+var (
+	clientMu  sync.Mutex
+	client    *http.Client
+	clientTLS *tls.Config
+)
+
+//returns a client using the shared tls config. The client is replaced if the
+//config changes.
+func httpClient() (*http.Client, error) {
+	cfg, err := tlscfg.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return http.DefaultClient, nil
+	}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client == nil || clientTLS != cfg {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = cfg
+		client = &http.Client{Transport: tr}
+		clientTLS = cfg
+	}
+	return client, nil
+}
+
+func (httpTransport) Fetch(u string, off int64) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	c, err := httpClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, 0, nil
+	case http.StatusPartialContent:
+		var start int64
+		cr := res.Header.Get("Content-Range")
+		if _, err = fmt.Sscanf(cr, "bytes %d-", &start); err != nil || start != off {
+			res.Body.Close()
+			return nil, 0, fmt.Errorf("%s: unexpected Content-Range %q", u, cr)
+		}
+		return res.Body, off, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		//nothing past off
+		res.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), off, nil
+	}
+	res.Body.Close()
+	return nil, 0, fmt.Errorf("%s: %s", u, res.Status)
+}
+
+func (httpTransport) Size(u string) (int64, error) {
+	c, err := httpClient()
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.Head(u)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD: %s", res.Status)
+	}
+	if res.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, fmt.Errorf("ranges not supported")
+	}
+	if res.ContentLength <= 0 {
+		return 0, fmt.Errorf("unknown size")
+	}
+	return res.ContentLength, nil
+}
+
+func (httpTransport) FetchRange(u string, off, n int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	c, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: range request: %s", u, res.Status)
+	}
+	return res.Body, nil
+}
+
+//local files, i.e. on a usb drive or nfs mount
+type fileTransport struct{}
+
+func filePath(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Path, nil
+}
+
+func (fileTransport) Fetch(u string, off int64) (io.ReadCloser, int64, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, off, nil
+}
+
+func (fileTransport) Size(u string) (int64, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (fileTransport) FetchRange(u string, off, n int64) (io.ReadCloser, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, off, n), f}, nil
+}
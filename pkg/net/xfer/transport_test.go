@@ -0,0 +1,185 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package xfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
+)
+
+func TestTransports(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "xfer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//multiple of tftp block size, plus a bit
+	content := make([]byte, 5*1024*1024+100)
+	rand.New(rand.NewSource(1)).Read(content)
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	local := fp.Join(dir, "local.upd")
+	if err = ioutil.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hts := httptest.NewServer(&testServer{content: content})
+	defer hts.Close()
+	tftpAddr := tftpServer(t, content)
+
+	for _, td := range []struct {
+		name     string
+		src      string
+		mirrors  []string
+		parallel int
+		wantErr  bool
+	}{
+		{name: "file", src: "file://" + local},
+		{name: "fileParallel", src: "file://" + local, parallel: 2},
+		{name: "tftp", src: "tftp://" + tftpAddr + "/local.upd"},
+		{name: "tftpParallel", src: "tftp://" + tftpAddr + "/local.upd", parallel: 2},
+		{name: "mirrors", src: hts.URL + "/img.upd", mirrors: []string{
+			"file://" + fp.Join(dir, "nonexistent"),
+			"gopher://example.com/img.upd",
+			hts.URL + "/img.upd",
+		}},
+		{name: "noScheme", src: local, wantErr: true},
+		{name: "unknownScheme", src: "gopher://example.com/img.upd", wantErr: true},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			tvf := &TVFile{
+				Src:     td.src,
+				Mirrors: td.mirrors,
+				Dest:    fp.Join(dir, td.name, "img.upd"),
+				Sha256:  sum,
+			}
+			tvf.Parallel(td.parallel)
+			err := tvf.Get()
+			if (err != nil) != td.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err == nil {
+				if err = tvf.Verify(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+
+	for _, url := range []string{local, "file://" + local, hts.URL + "/img.upd", "tftp://" + tftpAddr + "/local.upd"} {
+		got, err := GetFile(url)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("GetFile(%s): wrong content or error %v", url, err)
+		}
+	}
+
+	//with tls enabled, unverified content must not be retrieved insecurely
+	origBundle := tlscfg.CABundle
+	defer func() { tlscfg.CABundle = origBundle }()
+	tlscfg.CABundle = local
+	if _, err = GetFile(hts.URL + "/img.upd"); err == nil {
+		t.Error("GetFile over http should fail when tls is enabled")
+	}
+	if _, err = GetFile("file://" + local); err != nil {
+		t.Errorf("GetFile from local file should succeed when tls is enabled: %s", err)
+	}
+}
+
+//serve content over tftp, ignoring the requested file name. Returns the
+//server's address.
+func tftpServer(t *testing.T, content []byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 1500)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 || binary.BigEndian.Uint16(buf) != tftpRRQ {
+				continue
+			}
+			blksize := tftpDefaultBlkSize
+			if bytes.Contains(buf[:n], []byte("blksize\x00")) {
+				blksize = tftpBlkSize
+			}
+			go tftpSend(t, client, content, blksize)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+//send content to client, from a new port as the protocol requires
+func tftpSend(t *testing.T, client *net.UDPAddr, content []byte, blksize int) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	exchange := func(pkt []byte, block uint16) bool {
+		ack := make([]byte, 4)
+		for tries := 0; tries < 5; tries++ {
+			if _, err := conn.WriteToUDP(pkt, client); err != nil {
+				return false
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := conn.ReadFromUDP(ack)
+			if err == nil && n == 4 && binary.BigEndian.Uint16(ack) == tftpACK && binary.BigEndian.Uint16(ack[2:]) == block {
+				return true
+			}
+		}
+		return false
+	}
+	if blksize != tftpDefaultBlkSize {
+		oack := []byte{0, tftpOACK}
+		oack = append(oack, []byte(fmt.Sprintf("blksize\x00%d\x00", blksize))...)
+		if !exchange(oack, 0) {
+			return
+		}
+	}
+	var block uint16
+	for off := 0; ; off += blksize {
+		block++
+		end := off + blksize
+		if end > len(content) {
+			end = len(content)
+		}
+		pkt := make([]byte, 4, 4+blksize)
+		binary.BigEndian.PutUint16(pkt, tftpDATA)
+		binary.BigEndian.PutUint16(pkt[2:], block)
+		pkt = append(pkt, content[off:end]...)
+		if !exchange(pkt, block) || end-off < blksize {
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package frd
+
+/* Signed emergency config, allowing field technicians to change FRData
+ * options without shell access.
+ *
+ * The config is a json file on user-inserted media, named like any other
+ * emergency-mode file, with a detached signature alongside it (see
+ * sig.Sign). It must be bound to a serial number and/or a platform code
+ * name, and may carry an expiration time. Options uses the same format as
+ * fr.data; only fields which are present are changed. For example,
+ *
+ * {
+ *   "Serial": "ABC123",
+ *   "Expires": "2020-07-01T00:00:00Z",
+ *   "Options": { "IgnoreNetCfg": true, "XLog": "10.0.0.1:8080" }
+ * }
+ *
+ * The options apply to the current factory restore only; they are not
+ * persisted.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+)
+
+//dir in initramfs containing public keys trusted to sign emergency configs
+var EmergKeyringDir = "/etc/keys/emerg"
+
+//EmergencyConfig is the format of signed emergency config files.
+type EmergencyConfig struct {
+	Serial   string          `json:",omitempty"` //if set, must match unit's serial number
+	Platform string          `json:",omitempty"` //if set, must match unit's code name
+	Expires  *time.Time      `json:",omitempty"`
+	Options  json.RawMessage //Frjson fields to change
+}
+
+var errUnbound = errors.New("config must specify Serial and/or Platform")
+
+//verifies signature and binding of an emergency config, returning its options.
+//The file is read once, and the signature checked against what was read, as
+//media may not return the same data twice.
+func (d *frd) readEmergency(fname string) (json.RawMessage, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err = sig.VerifyBytes(fname, data, sig.LoadKeyring(EmergKeyringDir)); err != nil {
+		return nil, err
+	}
+	var cfg EmergencyConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Serial == "" && cfg.Platform == "" {
+		return nil, errUnbound
+	}
+	if cfg.Serial != "" && cfg.Serial != d.u.Platform.SerNum() {
+		return nil, fmt.Errorf("for serial %s, not %s", cfg.Serial, d.u.Platform.SerNum())
+	}
+	if cfg.Platform != "" && !strings.EqualFold(cfg.Platform, d.u.Platform.DeviceCodeName()) {
+		return nil, fmt.Errorf("for platform %s, not %s", cfg.Platform, d.u.Platform.DeviceCodeName())
+	}
+	if cfg.Expires != nil && time.Now().After(*cfg.Expires) {
+		return nil, fmt.Errorf("expired %s", cfg.Expires.Format(time.RFC3339))
+	}
+	if len(cfg.Options) == 0 {
+		return nil, errors.New("no options")
+	}
+	//check the options decode, without changing anything. A misspelled
+	//option would otherwise be silently ignored.
+	var check Frjson
+	dec := json.NewDecoder(bytes.NewReader(cfg.Options))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&check); err != nil {
+		return nil, err
+	}
+	return cfg.Options, nil
+}
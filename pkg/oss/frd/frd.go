@@ -5,9 +5,9 @@
 // SPDX-License-Identifier: BSD-3-Clause
 //
 
-// Minimal, OSS impl of a mechanism storing data for use by factory restore. If
-// you accept files from user-inserted media, you are responsible for ensuring
-// they cannot cause undesired behavior.
+// Minimal, OSS impl of a mechanism storing data for use by factory restore.
+// Files from user-inserted media are only accepted if signed; see
+// EmergencyConfig.
 package frd
 
 import (
@@ -16,12 +16,14 @@
 	"io/ioutil"
 	"os"
 	fp "path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/purecloudlabs/gprovision/pkg/common"
 	"github.com/purecloudlabs/gprovision/pkg/common/fr"
 	"github.com/purecloudlabs/gprovision/pkg/common/rlog"
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
 	"github.com/purecloudlabs/gprovision/pkg/hw/beep"
 	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
 	"github.com/purecloudlabs/gprovision/pkg/hw/ipmi/uid"
@@ -60,19 +62,37 @@ type Frjson struct {
 	// BootArgs: additional boot args to pass to kernel (applied during factory
 	// restore, takes effect next boot)
 	BootArgs string
+	// ImgPolicy: how to choose among images, for example "stream:beta,maxfail:2".
+	// See archive.ParsePolicy.
+	ImgPolicy string
 }
 
 // Volatile storage of unit info for use by other methods. Never persisted.
 func (d *frd) SetUnit(u common.Unit) { d.u = u }
 
-// Load FRData from user-inserted media or from recovery volume; return error
-// if unable to decode.
+// Load FRData from recovery volume, then apply the first valid signed
+// emergency config among userFiles (see EmergencyConfig). Returns an error
+// if unable to decode FRData and no emergency config was applied.
 func (d *frd) ReadRecoveryOr(userFiles []string) error {
-	if len(userFiles) != 0 {
-		//only read user files if you sanitize them and/or sign and verify them
-		log.Log("not reading untrusted user files")
+	err := d.Read()
+	for _, f := range userFiles {
+		if strings.HasSuffix(f, sig.Ext) {
+			continue
+		}
+		opts, eerr := d.readEmergency(f)
+		if eerr == nil {
+			eerr = json.Unmarshal(opts, &d.Data)
+		}
+		if eerr != nil {
+			log.Msgf("Emergency config %s rejected", fp.Base(f))
+			log.Logf("emergency config %s: %s", f, eerr)
+			continue
+		}
+		log.Msgf("Emergency config %s applied", fp.Base(f))
+		log.Logf("emergency config %s: %s", f, opts)
+		return nil
 	}
-	return d.Read()
+	return err
 }
 
 // Store FRData.
@@ -182,3 +202,8 @@ func (d *frd) SetXLog(url string) {
 func (d *frd) SetPreserve(noDelete bool) {
 	d.Data.Preserve = noDelete
 }
+
+// Policy used to choose among images. See archive.ParsePolicy.
+func (d *frd) ImgPolicy() string {
+	return d.Data.ImgPolicy
+}
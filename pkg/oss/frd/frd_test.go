@@ -0,0 +1,123 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package frd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/common"
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func TestEmergencyConfig(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "frd_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origKeyring := EmergKeyringDir
+	defer func() { EmergKeyringDir = origKeyring }()
+	EmergKeyringDir = fp.Join(dir, "keys")
+	if err = os.Mkdir(EmergKeyringDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err = ioutil.WriteFile(fp.Join(EmergKeyringDir, "test.pub"), []byte(enc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := common.PatherMock(fp.Join(dir, "rec"))
+	if err = os.Mkdir(rec.Path(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	u := common.Unit{
+		Rec:      &rec,
+		Platform: &common.PlatMock{Ser: "SN123", CodeName: "QEMU"},
+	}
+	//persisted data, which emergency config changes
+	if err = persist(rec.Path(), Frjson{XLog: "persisted:80", ImgPolicy: "oldest"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, td := range []struct {
+		name    string
+		config  string
+		key     ed25519.PrivateKey //nil: unsigned
+		applied bool
+	}{
+		{name: "serial", key: priv, applied: true,
+			config: `{"Serial":"SN123","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "platform", key: priv, applied: true,
+			config: `{"Platform":"qemu","Expires":"2100-01-01T00:00:00Z","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "unsigned",
+			config: `{"Serial":"SN123","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "wrongKey", key: otherPriv,
+			config: `{"Serial":"SN123","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "wrongSerial", key: priv,
+			config: `{"Serial":"SN456","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "unbound", key: priv,
+			config: `{"Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "expired", key: priv,
+			config: `{"Serial":"SN123","Expires":"2001-01-01T00:00:00Z","Options":{"IgnoreNetCfg":true,"XLog":"emerg:80"}}`},
+		{name: "unknownOption", key: priv,
+			config: `{"Serial":"SN123","Options":{"IgnoreNetConfig":true,"XLog":"emerg:80"}}`},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			fname := fp.Join(dir, "EMERG_"+td.name+".json")
+			if err := ioutil.WriteFile(fname, []byte(td.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if td.key != nil {
+				if err := sig.Sign(fname, td.key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := &frd{}
+			d.SetUnit(u)
+			if err := d.ReadRecoveryOr([]string{fname + sig.Ext, fname}); err != nil {
+				t.Fatal(err)
+			}
+			if d.IgnoreNetworkConfig() != td.applied {
+				t.Errorf("IgnoreNetCfg: want %t", td.applied)
+			}
+			wantXLog := "persisted:80"
+			if td.applied {
+				wantXLog = "emerg:80"
+			}
+			if d.Data.XLog != wantXLog {
+				t.Errorf("XLog: want %s, got %s", wantXLog, d.Data.XLog)
+			}
+			//unrelated options are retained
+			if d.ImgPolicy() != "oldest" {
+				t.Errorf("ImgPolicy: want oldest, got %s", d.ImgPolicy())
+			}
+		})
+	}
+}
@@ -20,10 +20,12 @@
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/log/flags"
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
 	"github.com/purecloudlabs/gprovision/pkg/oss/pblog/pb"
 
 	empty "github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const LogIdent = "PBLog"
@@ -49,8 +51,16 @@ func AddPBLog(endpoint, sn string, flags flags.Flag) (AllInOne, error) {
 	ctx := context.Background()
 	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
+	creds := grpc.WithInsecure()
+	cfg, err := tlscfg.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(cfg))
+	}
 	conn, err := grpc.DialContext(dialCtx, endpoint,
-		grpc.WithInsecure(),
+		creds,
 		grpc.WithBlock(),
 	)
 	if err != nil {
@@ -10,8 +10,10 @@
 import (
 	"context"
 	"net"
+	"strings"
 	"time"
 
+	"github.com/purecloudlabs/gprovision/pkg/erase/status"
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/oss/pblog/pb"
 
@@ -58,6 +60,15 @@ func tsStr(t *pb.Timestamp) string {
 //pb.LogServiceServer
 func (a *allInOneSrvr) Log(ctx context.Context, evt *pb.LogEvent) (*pb.GenericResponse, error) {
 	err := a.store.StoreLog(evt.SN, &pb.LogEvents{Evt: []*pb.LogEvent{evt}})
+	//cheap check first; most entries aren't progress reports
+	if err == nil && strings.HasPrefix(evt.Payload, status.Prefix) {
+		if r, ok := status.Parse(evt.Payload); ok {
+			a.ah.Lock()
+			defer a.ah.Unlock()
+			e := a.ah.findent(evt.SN)
+			e.setErase(r.Summary(), time.Now())
+		}
+	}
 	return pberr(err)
 }
 
@@ -9,6 +9,7 @@
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"os"
 	fp "path/filepath"
@@ -26,6 +27,8 @@ type histEntry struct {
 	t        time.Time
 	state    pb.ProcessState
 	platform string
+	erase    string    //summary of latest erase progress report
+	eraseT   time.Time //time of that report; doesn't change t
 }
 type hEntries []*histEntry
 type activityHistory struct {
@@ -41,6 +44,10 @@ func (e *histEntry) setPlat(plat string, now time.Time) {
 	e.t = now
 	e.platform = plat
 }
+func (e *histEntry) setErase(summary string, now time.Time) {
+	e.eraseT = now
+	e.erase = summary
+}
 
 const maxHistory = 100
 
@@ -69,10 +76,24 @@ func (ah *activityHistory) getent(sn string) *histEntry {
 	return entry
 }
 
+//like getent, but an existing item keeps its place in the list
+func (ah *activityHistory) findent(sn string) *histEntry {
+	for _, entry := range ah.entries {
+		if entry.sn == sn {
+			return entry
+		}
+	}
+	return ah.getent(sn)
+}
+
 func (e histEntry) toHtml(now time.Time) (s string) {
 	s = "<tr>" + tsToHtml(e.t, now, e.sn)
-	format := "<td class='state'>%s</td><td class='plat'>%s</td></tr>"
-	s += fmt.Sprintf(format, e.state, e.platform)
+	format := "<td class='state'>%s</td><td class='plat'>%s</td><td class='erase'>%s</td></tr>"
+	erase := html.EscapeString(e.erase)
+	if erase != "" {
+		erase += " (" + e.eraseT.Format(timeFormat) + ")"
+	}
+	s += fmt.Sprintf(format, e.state, e.platform, erase)
 	return
 
 }
@@ -100,7 +121,7 @@ func (h *activityHistory) toHtml() (s string) {
 	now := time.Now()
 	s = `<html><head><title>&#127355; Recent Activity</title><link href="/css" rel="stylesheet" type="text/css"></head>
 	<body>Displays activity for up to 100 devices<br><a href=/recent/>Refresh</a><br>
-	<table class=history><tr><th><time>Time</time></th><th class=serial>Serial</th><th class='state'>Last Known State</th><th class='plat'>Platform</th></tr>`
+	<table class=history><tr><th><time>Time</time></th><th class=serial>Serial</th><th class='state'>Last Known State</th><th class='plat'>Platform</th><th class='erase'>Data Erase</th></tr>`
 	h.Lock()
 	defer h.Unlock()
 	for _, e := range h.entries {
@@ -8,6 +8,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"net"
 	"strings"
@@ -15,6 +16,7 @@
 	"time"
 
 	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/net/tlscfg"
 
 	"github.com/soheilhy/cmux"
 	"golang.org/x/sync/errgroup"
@@ -24,6 +26,9 @@
 	Port     = ":8080"
 	QAHold   time.Duration
 	PrintDir string
+	TLSCert  string
+	TLSKey   string
+	ClientCA string
 )
 
 var flagOnce sync.Once
@@ -32,6 +37,9 @@ func Flags() {
 	flagOnce.Do(func() {
 		flag.StringVar(&Port, "port", Port, "override port")
 		flag.StringVar(&PrintDir, "printDir", ".", "dir to write printable docs")
+		flag.StringVar(&TLSCert, "tlsCert", "", "server certificate; enables tls")
+		flag.StringVar(&TLSKey, "tlsKey", "", "server key")
+		flag.StringVar(&ClientCA, "clientCA", "", "if set, require client certificates signed by a CA in this bundle")
 		flag.DurationVar(&QAHold, "qaMaxHold", 20*time.Minute,
 			"factory restore must complete within this time or qa doc will be discarded (use '0s' to disable discard)")
 		flag.Parse()
@@ -58,7 +66,14 @@ func (a *allInOneSrvr) ServeAt(port string) {
 		log.Fatalf(err.Error())
 	}
 
-	//TODO TLS support
+	if TLSCert != "" {
+		cfg, err := tlscfg.ServerConfig(TLSCert, TLSKey, ClientCA)
+		if err != nil {
+			log.Fatalf("tls: %s", err)
+		}
+		//cmux sees decrypted traffic, so grpc and http need no tls config
+		a.lis = tls.NewListener(a.lis, cfg)
+	}
 
 	m := cmux.New(a.lis)
 	// Note - the cmux example is outdated. see this issue (comment is for tls):
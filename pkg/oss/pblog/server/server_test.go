@@ -19,6 +19,7 @@
 	"github.com/purecloudlabs/gprovision/pkg/common/rkeep"
 	"github.com/purecloudlabs/gprovision/pkg/common/rlog"
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/erase/status"
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
 	"github.com/purecloudlabs/gprovision/pkg/oss/pblog"
@@ -62,6 +63,19 @@ func TestServer(t *testing.T) {
 		t.Errorf("reported time too short: %d (%s)", len(tm), tm)
 	}
 	rkeep.ReportCodename("codename")
+	log.Log(status.Report{Disks: []status.Disk{{Dev: "/dev/sda", Fraction: 0.42, ETA: 3600}}}.String())
+	resp, err = http.Get("http://" + host + "/recent/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if want := "sda 42%, 1h00m left"; !strings.Contains(string(page), want) {
+		t.Errorf("erase progress %q missing from /recent/:\n%s", want, page)
+	}
 
 	//now check what the server stored
 	msrv := lSrvr.(*MockSrvr)
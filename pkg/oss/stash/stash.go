@@ -9,6 +9,7 @@
 package stash
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -157,6 +158,57 @@ func (s *ostash) ReadIPMIPass() (string, error) {
 	return s.cr.IPMI, nil
 }
 
+//Returns pem-encoded tls client cert and key from the recovery volume, or
+//nils if the unit is unknown or there is no cert. Like the passwords, these
+//are stored insecurely.
+func (s *ostash) ReadClientCert() (cert, key []byte, err error) {
+	if s.u.Rec == nil {
+		return nil, nil, nil
+	}
+	cert, err = ioutil.ReadFile(fp.Join(s.u.Rec.Path(), "client.crt"))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err = ioutil.ReadFile(fp.Join(s.u.Rec.Path(), "client.key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+const diskKeyLen = 64
+
+//Returns key for full-disk encryption from the recovery volume, generating one
+//if there is none. Stored insecurely, like the passwords.
+func (s *ostash) ReadDiskKey() ([]byte, error) {
+	if s.u.Rec == nil {
+		return nil, errors.New("unit unknown")
+	}
+	f := fp.Join(s.u.Rec.Path(), "insecure.diskkey")
+	key, err := ioutil.ReadFile(f)
+	if err == nil {
+		if len(key) != diskKeyLen {
+			return nil, errors.New("disk key corrupt")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	log.Logf("generating disk key")
+	key = make([]byte, diskKeyLen)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(f, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 // Asks user to input shell password. Compares to stored pw. Reboots if no
 // match - ONLY returns if password matches.
 func (s *ostash) RequestShellPassword() {
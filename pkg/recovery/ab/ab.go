@@ -0,0 +1,170 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+/*Package ab tracks the A/B root slots of units whose layout has a root_b
+partition (see appliance.Variant.ABSlots).
+
+An update is written to the inactive slot, which becomes pending. On each
+boot, init calls Next to choose a slot; the pending slot is tried up to
+MaxAttempts times, after which init falls back to the active slot. The booted
+OS confirms the pending slot with Confirm, after its health check.
+
+State is kept on the recovery volume, where init, recovery, and the booted OS
+can all reach it.
+*/
+package ab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+type Slot string
+
+const (
+	A Slot = "a"
+	B Slot = "b"
+)
+
+//Other returns the other slot.
+func (s Slot) Other() Slot {
+	if s == B {
+		return A
+	}
+	return B
+}
+
+//Label returns the fs label of the slot's root. Slot A uses the primary volume
+//name, as on units without slots, so factory restore needn't differ.
+func (s Slot) Label() string {
+	if s == B {
+		return strs.PriVolName() + "_b"
+	}
+	return strs.PriVolName()
+}
+
+//Dev returns the path at which udev exposes the slot's root.
+func (s Slot) Dev() string { return "/dev/disk/by-label/" + s.Label() }
+
+//Role returns the layout role of the slot's partition.
+func (s Slot) Role() appliance.PartRole {
+	if s == B {
+		return appliance.RoleRootB
+	}
+	return appliance.RoleRoot
+}
+
+//MaxAttempts is the number of times a pending slot is booted without being
+//confirmed before init falls back to the active slot.
+var MaxAttempts uint = 3
+
+const stateName = "ab_slots.json"
+
+//State of the slots.
+type State struct {
+	Active          Slot   //last confirmed slot, or the one written by factory restore
+	ActiveImage     string `json:",omitempty"`
+	Pending         Slot   `json:",omitempty"` //updated slot, not yet confirmed
+	PendingImage    string `json:",omitempty"`
+	Attempts        uint   `json:",omitempty"` //boots of the pending slot so far
+	RolledBack      string `json:",omitempty"` //image of the most recent pending slot which was abandoned
+	UpdateRequested bool   `json:",omitempty"` //set by the OS; init starts an update on next boot
+}
+
+func statePath(recovRoot string) string {
+	return fp.Join(recovRoot, strs.RecoveryLogDir(), stateName)
+}
+
+//Load reads the state from the recovery volume mounted at recovRoot. If there
+//is no state, slot A is active.
+func Load(recovRoot string) (*State, error) {
+	data, err := ioutil.ReadFile(statePath(recovRoot))
+	if os.IsNotExist(err) {
+		return &State{Active: A}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("%s: %s", stateName, err)
+	}
+	if st.Active != A && st.Active != B {
+		return nil, fmt.Errorf("%s: bad active slot %q", stateName, st.Active)
+	}
+	return st, nil
+}
+
+//Write saves the state atomically.
+func (st *State) Write(recovRoot string) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(fp.Dir(statePath(recovRoot)), 0777); err != nil {
+		return err
+	}
+	return futil.WriteFileAtomic(statePath(recovRoot), data, 0644)
+}
+
+//Reset is for use after factory restore, which writes image to slot A.
+func (st *State) Reset(image string) {
+	*st = State{Active: A, ActiveImage: image}
+}
+
+//SetPending is for use after image has been written to the inactive slot,
+//which it returns.
+func (st *State) SetPending(image string) Slot {
+	st.Pending = st.Active.Other()
+	st.PendingImage = image
+	st.Attempts = 0
+	st.UpdateRequested = false
+	return st.Pending
+}
+
+//Next returns the slot to boot, counting an attempt if it is the pending slot.
+//Once a pending slot has used MaxAttempts, it is abandoned and its image is
+//returned as failed.
+func (st *State) Next() (slot Slot, failed string) {
+	if st.Pending == "" {
+		return st.Active, ""
+	}
+	if st.Attempts >= MaxAttempts {
+		log.Logf("slot %s (%s) not confirmed after %d boots, falling back to slot %s",
+			st.Pending, st.PendingImage, st.Attempts, st.Active)
+		failed = st.PendingImage
+		st.RolledBack = failed
+		st.Pending, st.PendingImage, st.Attempts = "", "", 0
+		return st.Active, failed
+	}
+	st.Attempts++
+	return st.Pending, ""
+}
+
+//Confirm is for use by the booted OS. If healthy, the pending slot becomes
+//active; otherwise, the next boot falls back to the active slot. Does nothing
+//if image isn't that of the pending slot, i.e. the active slot is running.
+func (st *State) Confirm(image string, healthy bool) bool {
+	if st.Pending == "" || image != st.PendingImage {
+		return false
+	}
+	if healthy {
+		st.Active, st.ActiveImage = st.Pending, st.PendingImage
+		st.Pending, st.PendingImage, st.Attempts = "", "", 0
+	} else {
+		st.Attempts = MaxAttempts
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package ab
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+)
+
+func TestRollback(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	st := &State{}
+	st.Reset("img1")
+	if s, f := st.Next(); s != A || f != "" {
+		t.Errorf("want slot a, got %s %q", s, f)
+	}
+	if p := st.SetPending("img2"); p != B {
+		t.Errorf("want pending slot b, got %s", p)
+	}
+	for i := uint(0); i < MaxAttempts; i++ {
+		if s, f := st.Next(); s != B || f != "" {
+			t.Fatalf("attempt %d: want slot b, got %s %q", i, s, f)
+		}
+	}
+	s, f := st.Next()
+	if s != A || f != "img2" {
+		t.Errorf("want rollback to a from img2, got %s %q", s, f)
+	}
+	if st.Pending != "" || st.RolledBack != "img2" {
+		t.Errorf("bad state after rollback: %#v", st)
+	}
+	if s, _ = st.Next(); s != A {
+		t.Errorf("want slot a after rollback, got %s", s)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	st := &State{}
+	st.Reset("img1")
+	st.SetPending("img2")
+	st.Next()
+	if st.Confirm("img1", true) {
+		t.Error("confirmed while active slot is running")
+	}
+	if !st.Confirm("img2", true) || st.Active != B || st.ActiveImage != "img2" || st.Pending != "" {
+		t.Errorf("bad state after confirm: %#v", st)
+	}
+	//next update goes to a; failed health check means immediate rollback
+	if p := st.SetPending("img3"); p != A {
+		t.Errorf("want pending slot a, got %s", p)
+	}
+	st.Confirm("img3", false)
+	if s, f := st.Next(); s != B || f != "img3" {
+		t.Errorf("want rollback to b from img3, got %s %q", s, f)
+	}
+}
+
+func TestLoadWrite(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gp-ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	st, err := Load(tmp)
+	if err != nil || st.Active != A {
+		t.Fatalf("want slot a without state file, got %#v %v", st, err)
+	}
+	st.SetPending("img2")
+	st.UpdateRequested = true
+	if err = st.Write(tmp); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *st {
+		t.Errorf("want %#v, got %#v", st, got)
+	}
+	if err = ioutil.WriteFile(statePath(tmp), []byte(`{"Active":"c"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(tmp); err == nil {
+		t.Error("want error for bad slot")
+	}
+	if B.Label() != A.Label()+"_b" || A.Other() != B || B.Role() != "root_b" {
+		t.Error("bad slot names")
+	}
+}
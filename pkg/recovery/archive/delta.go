@@ -0,0 +1,93 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+/* Delta images.
+ *
+ * A delta image is an ordinary .upd whose embedded metadata names a base
+ * image (meta.ImgMeta.Base). It contains only files which were added or
+ * changed relative to the base, and its metadata lists paths which were
+ * removed (meta.ImgMeta.Removed). The base must be present in the Image dir on
+ * the recovery volume, and may itself be a delta.
+ *
+ * A delta is applied by extracting its base and then the delta into the same
+ * staging dir, so that the result is identical to a full image.
+ */
+
+import (
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+)
+
+//limits the length of a chain of deltas
+const maxChain = 8
+
+//one image in a chain, and the paths it removes from the image below it
+type layer struct {
+	upd     string
+	removed []string
+}
+
+//resolveChain returns the layers needed to synthesize a full image from upd,
+//base first. For a full image, that is only upd itself. Base images must be
+//in dir, and each must pass the same checks as upd (other than history).
+func resolveChain(upd string, m *meta.ImgMeta, dir string) ([]layer, error) {
+	var chain []layer
+	for {
+		l := layer{upd: upd}
+		if m == nil || !m.IsDelta() {
+			return append([]layer{l}, chain...), nil
+		}
+		if len(chain) == maxChain {
+			return nil, fmt.Errorf("more than %d deltas in chain", maxChain)
+		}
+		l.removed = m.Removed
+		chain = append([]layer{l}, chain...)
+
+		base := fp.Join(dir, strings.TrimSuffix(m.Base, ".upd")+".upd")
+		log.Logf("%s: delta against %s", fp.Base(upd), m.Base)
+		if !futil.IsXZSha256(base) {
+			return nil, fmt.Errorf("base image %s missing or invalid", m.Base)
+		}
+		if !signed(base, dt.ImgToDTag(trimmedName(base)), keyring, allowUnsigned) {
+			return nil, fmt.Errorf("base image %s: signature check failed", m.Base)
+		}
+		var err error
+		upd = base
+		m, err = meta.Read(base)
+		if err != nil {
+			//lacking metadata, it can't be a delta
+			log.Logf("%s: reading metadata: %s", fp.Base(base), err)
+			m = nil
+		}
+	}
+}
+
+//delete paths removed by a delta
+func removePaths(dir string, paths []string) error {
+	for _, p := range paths {
+		path, err := safeJoin(dir, p)
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			continue
+		}
+		if err = os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
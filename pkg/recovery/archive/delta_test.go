@@ -0,0 +1,187 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+)
+
+func TestDeltaChain(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	allowUnsigned = true
+	keyring = nil
+
+	full := fp.Join(dir, "full.upd")
+	writeImg(t, full, &meta.ImgMeta{ImgName: "full"}, map[string]string{
+		"a": "base a",
+		"b": "base b",
+		"c": "base c",
+	})
+	d1 := fp.Join(dir, "d1.upd")
+	writeImg(t, d1, &meta.ImgMeta{ImgName: "d1", Base: "full", Removed: []string{"b"}}, map[string]string{
+		"a": "d1 a",
+	})
+	d2 := fp.Join(dir, "d2.upd")
+	d2meta := &meta.ImgMeta{ImgName: "d2", Base: "d1.upd", Removed: []string{"c"}}
+	writeImg(t, d2, d2meta, map[string]string{
+		"d": "d2 d",
+	})
+
+	chain, err := resolveChain(d2, d2meta, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].upd != full || chain[1].upd != d1 || chain[2].upd != d2 {
+		t.Fatalf("wrong chain %#v", chain)
+	}
+
+	root := fp.Join(dir, "root")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = extractUpd(root, chain); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a": "d1 a",
+		"d": "d2 d",
+	}
+	for name, content := range want {
+		data, err := ioutil.ReadFile(fp.Join(root, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: want %q, got %q (%v)", name, content, data, err)
+		}
+	}
+	for _, name := range []string{"b", "c"} {
+		if _, err = os.Stat(fp.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: should have been removed (%v)", name, err)
+		}
+	}
+	m, err := meta.Read(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resolveChain(full, m, dir); err != nil {
+		t.Errorf("full image: %s", err)
+	}
+
+	//missing base
+	if err = os.Remove(full); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resolveChain(d2, d2meta, dir); err == nil {
+		t.Errorf("chain resolved with missing base")
+	}
+}
+
+//a delta can remove a path and add it again, including as a different type
+func TestDeltaReAdd(t *testing.T) {
+	tlog := testlog.NewTestLogNoBG(t)
+	defer tlog.Freeze()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	allowUnsigned = true
+	keyring = nil
+
+	full := fp.Join(dir, "full.upd")
+	writeImg(t, full, &meta.ImgMeta{ImgName: "full"}, map[string]string{
+		"a":   "base a",
+		"e/f": "base e/f",
+	})
+	d1 := fp.Join(dir, "d1.upd")
+	d1meta := &meta.ImgMeta{ImgName: "d1", Base: "full", Removed: []string{"a", "e"}}
+	writeImg(t, d1, d1meta, map[string]string{
+		"a": "d1 a",
+		"e": "d1 e",
+	})
+	chain, err := resolveChain(d1, d1meta, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := fp.Join(dir, "root")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = extractUpd(root, chain); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a": "d1 a", "e": "d1 e"} {
+		data, err := ioutil.ReadFile(fp.Join(root, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: want %q, got %q (%v)", name, content, data, err)
+		}
+	}
+}
+
+//write an image with embedded metadata and the given files
+func writeImg(t *testing.T, name string, m *meta.ImgMeta, files map[string]string) {
+	t.Helper()
+	mdata, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	add := func(name string, data []byte) {
+		h := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(meta.MetaPath, mdata)
+	var names []string
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		add(n, []byte(files[n]))
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	xz := exec.Command("xz", "-C", "sha256")
+	xz.Stdin = buf
+	xz.Stdout = f
+	if err := xz.Run(); err != nil {
+		t.Fatalf("run xz: %s", err)
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+/* Native xz + tar extraction.
+ *
+ * The image is decompressed and extracted in a single pass. Xz block checksums
+ * are verified as data is read, so an image is only known to be valid once the
+ * entire stream has been consumed; for this reason, content is extracted into
+ * a staging dir and only moved into place once extraction completes. The
+ * image's sha256 digest is computed in the same pass, and its signature checked
+ * against that digest before unstaging; see sig.go.
+ *
+ * Ownership, permissions, xattrs, hard links, symlinks, and device nodes are
+ * preserved, as with gnu tar's --xattrs option. Like gnu tar's -i option,
+ * zero blocks between archives are ignored so that concatenated archives can
+ * be extracted.
+ */
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	futil "github.com/purecloudlabs/gprovision/pkg/fileutil"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	stagingDir     = ".staging"
+	tarBlockSize   = 512
+	xattrPaxPrefix = "SCHILY.xattr."
+)
+
+var progressInterval = 5 * time.Second
+
+//counts bytes passing through, for progress reporting
+type countingReader struct {
+	r io.Reader
+	n int64 //access atomically
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(&cr.n, int64(n))
+	return n, err
+}
+
+func (cr *countingReader) count() int64 { return atomic.LoadInt64(&cr.n) }
+
+//extractUpd decompresses and extracts layers into a staging dir under root.
+//Each layer is extracted over the previous, as with delta images. Once every
+//layer has been read without error, staged content is moved into root. On
+//error, staged content is removed.
+func extractUpd(root string, layers []layer) error {
+	staging := fp.Join(root, stagingDir)
+	//remove anything left from a previous attempt
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.Mkdir(staging, 0755); err != nil {
+		return err
+	}
+	var rootHdr *tar.Header
+	for _, l := range layers {
+		hdr, err := extractLayer(l, staging)
+		if err != nil {
+			if rmErr := os.RemoveAll(staging); rmErr != nil {
+				log.Logf("removing %s: %s", staging, rmErr)
+			}
+			return err
+		}
+		if hdr != nil {
+			rootHdr = hdr
+		}
+	}
+	if err := unstage(staging, root); err != nil {
+		return err
+	}
+	if rootHdr != nil {
+		return setMeta(root, rootHdr)
+	}
+	return nil
+}
+
+//remove paths the image lists as removed from staging, then extract the image
+//there. Removing first allows a delta to re-add a path, or change its type.
+//Fails if the signature does not match the data extracted.
+func extractLayer(l layer, staging string) (*tar.Header, error) {
+	if err := removePaths(staging, l.removed); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(l.upd)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	log.Logf("extracting %s", l.upd)
+
+	h := sha256.New()
+	compressed := &countingReader{r: bufio.NewReaderSize(io.TeeReader(f, h), 1024*1024)}
+	xzr, err := xz.NewReader(compressed)
+	if err != nil {
+		return nil, err
+	}
+	decompressed := &countingReader{r: xzr}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		showExtractProgress(done, compressed, decompressed, fi.Size())
+		wg.Done()
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	hdr, err := untar(bufio.NewReader(decompressed), staging)
+	if err != nil {
+		return nil, err
+	}
+	//read the rest of the xz stream, verifying its checks, and anything
+	//after it, so that the digest covers the whole file
+	if _, err = io.Copy(ioutil.Discard, decompressed); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(ioutil.Discard, compressed); err != nil {
+		return nil, err
+	}
+	if !checkSig(l.upd, dt.ImgToDTag(trimmedName(l.upd)), h.Sum(nil), keyring, allowUnsigned) {
+		return nil, fmt.Errorf("%s: signature check failed", fp.Base(l.upd))
+	}
+	return hdr, nil
+}
+
+//Periodically reports progress of extraction. Percentage is based on the
+//amount of compressed data consumed. Returns when done is closed.
+func showExtractProgress(done chan struct{}, compressed, decompressed *countingReader, size int64) {
+	tick := time.NewTicker(progressInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			log.Logf("read %s, wrote %s", futil.ToMegs(compressed.count()), futil.ToMegs(decompressed.count()))
+			return
+		case <-tick.C:
+		}
+		pct := int64(0)
+		if size > 0 {
+			pct = compressed.count() * 100 / size
+		}
+		log.Msgf("Writing... %d%% (%dM)", pct, decompressed.count()/(1024*1024))
+	}
+}
+
+//untar extracts all tar archives in r to dir. Returns the header for the
+//archive's root dir ("./"), if present, so that the caller can apply its
+//metadata to the final location.
+func untar(r *bufio.Reader, dir string) (rootHdr *tar.Header, err error) {
+	var dirs []*tar.Header
+	for {
+		var more bool
+		more, err = skipZeroBlocks(r)
+		if err != nil || !more {
+			break
+		}
+		tr := tar.NewReader(r)
+		for {
+			var hdr *tar.Header
+			hdr, err = tr.Next()
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			var path string
+			path, err = safeJoin(dir, hdr.Name)
+			if err != nil {
+				return nil, err
+			}
+			if path == dir {
+				rootHdr = hdr
+				continue
+			}
+			if err = extractEntry(tr, hdr, path, dir); err != nil {
+				return nil, fmt.Errorf("extracting %s: %s", hdr.Name, err)
+			}
+			if hdr.Typeflag == tar.TypeDir {
+				dirs = append(dirs, hdr)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	//Creating entries changes a dir's mtime, and restrictive perms could
+	//prevent entries from being created. Apply dir metadata last, deepest
+	//first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, _ := safeJoin(dir, dirs[i].Name)
+		if err = setMeta(path, dirs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return rootHdr, nil
+}
+
+//skip any zero blocks preceding the next archive. Returns false at the end of
+//the stream.
+func skipZeroBlocks(r *bufio.Reader) (more bool, err error) {
+	zero := make([]byte, tarBlockSize)
+	for {
+		blk, err := r.Peek(tarBlockSize)
+		if err == io.EOF && len(blk) == 0 {
+			return false, nil
+		}
+		if err == io.EOF {
+			return false, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(blk, zero) {
+			return true, nil
+		}
+		if _, err = r.Discard(tarBlockSize); err != nil {
+			return false, err
+		}
+	}
+}
+
+//join name to dir, refusing any result outside of dir
+func safeJoin(dir, name string) (string, error) {
+	path := fp.Join(dir, name)
+	if path != dir && !strings.HasPrefix(path, dir+string(fp.Separator)) {
+		return "", fmt.Errorf("%s is outside of destination", name)
+	}
+	return path, nil
+}
+
+//create a single entry at path. dir is the top-level dir, used for hard links.
+func extractEntry(tr *tar.Reader, hdr *tar.Header, path, dir string) (err error) {
+	//parent dirs may not have their own entries in the archive
+	if err = os.MkdirAll(fp.Dir(path), 0755); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		//as with tar, replace rather than write through existing files
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	perm := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err = os.Mkdir(path, 0700)
+		if os.IsExist(err) {
+			var fi os.FileInfo
+			fi, err = os.Lstat(path)
+			if err == nil && !fi.IsDir() {
+				err = fmt.Errorf("exists and is not a dir")
+			}
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		err = writeFile(tr, path)
+	case tar.TypeSymlink:
+		err = os.Symlink(hdr.Linkname, path)
+	case tar.TypeLink:
+		//shares metadata with its target, so return early
+		var tgt string
+		tgt, err = safeJoin(dir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		return os.Link(tgt, path)
+	case tar.TypeChar:
+		err = mknod(path, unix.S_IFCHR|perm, hdr)
+	case tar.TypeBlock:
+		err = mknod(path, unix.S_IFBLK|perm, hdr)
+	case tar.TypeFifo:
+		err = mknod(path, unix.S_IFIFO|perm, hdr)
+	default:
+		log.Logf("untar: skipping %s, unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	if err != nil || hdr.Typeflag == tar.TypeDir {
+		return err
+	}
+	return setMeta(path, hdr)
+}
+
+func writeFile(r io.Reader, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func mknod(path string, mode uint32, hdr *tar.Header) error {
+	dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+	return unix.Mknod(path, mode, int(dev))
+}
+
+//setMeta applies ownership, permissions, xattrs, and times from hdr. As with
+//tar, ownership is only preserved when running as root. Order matters: chown
+//clears setuid bits and security.capability.
+func setMeta(path string, hdr *tar.Header) error {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(path, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+	for k, v := range hdr.PAXRecords {
+		if !strings.HasPrefix(k, xattrPaxPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(k, xattrPaxPrefix)
+		if err := unix.Lsetxattr(path, attr, []byte(v), 0); err != nil {
+			log.Logf("untar: setting xattr %s on %s: %s", attr, hdr.Name, err)
+		}
+	}
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(hdr.ModTime.UnixNano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+//move staged content into root, replacing anything in the way
+func unstage(staging, root string) error {
+	entries, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		dst := fp.Join(root, e.Name())
+		if err = os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err = os.Rename(fp.Join(staging, e.Name()), dst); err != nil {
+			return err
+		}
+	}
+	return os.Remove(staging)
+}
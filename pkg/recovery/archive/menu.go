@@ -18,11 +18,18 @@
 	"github.com/purecloudlabs/gprovision/pkg/log"
 )
 
-//display a menu. if lcd is present, uses that; otherwise vga.
-func Menu(choices []string) []string {
+//label of the lcd menu item accepting all of the policy's choices
+const menuAll = "All, in policy order"
+
+//display a menu. if lcd is present, uses that; otherwise vga. the policy
+//which produced choices is logged, and shown as the vga menu's title or when
+//confirming an lcd choice. on the lcd, a final item accepts all of the
+//policy's choices without narrowing them.
+func Menu(choices []string, pol Policy) []string {
 	var choice cfa.Choice
+	log.Logf("image menu, policy: %s", pol)
 	if cfa.DefaultLcd == nil {
-		return VgaMenu(choices)
+		return VgaMenu("Images available (policy: "+pol.String()+")", choices)
 	}
 	var shorts []string
 	for _, c := range choices {
@@ -34,19 +41,24 @@ func Menu(choices []string) []string {
 		s = strings.TrimSuffix(s, ".upd")
 		shorts = append(shorts, s)
 	}
-	choice, _ = cfa.DefaultLcd.MenuWithConfirm("image menu", cfa.Strs2LTxt(shorts...), 5*time.Minute, time.Minute, false)
-	if choice >= 0 {
+	items := append(shorts, menuAll)
+	desc := "images (policy " + pol.String() + ")"
+	choice, _ = cfa.DefaultLcd.MenuWithConfirm(desc, cfa.Strs2LTxt(items...), 5*time.Minute, time.Minute, false)
+	switch {
+	case choice < 0:
+		return nil
+	case int(choice) < len(choices):
 		return []string{choices[choice]}
 	}
-	return nil
+	return choices
 }
 
-//list images on screen, ask user to make a choice
+//list images on screen under title, ask user to make a choice
 //returns string array with length 1
-func VgaMenu(choices []string) []string {
+func VgaMenu(title string, choices []string) []string {
 	log.Msg("displaying menu on vga")
 	fmt.Printf("\n\n=======================================\n")
-	fmt.Println("Images available:")
+	fmt.Printf("%s:\n", title)
 	for i, c := range choices {
 		fmt.Printf("\t%d. %s\n", i+1, c)
 	}
@@ -0,0 +1,87 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package meta
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Target describes the unit an image would be applied to.
+type Target struct {
+	CodeName string //platform code name
+	Family   string //platform family name
+	Kernel   string //kernel release of recovery env, as with `uname -r`
+	DiskSize uint64 //bytes
+}
+
+//CheckCompat returns an error describing the first constraint in im which
+//excludes tgt, or nil if the image is compatible.
+func (im *ImgMeta) CheckCompat(tgt Target) error {
+	if len(im.Platforms) > 0 && !containsFold(im.Platforms, tgt.CodeName) {
+		return fmt.Errorf("platform %s not in %v", tgt.CodeName, im.Platforms)
+	}
+	if len(im.Families) > 0 && !containsFold(im.Families, tgt.Family) {
+		return fmt.Errorf("family %s not in %v", tgt.Family, im.Families)
+	}
+	if im.MinKernel != "" && CompareVersions(tgt.Kernel, im.MinKernel) < 0 {
+		return fmt.Errorf("kernel %s older than %s", tgt.Kernel, im.MinKernel)
+	}
+	if im.MinDiskSize > 0 && tgt.DiskSize < im.MinDiskSize {
+		return fmt.Errorf("disk size %d less than %d", tgt.DiskSize, im.MinDiskSize)
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//CompareVersions compares dotted version strings such as kernel releases,
+//returning -1, 0, or 1. Only leading numeric components are considered, so
+//4.19.114-foo is equal to 4.19.114. Missing components are treated as 0.
+func CompareVersions(a, b string) int {
+	va, vb := versionParts(a), versionParts(b)
+	for len(va) < len(vb) {
+		va = append(va, 0)
+	}
+	for len(vb) < len(va) {
+		vb = append(vb, 0)
+	}
+	for i := range va {
+		if va[i] < vb[i] {
+			return -1
+		}
+		if va[i] > vb[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) (parts []uint64) {
+	//stop at the first char that's neither digit nor dot
+	end := strings.IndexFunc(v, func(r rune) bool { return r != '.' && (r < '0' || r > '9') })
+	if end >= 0 {
+		v = v[:end]
+	}
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return
+}
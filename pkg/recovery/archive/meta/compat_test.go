@@ -0,0 +1,60 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package meta
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, td := range []struct {
+		a, b string
+		want int
+	}{
+		{"4.19.114", "4.19.114", 0},
+		{"4.19.114-gprov", "4.19.114", 0},
+		{"4.19.114", "4.19", 1},
+		{"4.19", "4.19.0", 0},
+		{"4.9.1", "4.19.0", -1},
+		{"5.4.0+", "4.19.114", 1},
+		{"", "4.19", -1},
+	} {
+		if got := CompareVersions(td.a, td.b); got != td.want {
+			t.Errorf("CompareVersions(%q, %q): want %d, got %d", td.a, td.b, td.want, got)
+		}
+	}
+}
+
+func TestCheckCompat(t *testing.T) {
+	tgt := Target{
+		CodeName: "QEMU",
+		Family:   "qemu",
+		Kernel:   "4.19.114",
+		DiskSize: 5 * 1024 * 1024 * 1024,
+	}
+	for _, td := range []struct {
+		name string
+		im   ImgMeta
+		ok   bool
+	}{
+		{"unconstrained", ImgMeta{}, true},
+		{"platform", ImgMeta{Platforms: []string{"other", "qemu"}}, true},
+		{"wrong platform", ImgMeta{Platforms: []string{"other"}}, false},
+		{"family", ImgMeta{Families: []string{"QEMU"}}, true},
+		{"wrong family", ImgMeta{Families: []string{"other"}}, false},
+		{"kernel", ImgMeta{MinKernel: "4.19"}, true},
+		{"old kernel", ImgMeta{MinKernel: "5.4"}, false},
+		{"disk", ImgMeta{MinDiskSize: 1024}, true},
+		{"small disk", ImgMeta{MinDiskSize: 6 * 1024 * 1024 * 1024}, false},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			err := td.im.CheckCompat(tgt)
+			if (err == nil) != td.ok {
+				t.Errorf("want ok=%t, got %v", td.ok, err)
+			}
+		})
+	}
+}
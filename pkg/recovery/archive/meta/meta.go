@@ -38,8 +38,31 @@ type ImgMeta struct {
 	ImgJob    string
 	ImgName   string
 	Stream    string
+
+	//Delta images only. Base is the name of the image this is a delta against,
+	//and Removed lists paths present in Base which must be deleted.
+	Base    string   `json:",omitempty"`
+	Removed []string `json:",omitempty"`
+
+	//Compatibility constraints; see CheckCompat. Empty values impose no
+	//constraint.
+	Platforms   []string `json:",omitempty"` //compatible platform code names
+	Families    []string `json:",omitempty"` //compatible platform families
+	MinKernel   string   `json:",omitempty"` //min kernel release in recovery env
+	MinDiskSize uint64   `json:",omitempty"` //bytes
+
+	Contents []FileHash `json:",omitempty"` //hashes of files in image
 }
 
+//FileHash is the sha256 of one file in an image.
+type FileHash struct {
+	Path   string
+	Sha256 string
+}
+
+//IsDelta returns true if the image must be applied on top of a base image.
+func (im *ImgMeta) IsDelta() bool { return im.Base != "" }
+
 //Disktag returns disktag name for given platform.
 func (im *ImgMeta) Disktag(plat string) string {
 	if strings.Count(im.ImgName, "HW") != 1 {
@@ -67,6 +90,25 @@ func (im *ImgMeta) String() string {
 	if im.Stream != "main_systest" {
 		str += fmt.Sprintln("Alt Tier: ", im.Stream)
 	}
+	if im.IsDelta() {
+		str += fmt.Sprintln("Base:     ", im.Base)
+		str += fmt.Sprintf("Removed:   %d path(s)\n", len(im.Removed))
+	}
+	if len(im.Platforms) > 0 {
+		str += fmt.Sprintln("Platforms:", strings.Join(im.Platforms, ", "))
+	}
+	if len(im.Families) > 0 {
+		str += fmt.Sprintln("Families: ", strings.Join(im.Families, ", "))
+	}
+	if im.MinKernel != "" {
+		str += fmt.Sprintln("MinKernel:", im.MinKernel)
+	}
+	if im.MinDiskSize > 0 {
+		str += fmt.Sprintf("MinDisk:   %dM\n", im.MinDiskSize/(1024*1024))
+	}
+	if len(im.Contents) > 0 {
+		str += fmt.Sprintf("Contents:  %d file hash(es)\n", len(im.Contents))
+	}
 	return str
 }
 
@@ -8,17 +8,18 @@
 package archive
 
 import (
+	"archive/tar"
 	"bytes"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	fp "path/filepath"
 	"testing"
+	"time"
 
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/fileutil"
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
-	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
 )
 
 const (
@@ -80,56 +81,20 @@ func TestUpdSort(t *testing.T) {
 		"PRODUCT.Os.Platform-02.2015-02033.1593.gho",*/
 	}
 	testUpdSort(t, "New Test 1", unsorted1, sorted1, false)
-}
 
-//func validateExtractUpd(updPath string) (err error)
-func TestValidationLowMem(t *testing.T) {
-	tlog := testlog.NewTestLog(t, true, false)
-	lowMemoryDevice = true
-	err := testValidation(t)
-	tlog.Freeze()
-	if err != nil {
-		t.Logf("log contents:\n%s\n", tlog.Buf.String())
+	unsorted2 := []string{
+		strs.ImgPrefix() + "2018-04-05.9.upd",
+		strs.ImgPrefix() + "2018-04-05.10.upd",
+		strs.ImgPrefix() + "2018-04-04.11.upd",
 	}
-}
-
-func TestValidationNormalMem(t *testing.T) {
-	tlog := testlog.NewTestLog(t, true, false)
-	lowMemoryDevice = false
-	//use Tempfile to get a safe file name
-	f, err := ioutil.TempFile("", "recovery_archive_test_decompress")
-	if err != nil {
-		t.Fatalf("%s", err)
-	}
-	f.Close()
-	decompressBuf = f.Name()
-	defer os.Remove(decompressBuf)
-	if err = testValidation(t); err != nil {
-		t.Errorf("test validation: %s", err)
-	}
-	tlog.Freeze()
-	fi, err := os.Stat(decompressBuf)
-	if err != nil {
-		t.Fatalf("%s", err)
-	}
-	size := fi.Size()
-	t.Logf("decompressed size: %d", size)
-	if size != tenMegs {
-		t.Logf("log contents:\n%s\n", tlog.Buf.String())
-		t.Errorf("decompressed to wrong size: got %d, want %d. name=%s", size, tenMegs, decompressBuf)
+	sorted2 := []string{
+		strs.ImgPrefix() + "2018-04-05.10.upd",
+		strs.ImgPrefix() + "2018-04-05.9.upd",
+		strs.ImgPrefix() + "2018-04-04.11.upd",
 	}
+	testUpdSort(t, "build numbers", unsorted2, sorted2, true)
 }
 
-func testValidation(t *testing.T) (err error) {
-	fname := create10McompressedDummy(t, true)
-	defer os.Remove(fname)
-
-	err = validateExtractUpd(fname)
-	if err != nil {
-		t.Errorf("%s", err)
-	}
-	return
-}
 func create10McompressedDummy(t *testing.T, shaChecksum bool) string {
 	args := []string{}
 	if shaChecksum {
@@ -154,16 +119,17 @@ func create10McompressedDummy(t *testing.T, shaChecksum bool) string {
 	return f.Name()
 }
 
-//func ApplyUpdate(target *disk.Filesystem)
-//creates xz-compressed tarball for applyUpd to extract, checks output file name/size to verify decompress worked
+//func extractUpd(root string, layers []layer) error
+//creates xz-compressed tarball to extract, checks output file name/size to verify decompress worked
 func TestExtract(t *testing.T) {
 	tlog := testlog.NewTestLog(t, true, false)
-	lowMemoryDevice = true
 	d, err := ioutil.TempDir("", "recovery_archive_testdir")
 	if err != nil {
 		t.Errorf("%s", err)
 	}
 	defer os.RemoveAll(d)
+	allowUnsigned = true
+	keyring = nil
 	f, err := ioutil.TempFile("", "tar-input")
 	if err != nil {
 		t.Fatalf("%s", err)
@@ -184,8 +150,7 @@ func TestExtract(t *testing.T) {
 		t.Fatalf("%s", err)
 	}
 	defer g.Close()
-	updateFullPath = g.Name()
-	defer os.Remove(updateFullPath)
+	defer os.Remove(g.Name())
 	xz.Stdout = g
 	err = tar.Run()
 	if err != nil {
@@ -196,20 +161,166 @@ func TestExtract(t *testing.T) {
 		t.Logf("xz error: %s", err)
 	}
 
-	fs := disk.TestFilesystem(d)
-	if !applyUpd(&fs) {
+	if err = extractUpd(d, []layer{{upd: g.Name()}}); err != nil {
 		t.Logf("log contents:\n%s\n", tlog.Buf.String())
-		t.Errorf("applyUpd failed")
+		t.Errorf("extractUpd failed: %s", err)
 	}
 	fi, err := os.Stat(fp.Join(d, f.Name()))
 	if err != nil {
-		t.Errorf("stat error: %s", err)
+		t.Fatalf("stat error: %s", err)
 	}
 	size := fi.Size()
 	if size != tenMegs {
 		t.Logf("log contents:\n%s\n", tlog.Buf.String())
 		t.Errorf("decompressed to wrong size: got %d, want %d", size, tenMegs)
 	}
+	if _, err = os.Stat(fp.Join(d, stagingDir)); !os.IsNotExist(err) {
+		t.Errorf("staging dir not removed: %v", err)
+	}
+}
+
+//checks that links, device nodes, perms, and concatenated archives are handled
+func TestExtractEntryTypes(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	d, err := ioutil.TempDir("", "recovery_archive_testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	allowUnsigned = true
+	keyring = nil
+	mtime := time.Date(2019, 12, 10, 1, 2, 3, 0, time.UTC)
+	part1 := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0751},
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: mtime},
+		{Name: "etc/file", Typeflag: tar.TypeReg, Mode: 04755, Size: 4, ModTime: mtime},
+		{Name: "etc/hard", Typeflag: tar.TypeLink, Linkname: "etc/file"},
+		{Name: "etc/sym", Typeflag: tar.TypeSymlink, Linkname: "file"},
+	}
+	part2 := []*tar.Header{
+		{Name: "dev/fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+		{Name: "etc/file", Typeflag: tar.TypeReg, Mode: 0640, Size: 4, ModTime: mtime},
+	}
+	upd := fp.Join(d, "test.upd")
+	xzTar(t, upd, part1, part2)
+	root := fp.Join(d, "root")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = extractUpd(root, []layer{{upd: upd}}); err != nil {
+		t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		t.Fatalf("extractUpd: %s", err)
+	}
+
+	checkMode := func(name string, want os.FileMode) {
+		t.Helper()
+		fi, err := os.Lstat(fp.Join(root, name))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if fi.Mode() != want {
+			t.Errorf("%s: want mode %s, got %s", name, want, fi.Mode())
+		}
+	}
+	checkMode(".", os.ModeDir|0751)
+	checkMode("etc", os.ModeDir|0700)
+	checkMode("etc/file", 0640)
+	checkMode("dev/fifo", os.ModeNamedPipe|0600)
+	checkMode("etc/sym", os.ModeSymlink|0777)
+
+	fi, err := os.Stat(fp.Join(root, "etc"))
+	if err == nil && !fi.ModTime().Equal(mtime) {
+		t.Errorf("etc: want mtime %s, got %s", mtime, fi.ModTime())
+	}
+	if lnk, err := os.Readlink(fp.Join(root, "etc/sym")); err != nil || lnk != "file" {
+		t.Errorf("etc/sym: got %q, %v", lnk, err)
+	}
+	//hard link was created before file was replaced, so should retain old content
+	data, err := ioutil.ReadFile(fp.Join(root, "etc/hard"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("etc/hard: got %q, %v", data, err)
+	}
+	data, err = ioutil.ReadFile(fp.Join(root, "etc/file"))
+	if err != nil || string(data) != "DATA" {
+		t.Errorf("etc/file: got %q, %v", data, err)
+	}
+}
+
+//corrupted image must fail to extract, leaving nothing behind
+func TestExtractCorrupt(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	d, err := ioutil.TempDir("", "recovery_archive_testdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	allowUnsigned = true
+	keyring = nil
+	upd := fp.Join(d, "test.upd")
+	xzTar(t, upd, []*tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+	})
+	data, err := ioutil.ReadFile(upd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//flip a bit in the checksum at the end of the block
+	data[len(data)-40] ^= 1
+	if err = ioutil.WriteFile(upd, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := fp.Join(d, "root")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = extractUpd(root, []layer{{upd: upd}})
+	tlog.Freeze()
+	if err == nil {
+		t.Errorf("corrupt image extracted without error")
+	}
+	if !fileutil.IsEmptyDir(root) {
+		t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		t.Errorf("content remains after failed extraction")
+	}
+}
+
+//writes an xz-compressed file consisting of one tar archive per element of
+//parts. Regular files contain "data" in the first archive and "DATA" after.
+func xzTar(t *testing.T, name string, parts ...[]*tar.Header) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	for i, hdrs := range parts {
+		tw := tar.NewWriter(buf)
+		for _, h := range hdrs {
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			if h.Typeflag == tar.TypeReg {
+				content := "data"
+				if i > 0 {
+					content = "DATA"
+				}
+				if _, err := tw.Write([]byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	xz := exec.Command("xz", "-C", "sha256")
+	xz.Stdin = buf
+	xz.Stdout = f
+	if err := xz.Run(); err != nil {
+		t.Fatalf("run xz: %s", err)
+	}
 }
 
 // func IsXZSha256(fname string) bool
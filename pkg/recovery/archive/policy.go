@@ -0,0 +1,182 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+/* Image selection policy.
+ *
+ * A policy is a comma-separated list of terms, each of which is a name,
+ * optionally followed by a colon and an argument:
+ *
+ *   newest       consider newest images first (default)
+ *   oldest       consider oldest/original images first
+ *   menu         let the user choose among images allowed by the policy
+ *   pin:NAME     only consider the named image
+ *   maxfail:N    skip images with more than N recorded boot failures
+ *   lastgood     consider the last image known to boot successfully first
+ *   stream:NAME  only consider images whose metadata has the given stream
+ *
+ * Policies can come from FRData, the PREFERRED_UPDATE env var, and the boot
+ * menu, with terms from each overriding those from the preceding.
+ */
+
+import (
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/fr"
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+//Policy determines which images are considered, and in what order.
+type Policy struct {
+	OldestFirst bool
+	Menu        bool
+	Pin         string //if set, only consider this image
+	MaxFails    int    //if non-negative, skip images with more boot failures
+	LastGood    bool   //try the last known good image first
+	Stream      string //if set, only consider images in this stream
+}
+
+//DefaultPolicy considers all images, newest first.
+func DefaultPolicy() Policy { return Policy{MaxFails: -1} }
+
+//Parse applies the terms in spec to p. Unrecognized or malformed
+//terms are skipped, and reported in the returned error.
+func (p *Policy) Parse(spec string) error {
+	var bad []string
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		name, arg := term, ""
+		if i := strings.Index(term, ":"); i >= 0 {
+			name, arg = term[:i], term[i+1:]
+		}
+		name = strings.Trim(strings.ToLower(name), "-_")
+		ok := true
+		switch name {
+		case "newest":
+			p.OldestFirst = false
+		case "oldest":
+			p.OldestFirst = true
+		case "menu":
+			p.Menu = true
+		case "lastgood":
+			p.LastGood = true
+		case "pin":
+			p.Pin = strings.TrimSuffix(fp.Base(arg), ".upd")
+			ok = arg != ""
+		case "stream":
+			p.Stream = arg
+			ok = arg != ""
+		case "maxfail":
+			n, err := strconv.Atoi(arg)
+			ok = err == nil
+			if ok {
+				p.MaxFails = n
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			bad = append(bad, term)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("bad policy term(s) %q", bad)
+	}
+	return nil
+}
+
+//ParsePolicy returns a policy from the given spec, starting from DefaultPolicy.
+func ParsePolicy(spec string) (Policy, error) {
+	p := DefaultPolicy()
+	err := p.Parse(spec)
+	return p, err
+}
+
+//String returns a spec which parses to the same policy.
+func (p Policy) String() string {
+	terms := []string{"newest"}
+	if p.OldestFirst {
+		terms[0] = "oldest"
+	}
+	if p.Pin != "" {
+		terms = append(terms, "pin:"+p.Pin)
+	}
+	if p.Stream != "" {
+		terms = append(terms, "stream:"+p.Stream)
+	}
+	if p.MaxFails >= 0 {
+		terms = append(terms, fmt.Sprintf("maxfail:%d", p.MaxFails))
+	}
+	if p.LastGood {
+		terms = append(terms, "lastgood")
+	}
+	if p.Menu {
+		terms = append(terms, "menu")
+	}
+	return strings.Join(terms, ",")
+}
+
+//combine policy from FRData, env, and imgopt, in increasing order of priority
+func loadPolicy(imgopt string) Policy {
+	p := DefaultPolicy()
+	//env var could be set through the grub menu
+	for _, spec := range []string{fr.ImgPolicy(), os.Getenv("PREFERRED_UPDATE"), imgopt} {
+		if err := p.Parse(spec); err != nil {
+			log.Logf("image policy: %s", err)
+		}
+	}
+	return p
+}
+
+//apply filters and reorders choices, which must already be sorted. If nothing
+//is left, the policy is ignored - better to restore some image than none.
+func (p Policy) apply(choices []string) []string {
+	var lkg string
+	if p.LastGood {
+		lkg = history.LastKnownGood()
+	}
+	var out []string
+	for _, c := range choices {
+		name := trimmedName(c)
+		dtag := dt.ImgToDTag(name)
+		if p.Pin != "" && name != p.Pin && dtag != p.Pin {
+			continue
+		}
+		if p.MaxFails >= 0 && history.BootFailures(dtag) > uint(p.MaxFails) {
+			log.Logf("policy: skipping %s, too many boot failures", name)
+			continue
+		}
+		if p.Stream != "" {
+			m, err := meta.Read(c)
+			if err != nil || m.Stream != p.Stream {
+				log.Logf("policy: skipping %s, not in stream %s", name, p.Stream)
+				continue
+			}
+		}
+		if lkg != "" && dtag == lkg {
+			out = append([]string{c}, out...)
+			continue
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 && len(choices) > 0 {
+		log.Msgf("no image matches policy %s, ignoring", p)
+		return choices
+	}
+	return out
+}
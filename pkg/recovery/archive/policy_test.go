@@ -0,0 +1,103 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, td := range []struct {
+		spec string
+		want Policy
+		bad  bool
+	}{
+		{spec: "", want: DefaultPolicy()},
+		{spec: "OLDEST", want: Policy{OldestFirst: true, MaxFails: -1}},
+		{spec: "-menu-", want: Policy{Menu: true, MaxFails: -1}},
+		{spec: "stream:beta, maxfail:2,lastgood", want: Policy{Stream: "beta", MaxFails: 2, LastGood: true}},
+		{spec: "pin:/mnt/Image/img.upd", want: Policy{Pin: "img", MaxFails: -1}},
+		{spec: "maxfail:x,newest,bogus", want: DefaultPolicy(), bad: true},
+	} {
+		t.Run(td.spec, func(t *testing.T) {
+			got, err := ParsePolicy(td.spec)
+			if (err != nil) != td.bad {
+				t.Errorf("unexpected error state %v", err)
+			}
+			if got != td.want {
+				t.Errorf("want %#v, got %#v", td.want, got)
+			}
+			again, err := ParsePolicy(got.String())
+			if err != nil || again != got {
+				t.Errorf("%s: round trip failed: %#v %v", got, again, err)
+			}
+		})
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var imgs []string
+	for i, stream := range []string{"main", "beta", "main"} {
+		name := strs.ImgPrefix() + "2020-01-0" + string('3'-rune(i)) + ".1"
+		upd := fp.Join(dir, name+".upd")
+		writeImg(t, upd, &meta.ImgMeta{ImgName: name, Stream: stream}, nil)
+		imgs = append(imgs, upd)
+	}
+	good := time.Now()
+	history.WriteArbitraryHistory(dir, history.ResultList{
+		{Image: trimmedName(imgs[0]), BootFailures: 3},
+		{Image: trimmedName(imgs[2]), LastGoodBoot: &good},
+	})
+	history.Load()
+
+	for _, td := range []struct {
+		spec string
+		want []string
+	}{
+		{spec: "", want: imgs},
+		{spec: "stream:main", want: []string{imgs[0], imgs[2]}},
+		{spec: "maxfail:2", want: imgs[1:]},
+		{spec: "lastgood", want: []string{imgs[2], imgs[0], imgs[1]}},
+		{spec: "pin:" + trimmedName(imgs[1]), want: imgs[1:2]},
+		{spec: "stream:main,maxfail:2", want: imgs[2:]},
+		//nothing matches, so policy is ignored
+		{spec: "stream:none", want: imgs},
+	} {
+		pol, err := ParsePolicy(td.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := pol.apply(imgs)
+		if !reflect.DeepEqual(got, td.want) {
+			t.Errorf("%s: want\n%q\ngot\n%q", td.spec, td.want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+/* Image retention.
+ *
+ * Images accumulate in the Image dir on the recovery volume. Prune deletes
+ * those which are no longer useful, keeping
+ *  - the original factory image (the oldest)
+ *  - the last image known to boot successfully, per history
+ *  - the newest images
+ *  - the image chosen by FindValidUpd, if any
+ *  - the base(s) of any kept delta image
+ * Detached signatures are deleted along with their images.
+ */
+
+import (
+	"fmt"
+	"os"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+//DefaultKeepNewest is the number of newest images kept by Prune during factory restore.
+const DefaultKeepNewest = 3
+
+//Prune deletes images in dir which are no longer needed, returning the paths
+//of those deleted. If dryRun is true, nothing is deleted. If history is to be
+//taken into account, it must already be loaded.
+func Prune(dir string, keepNewest int, dryRun bool) (removed []string, err error) {
+	imgs, err := listImages(dir)
+	if err != nil {
+		return nil, err
+	}
+	imgs = sortUpdates(imgs, false)
+	keep := make(map[string]string) //path -> reason
+	if orig := original(imgs); orig != "" {
+		keep[orig] = "original"
+	}
+	lkg := history.LastKnownGood()
+	for i, img := range imgs {
+		if i < keepNewest {
+			keep[img] = "newest"
+		}
+		if lkg != "" && dt.ImgToDTag(trimmedName(img)) == lkg {
+			keep[img] = "last known good"
+		}
+		if img == updateFullPath {
+			keep[img] = "current"
+		}
+	}
+	keepBases(dir, keep)
+
+	for _, img := range imgs {
+		if reason, ok := keep[img]; ok {
+			log.Logf("retain: keeping %s (%s)", fp.Base(img), reason)
+			continue
+		}
+		log.Logf("retain: removing %s", fp.Base(img))
+		if !dryRun {
+			if err = os.Remove(img); err != nil {
+				return removed, err
+			}
+			if err = os.Remove(img + sig.Ext); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		removed = append(removed, img)
+	}
+	return removed, nil
+}
+
+//return paths of files in dir which look like images. no validation is done.
+func listImages(dir string) ([]string, error) {
+	matches, err := fp.Glob(fp.Join(dir, strs.ImgPrefix()+"*.upd"))
+	if err != nil {
+		return nil, err
+	}
+	var imgs []string
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+			imgs = append(imgs, m)
+		}
+	}
+	return imgs, nil
+}
+
+//the oldest image with a well-formed name. imgs must be sorted newest first.
+func original(imgs []string) string {
+	for i := len(imgs) - 1; i >= 0; i-- {
+		if decode(fp.Base(imgs[i])).date != 0 {
+			return imgs[i]
+		}
+	}
+	return ""
+}
+
+//mark the base of any kept delta as kept, recursively
+func keepBases(dir string, keep map[string]string) {
+	var todo []string
+	for img := range keep {
+		todo = append(todo, img)
+	}
+	for len(todo) > 0 {
+		img := todo[0]
+		todo = todo[1:]
+		m, err := meta.Read(img)
+		if err != nil || !m.IsDelta() {
+			continue
+		}
+		base := fp.Join(dir, strings.TrimSuffix(m.Base, ".upd")+".upd")
+		if _, ok := keep[base]; ok {
+			continue
+		}
+		keep[base] = fmt.Sprintf("base of %s", fp.Base(img))
+		todo = append(todo, base)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+func TestPrune(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	updateFullPath = ""
+
+	//index 0 is oldest
+	var imgs []string
+	for _, date := range []string{"2019-01-01", "2019-02-01", "2019-03-01", "2019-04-01", "2019-05-01", "2019-06-01", "2019-07-01"} {
+		imgs = append(imgs, fp.Join(dir, strs.ImgPrefix()+date+".1.upd"))
+	}
+	for i, img := range imgs {
+		m := &meta.ImgMeta{ImgName: trimmedName(img)}
+		if i == 6 {
+			//newest is a delta against an otherwise-unneeded image
+			m.Base = fp.Base(imgs[3])
+		}
+		writeImg(t, img, m, nil)
+		if err = ioutil.WriteFile(img+sig.Ext, []byte("sig"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	good := time.Now()
+	history.WriteArbitraryHistory(dir, history.ResultList{
+		{Image: trimmedName(imgs[1]), LastGoodBoot: &good},
+	})
+	history.Load()
+
+	//keeps 2 newest, delta base, last known good, and original
+	want := []string{imgs[4], imgs[2]}
+	dry, err := Prune(dir, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dry, want) {
+		t.Errorf("dry run: want %q, got %q", want, dry)
+	}
+	if _, err = os.Stat(imgs[4]); err != nil {
+		t.Errorf("dry run removed image: %s", err)
+	}
+
+	removed, err := Prune(dir, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("want %q, got %q", want, removed)
+	}
+	left, err := fp.Glob(fp.Join(dir, "*.upd*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantLeft []string
+	for i, img := range imgs {
+		if i != 2 && i != 4 {
+			wantLeft = append(wantLeft, img, img+sig.Ext)
+		}
+	}
+	sort.Strings(left)
+	sort.Strings(wantLeft)
+	if !reflect.DeepEqual(left, wantLeft) {
+		t.Errorf("remaining files: want\n%q\ngot\n%q", wantLeft, left)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+/* Images are checked against the keys in KeyringDir, which is baked into the
+ * initramfs. See package sig for the signature and keyring formats.
+ *
+ * Images may be on user-inserted media, which need not return the same data
+ * each time it is read. The signature is therefore checked against the digest
+ * computed while the image is extracted (see extractLayer), before anything is
+ * moved out of staging; when choosing an image, only the presence of a
+ * signature is checked.
+ */
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	fp "path/filepath"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/history"
+)
+
+//dir in initramfs containing trusted public keys
+var KeyringDir = "/etc/keys/upd"
+
+//signed returns true if upd could pass checkSig: it has a signature and there
+//are keys to check it with, or unsigned images are allowed. Failures are
+//logged and recorded in history under dtag.
+func signed(upd, dtag string, keys []ed25519.PublicKey, allowUnsigned bool) bool {
+	var err error
+	if _, e := os.Stat(upd + sig.Ext); e != nil {
+		err = sig.ErrUnsigned
+	} else if len(keys) == 0 {
+		err = sig.ErrNoKeys
+	}
+	if err == nil || allowUnsigned {
+		return true
+	}
+	detail := fmt.Sprintf("signature check failed: %s", err)
+	log.Msgf("%s: bad or missing signature", fp.Base(upd))
+	log.Logf("%s: %s", fp.Base(upd), detail)
+	history.AddEvent(dtag, history.Event{
+		Stage:  history.StageValidation,
+		Reason: history.ReasonSignature,
+		Detail: detail,
+	})
+	return false
+}
+
+//checkSig verifies the signature on upd against sum, the sha256 digest of the
+//data read from it, logging the result and recording it in history under dtag.
+//Images lacking a verifiable signature are only accepted if allowUnsigned is
+//true; bad signatures are never accepted.
+func checkSig(upd, dtag string, sum []byte, keys []ed25519.PublicKey, allowUnsigned bool) bool {
+	name := fp.Base(upd)
+	err := sig.VerifyDigest(upd, sum, keys)
+	ev := history.Event{
+		Stage:  history.StageValidation,
+		Reason: history.ReasonSignature,
+	}
+	switch {
+	case err == nil:
+		ev.Detail = "signature ok"
+		ev.Success = true
+	case (err == sig.ErrUnsigned || err == sig.ErrNoKeys) && allowUnsigned:
+		ev.Detail = fmt.Sprintf("signature not verified (%s), accepted on prototype", err)
+		ev.Success = true
+	default:
+		ev.Detail = fmt.Sprintf("signature check failed: %s", err)
+		log.Msgf("%s: bad or missing signature", name)
+	}
+	log.Logf("%s: %s", name, ev.Detail)
+	history.AddEvent(dtag, ev)
+	return ev.Success
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+
+	"github.com/purecloudlabs/gprovision/pkg/common/sig"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+)
+
+func TestSignatures(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keydir := fp.Join(dir, "keys")
+	if err = os.Mkdir(keydir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	keyfile := fp.Join(keydir, "test.pub")
+	enc := "# test key\n" + base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err = ioutil.WriteFile(keyfile, []byte(enc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := sig.LoadKeyring(keydir)
+	if len(keys) != 1 {
+		t.Fatalf("want 1 key, got %d", len(keys))
+	}
+
+	upd := fp.Join(dir, "img.upd")
+	data := []byte("some image data")
+	if err = ioutil.WriteFile(upd, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+
+	if checkSig(upd, "img", sum[:], keys, false) || signed(upd, "img", keys, false) {
+		t.Error("unsigned image accepted on non-prototype")
+	}
+	if !checkSig(upd, "img", sum[:], keys, true) {
+		t.Error("unsigned image rejected on prototype")
+	}
+
+	if err = sig.Sign(upd, priv); err != nil {
+		t.Fatal(err)
+	}
+	if !checkSig(upd, "img", sum[:], keys, false) {
+		t.Error("signed image rejected")
+	}
+	if !checkSig(upd, "img", sum[:], nil, true) {
+		t.Error("image rejected on prototype without keys")
+	}
+	if checkSig(upd, "img", sum[:], []ed25519.PublicKey{otherPub}, true) {
+		t.Error("signature accepted with wrong key")
+	}
+
+	//modify image; signature must no longer match
+	sum = sha256.Sum256([]byte("some other image data"))
+	if checkSig(upd, "img", sum[:], keys, true) {
+		t.Error("bad signature accepted on prototype")
+	}
+}
+
+//the signature is checked against the data extracted
+func TestExtractSigned(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "recovery_archive_test_sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowUnsigned = false
+	keyring = []ed25519.PublicKey{pub}
+	defer func() { keyring = nil }()
+
+	upd := fp.Join(dir, "img.upd")
+	writeImg(t, upd, &meta.ImgMeta{ImgName: "img"}, map[string]string{"a": "signed"})
+	if err = sig.Sign(upd, priv); err != nil {
+		t.Fatal(err)
+	}
+	root := fp.Join(dir, "root")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = extractUpd(root, []layer{{upd: upd}}); err != nil {
+		t.Fatalf("signed image: %s", err)
+	}
+
+	//replace the image, keeping the old signature
+	writeImg(t, upd, &meta.ImgMeta{ImgName: "img"}, map[string]string{"b": "unsigned"})
+	if err = extractUpd(root, []layer{{upd: upd}}); err == nil {
+		t.Error("image extracted despite signature mismatch")
+	}
+	if _, err = os.Stat(fp.Join(root, "b")); !os.IsNotExist(err) {
+		t.Errorf("content of image with bad signature unstaged: %v", err)
+	}
+}
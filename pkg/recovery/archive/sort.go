@@ -10,7 +10,7 @@
 /* Sort update names by date, newest first.
  * Names must be in a particular format
  *
- * sort value: yymmdd.build (float)
+ * sort value: yyyymmdd, then build
  * prod. os rev . platform .yyyy-mm-dd.build.ext
  */
 
@@ -21,23 +21,29 @@
 )
 
 type update struct {
-	name string
-	date float64 //yymmdd.hhmm
+	name  string
+	date  int //yyyymmdd
+	build int
 }
 type updates []update
 
-func (s updates) Less(i, j int) bool { return s[i].date < s[j].date }
-func (s updates) Len() int           { return len(s) }
-func (s updates) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s updates) Less(i, j int) bool {
+	if s[i].date == s[j].date {
+		return s[i].build < s[j].build
+	}
+	return s[i].date < s[j].date
+}
+func (s updates) Len() int      { return len(s) }
+func (s updates) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
 /* decode image name for sorting
- * sort value: yymmdd.build (float) -- prefers date for sort, but in the event of a collision a higer build wins
+ * sort value: yyyymmdd, build -- prefers date for sort, but in the event of a collision a higher build wins.
+ * builds are compared as integers, so build 10 is newer than build 9
  * prod.os&rev.platform.yyyy-mm-dd.build
  *   0    1       2         3       4
  */
 func decode(name string) (u update) {
 	u.name = name
-	u.date = 0.0
 	parts := strings.Split(name, ".")
 	if (len(parts) != 6) || (len(parts[3]) != 10) {
 		return
@@ -51,21 +57,18 @@ func decode(name string) (u update) {
 			return
 		}
 	}
-	yyyy := dateIn[0]
-	mm := dateIn[1]
-	dd := dateIn[2]
-
 	if (len(parts[4]) == 0) || (!allDigits(parts[4])) {
 		return
 	}
-	build := "." + parts[4]
-
-	var err error
-	//                               2015 02 33 .1593
-	u.date, err = strconv.ParseFloat(yyyy+mm+dd+build, 64)
+	date, err := strconv.Atoi(dateIn[0] + dateIn[1] + dateIn[2])
 	if err != nil {
-		u.date = 0.0
+		return
+	}
+	build, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return
 	}
+	u.date, u.build = date, build
 	return
 }
 
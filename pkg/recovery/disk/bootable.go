@@ -32,7 +32,7 @@
 //determine whether system is uefi or legacy and make it bootable. returns list of additional partitions for fstab
 func MakeBootable(disks []*Disk, mainFS, recov *Filesystem, platform *appliance.Variant, extraOpts string) []*Filesystem {
 	if uefi.BootedUEFI() {
-		return ConfigUEFIBoot(recov, nil, false, extraOpts)
+		return ConfigUEFIBoot(recov, nil, platform, false, extraOpts)
 	}
 	return WriteLegacyBootParts(disks, mainFS, recov, platform, extraOpts)
 }
@@ -40,7 +40,8 @@ func MakeBootable(disks []*Disk, mainFS, recov *Filesystem, platform *appliance.
 //add missing boot entries or overwrite all
 //return the ESP partition as a Filesystem (for adding to fstab)
 //in recovery, boot entries should be correct
-func ConfigUEFIBoot(recov, ESP *Filesystem, overwriteBootEnts bool, extraOpts string) (bootParts []*Filesystem) {
+//with systemd-boot, the entries are loader entries on the ESP; see uefi.SdBoot
+func ConfigUEFIBoot(recov, ESP *Filesystem, platform *appliance.Variant, overwriteBootEnts bool, extraOpts string) (bootParts []*Filesystem) {
 	log.Logf("configuring UEFI boot entries...")
 	if recov.mountPoint == "" {
 		log.Fatalf("recov mountpoint must be set")
@@ -65,14 +66,22 @@ func ConfigUEFIBoot(recov, ESP *Filesystem, overwriteBootEnts bool, extraOpts st
 	ESP.Mount()
 	ESP.SetMountpoint(fp.Join(recov.mountPoint, "ESP"))
 	bootParts = append(bootParts, ESP)
+	sd := sdBoot(ESP, platform)
 	entries := uefi.AllBootEntryVars()
-	if !overwriteBootEnts && entries.OursPresent() {
+	if !overwriteBootEnts && entries.OursPresent(sd) {
 		log.Logf("boot entries present, not overwriting")
 		return
 	}
+	stale := entries.OtherLayout(sd)
 	if overwriteBootEnts {
 		log.Logf("removing our boot entries")
-		for _, e := range entries.Ours() {
+		stale = entries.Ours()
+		if sd != nil {
+			sd.RemoveEntries()
+		}
+	}
+	if len(stale) > 0 {
+		for _, e := range stale {
 			log.Logf("remove %s", e.Description)
 			err = e.Remove()
 			if err != nil {
@@ -95,6 +104,7 @@ func ConfigUEFIBoot(recov, ESP *Filesystem, overwriteBootEnts bool, extraOpts st
 		Device:  dev[:len(dev)-1],
 		PartNum: uint(part),
 		AbsPath: "/" + strs.BootKernel(),
+		SdBoot:  sd,
 	}
 	tmpl.Args = "quiet"
 	if args := kArgsFromEnv(false); len(args) > 0 {
@@ -106,6 +116,23 @@ func ConfigUEFIBoot(recov, ESP *Filesystem, overwriteBootEnts bool, extraOpts st
 	return
 }
 
+//returns systemd-boot config if the platform uses it, otherwise nil. UKIs are
+//built from the kernel on the ESP, and include an initramfs if there is one
+//next to the kernel, named <kernel>.initrd.
+func sdBoot(ESP *Filesystem, platform *appliance.Variant) *uefi.SdBoot {
+	if platform == nil || platform.Bootloader() != appliance.BootloaderSdBoot {
+		return nil
+	}
+	sd := &uefi.SdBoot{
+		ESP:    ESP.Path(),
+		Kernel: fp.Join(ESP.Path(), strs.BootKernel()),
+	}
+	if _, err := os.Stat(sd.Kernel + ".initrd"); err == nil {
+		sd.Initrd = sd.Kernel + ".initrd"
+	}
+	return sd
+}
+
 //find recovery volume by looking for the fs label
 func FindRecovery(platform *appliance.Variant) (recovery *Filesystem) {
 	if platform == nil {
@@ -142,7 +169,7 @@ func CreateRecovery(platform *appliance.Variant, recoverySize uint64, extraOpts
 		identifier: fp.Base(c[0]),
 	}
 	log.Msgf("using %s as %s", d.identifier, strs.RecVolName())
-	err := PartitionRecovery(d)
+	err := PartitionRecovery(d, platform)
 	if err != nil {
 		log.Fatalf("partitioning recovery: %s", err)
 	}
@@ -152,23 +179,24 @@ func CreateRecovery(platform *appliance.Variant, recoverySize uint64, extraOpts
 	recov = &Filesystem{
 		blkdev:     dev,
 		isRecovery: true,
-		mountType:  "ext3",
+		mountType:  d.spec(d.numParts).FsType,
+		mkfsOpts:   platform.MkfsOpts(d.spec(d.numParts).FsType),
 	}
 
 	_ = recov.Format(strs.RecVolName())
 
 	recov.mountPoint = "/mnt/recov"
 	recov.Mount()
-	if d.numParts == 2 {
+	if n := d.partNum(appliance.RoleESP); n > 0 {
 		//create Efi System Partition
 		esp := &Filesystem{
-			blkdev:    "/dev/" + d.identifier + "1",
+			blkdev:    "/dev/" + d.identifier + fmt.Sprint(n),
 			mountType: "vfat",
 		}
 		_ = esp.Format("ESP")
 		esp.mountPoint = recov.mountPoint + "/ESP"
 		esp.Mount()
-		ConfigUEFIBoot(recov, esp, true, extraOpts)
+		ConfigUEFIBoot(recov, esp, platform, true, extraOpts)
 	} else {
 		recov.InstallGrub4Dos()
 	}
@@ -370,21 +398,27 @@ func WriteLegacyBootParts(disks []*Disk, target, recov *Filesystem, platform *ap
 	grubConf := finalizeGrubConf(target.Fsid(), extraOpts)
 	useLatestKernel(target, recov)
 
-	bootTgt := func(i int) int {
-		if i == 1 {
-			return 2
-		}
-		return 1
-	}
-
 	for i, d := range disks {
+		n := d.partNum(appliance.RoleBoot)
+		if n < 1 {
+			log.Logf("%s: no boot partition in layout", d.identifier)
+			continue
+		}
+		spec := d.spec(n)
 		b := new(Filesystem)
 		bootParts = append(bootParts, b)
-		bdev := fmt.Sprintf("%s%d", d.identifier, bootTgt(d.target))
+		bdev := fmt.Sprintf("%s%d", d.identifier, n)
 		b.blkdev = fp.Join("/dev", bdev)
 		b.mountPoint = fp.Join("/mnt", bdev)
-		b.mountOpts = "noauto,relatime,noexec"
-		b.mountType = "ext3" //grub4dos fails with ext4
+		b.mountOpts = spec.MountOpts
+		if b.mountOpts == "" {
+			b.mountOpts = "noauto,relatime,noexec"
+		}
+		b.mountType = spec.FsType
+		if b.mountType == "" {
+			b.mountType = "ext3" //grub4dos fails with ext4
+		}
+		b.mkfsOpts = platform.MkfsOpts(b.mountType)
 		if platform.SSD() {
 			b.mountOpts += ",discard"
 		}
@@ -0,0 +1,79 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package disk
+
+import (
+	"io/ioutil"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block/luks"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//Encrypt creates a LUKS2 container on the fs's block device and opens it, so
+//that Format creates the fs inside the container. Must be called before
+//Format. A tpm2 key is enrolled if requested, but failure to do so is not
+//fatal since the unit can still be unlocked with key.
+func (fs *Filesystem) Encrypt(key []byte, enc *appliance.Encryption) error {
+	dev := fs.blkdev
+	log.Msgf("Encrypting %s...", dev)
+	if err := luks.Format(dev, luks.RootLabel(), key); err != nil {
+		return err
+	}
+	uuid, err := luks.UUID(dev)
+	if err != nil {
+		return err
+	}
+	fs.tpm2 = false
+	if enc.TPM2 {
+		if err = luks.EnrollTPM2(dev, key, enc.TPM2PCRs); err != nil {
+			log.Logf("enrolling tpm2 key on %s: %s", dev, err)
+		} else {
+			fs.tpm2 = true
+		}
+	}
+	discard := strings.Contains(fs.mountOpts, "discard")
+	mapped, err := luks.Open(dev, luks.RootName, key, discard)
+	if err != nil {
+		return err
+	}
+	fs.luksDev = dev
+	fs.luksUUID = uuid
+	fs.blkdev = mapped
+	return nil
+}
+
+//Encrypted returns true if the fs is inside a LUKS container.
+func (fs Filesystem) Encrypted() bool { return fs.luksDev != "" }
+
+//WriteCrypttab writes etc/crypttab for an encrypted fs. Does nothing if the fs
+//is not encrypted.
+func (fs Filesystem) WriteCrypttab() {
+	if !fs.Encrypted() {
+		return
+	}
+	discard := strings.Contains(fs.mountOpts, "discard")
+	entry := luks.CrypttabEntry(luks.RootName, fs.luksUUID, discard, fs.tpm2)
+	err := ioutil.WriteFile(fp.Join(fs.Path(), "etc", "crypttab"), []byte(entry), 0600)
+	if err != nil {
+		log.Logf("write crypttab: %s", err)
+	}
+}
+
+//close any open container holding the primary volume, so arrays and disks
+//can be reused
+func closeCrypt() {
+	if !luks.IsActive(luks.RootName) {
+		return
+	}
+	if err := luks.Close(luks.RootName); err != nil {
+		log.Logf("closing %s: %s", luks.RootName, err)
+	}
+}
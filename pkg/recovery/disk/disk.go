@@ -18,7 +18,6 @@
 	"syscall"
 
 	"github.com/purecloudlabs/gprovision/pkg/appliance"
-	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/hw/block"
 	"github.com/purecloudlabs/gprovision/pkg/hw/block/partitioning"
 	"github.com/purecloudlabs/gprovision/pkg/hw/uefi"
@@ -30,6 +29,7 @@ type Disk struct {
 	size       int64
 	target     int //partition number to use when creating raid array (or root fs, on non-raid plats)
 	numParts   int //total number of partitions
+	parts      []appliance.PartSpec
 }
 
 func (d Disk) SizeBytes() int64 {
@@ -38,7 +38,8 @@ func (d Disk) SizeBytes() int64 {
 
 //find the raw disk(s) we'll partition and install to
 func FindTargets(platform *appliance.Variant) (disks []*Disk) {
-	//stop any raid arrays
+	//close encrypted volume and stop any raid arrays
+	closeCrypt()
 	stopArr := exec.Command("mdadm", "--stop", "--scan")
 	out, err := stopArr.CombinedOutput()
 	if err != nil {
@@ -47,7 +48,7 @@ func FindTargets(platform *appliance.Variant) (disks []*Disk) {
 	devs := block.Devices()
 	var candidates dlist
 	for _, dev := range devs {
-		candidates = append(candidates, &Disk{fp.Base(dev.Name), int64(dev.Size), -1, 0})
+		candidates = append(candidates, &Disk{identifier: fp.Base(dev.Name), size: int64(dev.Size), target: -1})
 	}
 	wantSize := platform.DiskSize()
 	wantNum := platform.DataDisks()
@@ -208,44 +209,154 @@ func zero(dev string, megs uint, whence int) {
 	}
 }
 
-//partition a disk. small boot partition, remainder for raid
+//partition a disk according to the platform's layout; by default, a small boot partition (legacy only) and
+//the remainder for the main volume or raid.
 //also zero beginning and end of disk so raid controller will ignore it if the controller somehow gets re-enabled
 func (d *Disk) Partition(platform *appliance.Variant) error {
 	d.Zero(250, io.SeekStart)
 	//SNIA DDF and iMSM require metadata be at end of device, so this will wipe it; GPT uses beginning and end so it gets wiped as well.
 	d.Zero(100, io.SeekEnd)
 
-	mainPartType := partitioning.Linux
-	if platform.HasRaid() {
-		mainPartType = partitioning.LinuxRaid
-	}
+	d.setLayout(platform)
 	pt := partitioning.NewPTable("/dev/" + d.identifier)
-	if uefi.BootedUEFI() {
-		//for uefi, we always only boot from the usb key (???)
-		pt.Add(0, mainPartType, false, strs.PriVolName())
-		d.target = 1 // target = main volume, 1st partition in this case
-		d.numParts = 1
-	} else {
-		pt.Add(200, partitioning.Linux, false, "g4d")
-		pt.Add(0, mainPartType, false, strs.PriVolName())
-		d.target = 2 // target = main volume, 2nd partition in this case
-		d.numParts = 2
+	for _, s := range d.parts {
+		ptype := s.Type
+		if s.Role == appliance.RoleRoot && platform.HasRaid() {
+			ptype = partitioning.LinuxRaid
+		}
+		pt.Add(s.Megs(uint64(d.size)), ptype, s.Boot, s.Label)
 	}
 	return pt.Commit()
 }
 
-//partition recovery device
-func PartitionRecovery(d *Disk) error {
-	pt := partitioning.NewPTable("/dev/" + d.identifier)
+func (d *Disk) setLayout(platform *appliance.Variant) {
+	d.parts = appliance.Select(platform.Layout().Data, uefi.BootedUEFI())
+	d.target = d.partNum(appliance.RoleRoot)
+	d.numParts = len(d.parts)
+}
+
+//UseLayout is like Partition, but leaves existing partitions alone. This is
+//for updating one A/B slot while preserving everything else. Returns an error
+//if the partitions on the disk don't match the layout.
+func (d *Disk) UseLayout(platform *appliance.Variant) error {
+	d.setLayout(platform)
+	existing, err := partitioning.Read(d.Device())
+	if err != nil {
+		return err
+	}
+	if len(existing) != d.numParts {
+		return fmt.Errorf("%s has %d partitions, layout has %d", d.Device(), len(existing), d.numParts)
+	}
+	return nil
+}
+
+//partition recovery device according to the platform's layout. by default, an ESP (uefi only) and the recovery
+//volume.
+func PartitionRecovery(d *Disk, platform *appliance.Variant) error {
+	specs := platform.Layout().Recovery
+	if len(specs) == 0 {
+		specs = appliance.DefaultLayout().Recovery
+	}
 	if uefi.BootedUEFI() {
 		log.Logf("Partitioning recovery drive with GPT scheme")
-		pt.Add(200, partitioning.ESP, true, "ESP")
-		pt.Add(0, partitioning.Linux, false, "recovery")
-		d.numParts = 2
 	} else {
 		log.Logf("Partitioning recovery drive with MBR scheme")
-		pt.Add(0, partitioning.Linux, true, "recovery")
-		d.numParts = 1
+	}
+	d.parts = appliance.Select(specs, uefi.BootedUEFI())
+	d.numParts = len(d.parts)
+	if d.size == 0 {
+		size, err := block.ReadSize(d.Device())
+		if err != nil {
+			log.Logf("reading size of %s: %s", d.Device(), err)
+		}
+		d.size = int64(size)
+	}
+	pt := partitioning.NewPTable(d.Device())
+	for _, s := range d.parts {
+		pt.Add(s.Megs(uint64(d.size)), s.Type, s.Boot, s.Label)
 	}
 	return pt.Commit()
 }
+
+//returns the 1-based number of the first partition with the given role, or -1
+func (d *Disk) partNum(r appliance.PartRole) int {
+	for i, s := range d.parts {
+		if s.Role == r {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+//returns the spec for partition n (1-based)
+func (d *Disk) spec(n int) appliance.PartSpec {
+	if n < 1 || n > len(d.parts) {
+		return appliance.PartSpec{}
+	}
+	return d.parts[n-1]
+}
+
+//FormatOther formats partitions in the layout which have no particular role,
+//but do have a fs type, returning them for inclusion in fstab. Such
+//partitions are only supported on single-disk platforms.
+func FormatOther(disks []*Disk, platform *appliance.Variant) (others []*Filesystem) {
+	if len(disks) != 1 {
+		return
+	}
+	d := disks[0]
+	for i, s := range d.parts {
+		if s.Role != appliance.RoleOther || s.FsType == "" {
+			continue
+		}
+		fs := d.otherFs(i+1, platform)
+		if err := fs.Format(s.Label); err != nil {
+			log.Logf("formatting %s: %s", fs.blkdev, err)
+			continue
+		}
+		if s.MountPoint != "" {
+			others = append(others, fs)
+		}
+	}
+	return
+}
+
+//ExistingOther is like FormatOther, but the partitions are left as they are.
+//Their uuids are read with blkid, so that fstab refers to them as FormatOther's
+//would.
+func ExistingOther(disks []*Disk, platform *appliance.Variant) (others []*Filesystem) {
+	if len(disks) != 1 {
+		return
+	}
+	d := disks[0]
+	for i, s := range d.parts {
+		if s.Role != appliance.RoleOther || s.FsType == "" || s.MountPoint == "" {
+			continue
+		}
+		fs := d.otherFs(i+1, platform)
+		fs.formatted = true
+		bi, err := block.GetInfo(fs.blkdev)
+		if err == nil && bi.UUID != "" {
+			fs.fsid = bi.UUID
+		} else {
+			log.Logf("%s: can't determine uuid, using device name in fstab: %v", fs.blkdev, err)
+		}
+		others = append(others, fs)
+	}
+	return
+}
+
+//fs for role-less partition n (1-based)
+func (d *Disk) otherFs(n int, platform *appliance.Variant) *Filesystem {
+	s := d.spec(n)
+	fs := &Filesystem{
+		blkdev:     fmt.Sprintf("/dev/%s%d", d.identifier, n),
+		mountType:  s.FsType,
+		mountOpts:  s.MountOpts,
+		mountPoint: s.MountPoint,
+		mkfsOpts:   platform.MkfsOpts(s.FsType),
+	}
+	if fs.mountOpts == "" {
+		fs.mountOpts = "auto,relatime"
+	}
+	return fs
+}
@@ -40,6 +40,15 @@ type Filesystem struct {
 	currentMountPoint    string //where fs is currently mounted (if different from mountPoint, else empty)
 	fsid                 string //unique identifier for fstab column 0
 	label                string //name used during formatting
+
+	//set before formatting
+	mkfsOpts []string //if non-nil, replaces default mkfs options
+	subvol   string   //btrfs only: subvolume to create and use as root of fs
+
+	//set by Encrypt
+	luksDev  string //underlying device
+	luksUUID string
+	tpm2     bool //tpm2 key enrolled
 }
 
 func ExistingExt4Fs(device string, mounted bool) (fs *Filesystem) {
@@ -111,6 +120,10 @@ func (fs Filesystem) FstabEntry(uid, gid string) (entry string) {
 	if fs.mountPoint == "/" {
 		pass = 1
 	}
+	if fs.mountType == "xfs" || fs.mountType == "btrfs" {
+		//fsck for these is a no-op or is harmful at boot
+		pass = 0
+	}
 	opts := strings.Replace(fs.mountOpts, "$u", uid, -1)
 	opts = strings.Replace(opts, "$g", gid, -1)
 	if len(fs.fsid) != 0 && !strings.Contains(fs.blkdev, "by-label") {
@@ -173,22 +186,7 @@ func (fs *Filesystem) Format(label string) (err error) {
 		fs.mountType = "ext4"
 	}
 	log.Logf("formatting %s as %s, label %s", fs.blkdev, fs.mountType, label)
-	var cmd string
-	var args []string
-	var expectUuid bool
-	if fs.mountType == "vfat" {
-		cmd = "mkdosfs"
-		args = []string{"-n", label, fs.blkdev}
-	} else {
-		args = []string{"-L", label, "-m", "1", "-t", fs.mountType}
-		if fs.mountType == "ext4" {
-			//if it's ext4, make it possible use directory encryption
-			args = append(args, "-O", "encrypt")
-		}
-		args = append(args, fs.blkdev)
-		cmd = "mke2fs"
-		expectUuid = true
-	}
+	cmd, args := fs.mkfsCmd(label)
 	mkfs := exec.Command(cmd, args...)
 	out, err := mkfs.CombinedOutput()
 	if err != nil {
@@ -199,7 +197,18 @@ func (fs *Filesystem) Format(label string) (err error) {
 	/* could run tune2fs to set max mount count/interval. however, mkfs defaults to
 	 * not do so; when errors are encountered fs will be marked as dirty anyway
 	 */
-	if expectUuid {
+	switch fs.mountType {
+	case "vfat":
+	case "xfs", "btrfs":
+		//output format varies between versions; ask blkid instead
+		bi, e := block.GetInfo(fs.blkdev)
+		if e != nil || bi.UUID == "" {
+			log.Logf("%s: can't determine uuid: %v", fs.blkdev, e)
+			err = os.ErrInvalid
+			return
+		}
+		fs.fsid = bi.UUID
+	default:
 		var uu, nl int
 		uu = bytes.Index(out, []byte("UUID: "))
 		if uu >= 0 {
@@ -213,10 +222,76 @@ func (fs *Filesystem) Format(label string) (err error) {
 		nl += uu
 		fs.fsid = string(out[uu+6 : nl])
 	}
+	if fs.subvol != "" {
+		if err = fs.createSubvol(); err != nil {
+			log.Logf("%s: creating subvolume %s: %s", fs.blkdev, fs.subvol, err)
+			return
+		}
+	}
 	fs.formatted = true
 	return
 }
 
+//default mkfs options, by fs type
+var defaultMkfsOpts = map[string][]string{
+	"ext2": {"-m", "1"},
+	"ext3": {"-m", "1"},
+	//if it's ext4, make it possible use directory encryption
+	"ext4": {"-m", "1", "-O", "encrypt"},
+}
+
+//mkfs command and args for fs type. options from the variant, if any, replace
+//the defaults.
+func (fs *Filesystem) mkfsCmd(label string) (cmd string, args []string) {
+	opts := fs.mkfsOpts
+	if opts == nil {
+		opts = defaultMkfsOpts[fs.mountType]
+	}
+	switch fs.mountType {
+	case "vfat":
+		cmd = "mkdosfs"
+		args = []string{"-n", label}
+	case "xfs":
+		cmd = "mkfs.xfs"
+		args = []string{"-f", "-L", label}
+	case "btrfs":
+		cmd = "mkfs.btrfs"
+		args = []string{"-f", "-L", label}
+	default:
+		cmd = "mke2fs"
+		args = []string{"-L", label, "-t", fs.mountType}
+	}
+	args = append(args, opts...)
+	args = append(args, fs.blkdev)
+	return
+}
+
+//create btrfs subvolume and make it the default, so that it is mounted
+//even without the subvol= option
+func (fs *Filesystem) createSubvol() error {
+	tmp, err := ioutil.TempDir("", "subvol")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err = mount.Mount(fs.blkdev, tmp, "btrfs", "", 0); err != nil {
+		return err
+	}
+	defer func() {
+		if e := mount.Unmount(tmp, false, false); e != nil {
+			log.Logf("umount %s: %s", tmp, e)
+		}
+	}()
+	sv := fp.Join(tmp, fs.subvol)
+	for _, args := range [][]string{{"create", sv}, {"set-default", sv}} {
+		btrfs := exec.Command("btrfs", append([]string{"subvolume"}, args...)...)
+		if out, err := btrfs.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s\n%s", btrfs.Args, err, out)
+		}
+	}
+	return nil
+}
+
 func (fs Filesystem) Fsid() string {
 	return fs.fsid
 }
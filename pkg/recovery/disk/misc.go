@@ -68,7 +68,7 @@ func CreateArray(disks []*Disk, host string, platform *appliance.Variant) (md *F
 	}
 	md.blkdev = "/dev/md0"
 	md.mountPoint = "/mnt/md0"
-	md.mountOpts = "auto,relatime"
+	md.setRootOpts(disks[0], platform)
 	if platform.SSD() {
 		md.mountOpts += ",discard"
 	}
@@ -93,13 +93,42 @@ func (d *Disk) CreateNonArray(platform *appliance.Variant) (fs *Filesystem) {
 	fs = new(Filesystem)
 	fs.blkdev = fmt.Sprintf("/dev/%s%d", d.identifier, d.target)
 	fs.mountPoint = fmt.Sprintf("/mnt/%s%d", d.identifier, d.target)
-	fs.mountOpts = "auto,relatime"
+	fs.setRootOpts(d, platform)
 	if platform.SSD() {
 		fs.mountOpts += ",discard"
 	}
 	return
 }
 
+//CreateSlot is like CreateNonArray, but uses the partition with the given
+//role, for A/B slots.
+func (d *Disk) CreateSlot(platform *appliance.Variant, role appliance.PartRole) *Filesystem {
+	d.target = d.partNum(role)
+	return d.CreateNonArray(platform)
+}
+
+//name of the btrfs subvolume used for root
+const rootSubvol = "@"
+
+//use fs type and mount options from the layout's root partition on d, and
+//mkfs options from the platform
+func (fs *Filesystem) setRootOpts(d *Disk, platform *appliance.Variant) {
+	spec := d.spec(d.target)
+	fs.mountType = spec.FsType
+	if fs.mountType == "" {
+		fs.mountType = "ext4"
+	}
+	fs.mountOpts = spec.MountOpts
+	if fs.mountOpts == "" {
+		fs.mountOpts = "auto,relatime"
+	}
+	fs.mkfsOpts = platform.MkfsOpts(fs.mountType)
+	if fs.mountType == "btrfs" {
+		fs.subvol = rootSubvol
+		fs.mountOpts += ",subvol=" + rootSubvol
+	}
+}
+
 const K_OVERRIDE = "KERNEL_OVERRIDE"
 
 // check the kernel version in the image and on recovery media, update whichever's older
@@ -8,9 +8,13 @@
 package disk
 
 import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
 	"github.com/purecloudlabs/gprovision/pkg/common/strs"
 	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
 )
@@ -61,6 +65,98 @@ func TestFixupRecoveryFS(t *testing.T) {
 	}
 }
 
+//func (fs *Filesystem) mkfsCmd(label string) (cmd string, args []string)
+func TestMkfsCmd(t *testing.T) {
+	for _, td := range []struct {
+		fsType string
+		opts   []string
+		want   string
+	}{
+		{"ext4", nil, "mke2fs -L lbl -t ext4 -m 1 -O encrypt /dev/sda2"},
+		{"ext3", nil, "mke2fs -L lbl -t ext3 -m 1 /dev/sda2"},
+		{"ext4", []string{}, "mke2fs -L lbl -t ext4 /dev/sda2"},
+		{"vfat", nil, "mkdosfs -n lbl /dev/sda2"},
+		{"xfs", nil, "mkfs.xfs -f -L lbl /dev/sda2"},
+		{"xfs", []string{"-m", "reflink=1"}, "mkfs.xfs -f -L lbl -m reflink=1 /dev/sda2"},
+		{"btrfs", nil, "mkfs.btrfs -f -L lbl /dev/sda2"},
+	} {
+		fs := Filesystem{blkdev: "/dev/sda2", mountType: td.fsType, mkfsOpts: td.opts}
+		cmd, args := fs.mkfsCmd("lbl")
+		got := strings.Join(append([]string{cmd}, args...), " ")
+		if got != td.want {
+			t.Errorf("%s %v:\nwant %s\ngot  %s", td.fsType, td.opts, td.want, got)
+		}
+	}
+}
+
+//func (fs *Filesystem) setRootOpts(d *Disk, platform *appliance.Variant)
+func TestSetRootOpts(t *testing.T) {
+	plat := appliance.TestSetupFrom("QEMU", "mfg", "prod", "sku", "SN123")
+	if plat == nil {
+		t.Fatal("no QEMU variant")
+	}
+	for _, td := range []struct {
+		spec   appliance.PartSpec
+		fsType string
+		subvol string
+		entry  string
+	}{
+		{
+			spec:   appliance.PartSpec{Role: appliance.RoleRoot},
+			fsType: "ext4",
+			entry:  `UUID="u" / ext4 auto,relatime 0 1 #/dev/sda1`,
+		},
+		{
+			spec:   appliance.PartSpec{Role: appliance.RoleRoot, FsType: "xfs", MountOpts: "auto,noatime"},
+			fsType: "xfs",
+			entry:  `UUID="u" / xfs auto,noatime 0 0 #/dev/sda1`,
+		},
+		{
+			spec:   appliance.PartSpec{Role: appliance.RoleRoot, FsType: "btrfs"},
+			fsType: "btrfs",
+			subvol: rootSubvol,
+			entry:  `UUID="u" / btrfs auto,relatime,subvol=@ 0 0 #/dev/sda1`,
+		},
+	} {
+		d := &Disk{target: 1, parts: []appliance.PartSpec{td.spec}}
+		fs := Filesystem{blkdev: "/dev/sda1", fsid: "u", mountPoint: "/"}
+		fs.setRootOpts(d, plat)
+		if fs.mountType != td.fsType || fs.subvol != td.subvol {
+			t.Errorf("want type %s subvol %q, got %s %q", td.fsType, td.subvol, fs.mountType, fs.subvol)
+		}
+		if got := strings.TrimSpace(fs.FstabEntry("1", "1")); got != td.entry {
+			t.Errorf("\nwant %s\ngot  %s", td.entry, got)
+		}
+	}
+}
+
+//func (fs Filesystem) WriteCrypttab()
+func TestWriteCrypttab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypttab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.Mkdir(fp.Join(dir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := TestFilesystem(dir)
+	fs.WriteCrypttab()
+	if _, err = os.Stat(fp.Join(dir, "etc", "crypttab")); !os.IsNotExist(err) {
+		t.Errorf("crypttab written for unencrypted fs: %v", err)
+	}
+	fs.luksDev, fs.luksUUID, fs.tpm2 = "/dev/md0", "abc", true
+	fs.mountOpts = "auto,relatime,discard"
+	fs.WriteCrypttab()
+	data, err := ioutil.ReadFile(fp.Join(dir, "etc", "crypttab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cryptroot UUID=abc none luks,discard,tpm2-device=auto\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+}
+
 //func kBuildNum(out, kpath string) (ver uint64, success bool)
 func TestKBuildNum(t *testing.T) {
 	data := []struct {
@@ -31,7 +31,22 @@
 // and have the `.upd` extension. Images are xz-compressed tar files, and must
 // have the XZ signature and have been compressed with the option to use the
 // SHA256 checksum. This signature and checksum type are verified during image
-// validation.
+// validation. The checksums themselves are verified as the image is
+// decompressed and extracted, in a single pass; content is extracted to a
+// staging dir and only moved into place once the whole image has been read.
+//
+// Each image must also have a detached ed25519 signature, stored next to the
+// image with `.sig` appended to the name (i.e. NAME.upd.sig). The signature is
+// checked against the public keys baked into the initramfs (see
+// archive.KeyringDir), and the result is recorded in the history file. Unsigned
+// images are rejected unless the platform is a prototype; images with a bad
+// signature are always rejected.
+//
+// An image may be a delta against a base image, in which case its embedded
+// metadata (meta.ImgMeta) names the base and lists paths removed from it. The
+// base must be present in /Image/ and pass the same checks; it may itself be a
+// delta. The base is extracted first and the delta over it, resulting in the
+// same content as a full image.
 //
 // Restore process
 //
@@ -41,13 +56,17 @@
 //	 * using that information, locates the recovery drive
 //	 * load factory restore config json, if it exists
 //	 * look for update files in Image/ on recovery drive
-//	 * sort updates, newest first
-//	 * go through update list, checking integrity with xz's embedded SHA256 checksum
+//	 * filter and order updates according to the image selection policy
+//	   (newest first by default; see archive.ParsePolicy)
+//	 * go through update list, checking origin with the detached signature
 //	     * stop when first valid update is found
 //	 * if a valid update has been found:
 //	     * reconfigure BIOS (supported platforms), disabling fake raid
 //	         * only happens during windows -> linux conversion
-//	     * update is applied
+//	     * update is applied, checking integrity with xz's embedded SHA256
+//	       checksum
+//	     * if the update is corrupt, the next valid update is applied instead
+//	 * prune images no longer needed from Image/ (see archive.Prune)
 //	 * if no valid update, reboot
 //	 * read OS password from encrypted file, insert into etc/shadow
 //	   * if file doesn't exist, serial number is hashed and used as password
@@ -55,6 +74,18 @@
 //	 * create flag file on main drive
 //	 * reboot
 //
+// In-place updates
+//
+// On units with A/B root slots (see appliance.Variant.ABSlots), the OS can
+// request an update with `abSlot -request-update`. On the next boot, init runs
+// recovery in update mode (also available as kernel arg frmode=update), which
+// chooses an image as above but writes it only to the inactive slot; the disk
+// is not repartitioned, and the active slot and role-less partitions are left
+// alone. The new slot is tried on the following boots and kept if the OS
+// reports it healthy (`imgHistory -health good`); otherwise, init falls back
+// to the previous slot and records a boot failure for the image. See package
+// ab.
+//
 // Magic files
 //
 // If emergency mode file(s) are found by init, the paths are passed to factory restore.
@@ -66,7 +97,7 @@
 //
 // - Emergency Image
 //
-// Any file > 1MB is assumed to be an image; the same signature requirements apply as with normal images.
+// Any file > 1MB is assumed to be an image; the same signature requirements apply as with normal images, including the detached signature.
 //
 // If the file looks like an image, recovery enters emergency imaging mode. Prefixes strs.EmergPfx() and `_` (if they exist) are removed from the name, with the remainder used as the disktag. In emergency imaging mode, this image is processed as normal, except that no other images are considered - regardless of whether this image is older, corrupt, etc.
 //
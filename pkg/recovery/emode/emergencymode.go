@@ -9,11 +9,11 @@
 package emode
 
 import (
-	"fmt"
 	"os"
 	fp "path/filepath"
 	"time"
 
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
 	"github.com/purecloudlabs/gprovision/pkg/fileutil"
 	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
 	hk "github.com/purecloudlabs/gprovision/pkg/init/housekeeping"
@@ -25,8 +25,9 @@
 )
 
 // CheckForEmergency() looks for emergency-mode file(s) and detects type. Only one of two return variables
-// is used at a time. If efiles contains multiple files, chooses first that's valid
-func CheckForEmergency(efiles []string) (jsons []string, image string) {
+// is used at a time. Images which are not compatible with plat are ignored; if several are compatible, the
+// user chooses one.
+func CheckForEmergency(efiles []string, plat *appliance.Variant) (jsons []string, image string) {
 	if len(efiles) == 0 {
 		return
 	}
@@ -38,17 +39,26 @@ func CheckForEmergency(efiles []string) (jsons []string, image string) {
 	var imgs []string
 	imgs, jsons = checkFiles(efiles)
 	if len(imgs) > 0 {
-		image = imgs[0]
-		if len(imgs) > 1 {
-			msg := fmt.Sprintf("At least 2 emergency images found. In 20s: blindly choosing 1st, %s.", fp.Base(image))
-			log.Log(msg)
-			_ = cfa.DefaultLcd.BlinkMsg(msg, cfa.Fade, time.Second*2, time.Second*20)
+		imgs = compatible(imgs, plat)
+		switch len(imgs) {
+		case 0:
+			mustPowerCycle(ReasonNoMatch)
+			return nil, ""
+		case 1:
+			image = imgs[0]
+		default:
+			image = choose(imgs)
+			if image == "" {
+				mustPowerCycle(ReasonNoChoice)
+				return nil, ""
+			}
 		}
+		log.Msgf("Emergency image: %s", fp.Base(image))
 		jsons = nil
 		return
 	}
 	if len(jsons) == 0 {
-		MustPowerCycle(ReasonCorruption)
+		mustPowerCycle(ReasonCorruption)
 	}
 	return
 }
@@ -78,8 +88,12 @@ func checkFiles(files []string) (imgs, jsons []string) {
 const (
 	ReasonCorruption = "errors were encountered (file corruption?)"
 	ReasonNoMatch    = "none are for this device"
+	ReasonNoChoice   = "no image was chosen"
 )
 
+//replaced in tests
+var mustPowerCycle = MustPowerCycle
+
 //never returns
 func MustPowerCycle(reason string) {
 	hk.Preboots.Perform(false)
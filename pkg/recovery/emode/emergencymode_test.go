@@ -0,0 +1,165 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package emode
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
+	"github.com/purecloudlabs/gprovision/pkg/log/testlog"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+)
+
+func TestCheckForEmergency(t *testing.T) {
+	tlog := testlog.NewTestLog(t, true, false)
+	defer func() {
+		tlog.Freeze()
+		if t.Failed() {
+			t.Logf("log contents:\n%s\n", tlog.Buf.String())
+		}
+	}()
+	dir, err := ioutil.TempDir("", "emode_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plat := appliance.TestSetupFrom("QEMU", "mfg", "prod", "sku", "SN123")
+	if plat == nil {
+		t.Fatal("no QEMU variant")
+	}
+	pfx := fp.Join(dir, strs.EmergPfx())
+	qemu := writeImg(t, pfx+"qemu.upd", &meta.ImgMeta{ImgName: "qemu", Platforms: []string{"QEMU"}})
+	other := writeImg(t, pfx+"other.upd", &meta.ImgMeta{ImgName: "other", Platforms: []string{"cputest1"}})
+	generic := writeImg(t, pfx+"generic.upd", &meta.ImgMeta{ImgName: "generic"})
+	//no metadata; compatibility determined by name
+	namedQemu := writeNoMeta(t, pfx+"WIDGET.QEMU.upd")
+	namedOther := writeNoMeta(t, pfx+"WIDGET.cputest1.upd")
+	jsonFile := fp.Join(dir, strs.EmergPfx()+"cfg.json")
+	if err = ioutil.WriteFile(jsonFile, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origMenu, origConfirm := menuTime, confirmTime
+	menuTime, confirmTime = 100*time.Millisecond, 100*time.Millisecond
+	var cycleReason string
+	mustPowerCycle = func(reason string) { cycleReason = reason }
+	defer func() {
+		menuTime, confirmTime = origMenu, origConfirm
+		mustPowerCycle = MustPowerCycle
+		cfa.DefaultLcd = nil
+	}()
+
+	for _, td := range []struct {
+		name       string
+		files      []string
+		keys       []cfa.KeyActivity
+		wantImg    string
+		wantJsons  int
+		wantReason string
+	}{
+		{name: "meta", files: []string{other, qemu}, wantImg: qemu},
+		{name: "name", files: []string{namedOther, namedQemu}, wantImg: namedQemu},
+		{name: "jsonIgnored", files: []string{jsonFile, qemu}, wantImg: qemu},
+		{name: "json", files: []string{jsonFile}, wantJsons: 1},
+		{name: "noMatch", files: []string{other, namedOther, jsonFile}, wantReason: ReasonNoMatch},
+		{
+			name:    "menu",
+			files:   []string{qemu, other, generic},
+			keys:    []cfa.KeyActivity{cfa.KEY_DOWN_RELEASE, cfa.KEY_ENTER_RELEASE, cfa.KEY_RIGHT_RELEASE, cfa.KEY_ENTER_RELEASE},
+			wantImg: generic,
+		},
+		{
+			name:       "menuCancel",
+			files:      []string{qemu, generic},
+			keys:       []cfa.KeyActivity{cfa.KEY_EXIT_RELEASE, cfa.KEY_RIGHT_RELEASE, cfa.KEY_ENTER_RELEASE},
+			wantReason: ReasonNoChoice,
+		},
+		{name: "menuTimeout", files: []string{qemu, generic}, wantReason: ReasonNoChoice},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			cycleReason = ""
+			wg := new(sync.WaitGroup)
+			lcd, err := cfa.MockLcd(cfa.Cfa631, wg, td.keys...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfa.DefaultLcd = lcd
+			defer func() {
+				cfa.DefaultLcd = nil
+				lcd.Close()
+				wg.Wait()
+			}()
+
+			jsons, img := CheckForEmergency(td.files, plat)
+			if img != td.wantImg {
+				t.Errorf("want image %q, got %q", td.wantImg, img)
+			}
+			if len(jsons) != td.wantJsons {
+				t.Errorf("want %d jsons, got %v", td.wantJsons, jsons)
+			}
+			if cycleReason != td.wantReason {
+				t.Errorf("want power cycle reason %q, got %q", td.wantReason, cycleReason)
+			}
+		})
+	}
+}
+
+//write an xz image containing only metadata
+func writeImg(t *testing.T, name string, m *meta.ImgMeta) string {
+	t.Helper()
+	mdata, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	h := &tar.Header{Name: meta.MetaPath, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(mdata))}
+	if err = tw.WriteHeader(h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tw.Write(mdata); err != nil {
+		t.Fatal(err)
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	xz := exec.Command("xz", "-C", "sha256")
+	xz.Stdin = buf
+	xz.Stdout = f
+	if err = xz.Run(); err != nil {
+		t.Fatalf("run xz: %s", err)
+	}
+	return name
+}
+
+//write a file with an xz header, but no valid content
+func writeNoMeta(t *testing.T, name string) string {
+	t.Helper()
+	hdr := []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00, 0x0a}
+	if err := ioutil.WriteFile(name, append(hdr, make([]byte, 64)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
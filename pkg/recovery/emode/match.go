@@ -0,0 +1,89 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package emode
+
+import (
+	"fmt"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/appliance"
+	"github.com/purecloudlabs/gprovision/pkg/common/strs"
+	"github.com/purecloudlabs/gprovision/pkg/hw/cfa"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive/meta"
+)
+
+//returns the images in imgs which are compatible with plat. Uses the image's
+//metadata if possible, otherwise the file name.
+func compatible(imgs []string, plat *appliance.Variant) (matches []string) {
+	tgt := archive.CompatTarget(plat)
+	for _, img := range imgs {
+		m, err := meta.Read(img)
+		if err == nil {
+			err = m.CheckCompat(tgt)
+		} else {
+			log.Logf("%s: reading metadata: %s; checking name", img, err)
+			err = nameCompat(img, tgt.CodeName)
+		}
+		if err != nil {
+			log.Logf("emergency image %s: %s", fp.Base(img), err)
+			continue
+		}
+		matches = append(matches, img)
+	}
+	log.Logf("%d of %d emergency images compatible", len(matches), len(imgs))
+	return
+}
+
+//An image lacking metadata is incompatible if a field of its name is the code
+//name of another platform. Fields are delimited by '.' or '_'.
+func nameCompat(img, codeName string) error {
+	fields := strings.FieldsFunc(shortName(img), func(r rune) bool { return r == '.' || r == '_' })
+	for _, f := range fields {
+		if strings.EqualFold(f, codeName) {
+			return nil
+		}
+		if appliance.Get(f) != nil {
+			return fmt.Errorf("name is for platform %s, not %s", f, codeName)
+		}
+	}
+	return nil
+}
+
+//name without dir, emergency prefix, or suffix
+func shortName(img string) string {
+	s := fp.Base(img)
+	s = strings.TrimPrefix(s, strs.EmergPfx())
+	s = strings.TrimPrefix(s, "_")
+	return strings.TrimSuffix(s, ".upd")
+}
+
+//how long to wait for user input; replaced in tests
+var menuTime, confirmTime = 5 * time.Minute, time.Minute
+
+//ask the user to choose one of imgs. if lcd is present, uses that; otherwise
+//vga. returns "" if no choice is made.
+func choose(imgs []string) string {
+	if cfa.DefaultLcd == nil {
+		return archive.VgaMenu("Emergency images for this device", imgs)[0]
+	}
+	var shorts []string
+	for _, img := range imgs {
+		shorts = append(shorts, shortName(img))
+	}
+	choice, _ := cfa.DefaultLcd.MenuWithConfirm("emergency images", cfa.Strs2LTxt(shorts...), menuTime, confirmTime, false)
+	if choice < 0 || int(choice) >= len(imgs) {
+		log.Logf("emergency image menu: no choice (%d)", choice)
+		return ""
+	}
+	log.Logf("emergency image menu choice: %s", imgs[choice])
+	return imgs[choice]
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package history
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/purecloudlabs/gprovision/pkg/log"
+)
+
+//Stage at which an event occurred.
+type Stage string
+
+const (
+	StageValidation Stage = "validation" //checks before imaging: signature, compatibility, etc
+	StageImaging    Stage = "imaging"
+	StageBoot       Stage = "boot"
+	StageHealth     Stage = "health" //first-boot health check, run by the booted OS
+)
+
+//Reason is a machine-readable code for the outcome of an event.
+type Reason string
+
+const (
+	ReasonSignature    Reason = "signature"
+	ReasonIncompatible Reason = "incompatible"
+	ReasonChain        Reason = "chain" //problem with delta image's base
+	ReasonExtract      Reason = "extract"
+	ReasonImaging      Reason = "imaging"
+	ReasonBoot         Reason = "boot"
+	ReasonHealth       Reason = "health"
+)
+
+//Event records an imaging or boot attempt, health check, or validation
+//result. Only failures with non-zero Severity count against the image.
+type Event struct {
+	Time       time.Time
+	Stage      Stage
+	Success    bool
+	Severity   uint   `json:",omitempty"`
+	Reason     Reason `json:",omitempty"`
+	Detail     string `json:",omitempty"`
+	Kernel     string `json:",omitempty"` //kernel release in use
+	Bootloader string `json:",omitempty"`
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("%s @ %s, success: %t", e.Stage, e.Time.Format(time.RFC3339), e.Success)
+	if e.Severity > 0 {
+		s += fmt.Sprintf(", severity: %d", e.Severity)
+	}
+	if e.Reason != "" {
+		s += fmt.Sprintf(", reason: %s", e.Reason)
+	}
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	}
+	return s
+}
+
+//FailureHalfLife is the time over which the weight of a failure event halves,
+//so that an image isn't rejected forever due to transient problems. Zero
+//disables decay.
+var FailureHalfLife = 14 * 24 * time.Hour
+
+//Score returns the weighted sum of failures for the image, which Check
+//compares with MaxFailuresPerImg. Failures are weighted by severity and
+//decay with age. Once the image passes a health check, earlier failures
+//are ignored. Failures counted before events were recorded don't decay.
+func (img *ImageResult) Score() float64 {
+	return img.score(time.Now())
+}
+
+func (img *ImageResult) score(now time.Time) float64 {
+	var since time.Time
+	if img.KnownGood != nil {
+		since = *img.KnownGood
+	}
+	var score float64
+	var evFailures uint
+	for _, ev := range img.Events {
+		if ev.Success {
+			continue
+		}
+		evFailures += ev.Severity
+		if ev.Time.Before(since) {
+			continue
+		}
+		w := float64(ev.Severity)
+		if FailureHalfLife > 0 && now.After(ev.Time) {
+			w *= math.Pow(0.5, float64(now.Sub(ev.Time))/float64(FailureHalfLife))
+		}
+		score += w
+	}
+	legacy := img.ImagingFailures + img.BootFailures
+	if since.IsZero() && legacy > evFailures {
+		score += float64(legacy - evFailures)
+	}
+	return score
+}
+
+//AddEvent appends an event to the record for imgName, creating the record if
+//necessary. Order of records is not changed. Attempt and failure counts are
+//not changed; use RecordBootState or RebootHook for that.
+func AddEvent(imgName string, ev Event) {
+	if len(histPath) == 0 {
+		log.Logf("history: no path, discarding event for %s: %s", imgName, ev)
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	result := find(imgName)
+	if result == nil {
+		result = &ImageResult{Image: imgName}
+		results = append(results, result)
+	}
+	result.Events = append(result.Events, ev)
+	write(results)
+}
+
+func find(imgName string) *ImageResult {
+	for i := range results {
+		if results[i].Image == imgName {
+			return results[i]
+		}
+	}
+	return nil
+}
+
+//Health is the result of the first-boot health check.
+type Health struct {
+	Image      string //disktag name, without suffix
+	Healthy    bool
+	Severity   uint //for failures; defaults to 1
+	Reason     Reason
+	Detail     string
+	Bootloader string
+}
+
+//ReportHealth is for use by the booted OS, to record the result of its
+//first-boot health check. root is the mount point of the recovery volume.
+//A healthy image is marked known good; an unhealthy one loses that mark
+//and accrues failures, as with RecordBootState.
+func ReportHealth(root string, h Health) {
+	SetRoot(root)
+	ev := Event{
+		Time:       time.Now(),
+		Stage:      StageHealth,
+		Success:    h.Healthy,
+		Reason:     h.Reason,
+		Detail:     h.Detail,
+		Bootloader: h.Bootloader,
+	}
+	if !h.Healthy {
+		ev.Severity = h.Severity
+		if ev.Reason == "" {
+			ev.Reason = ReasonHealth
+		}
+	}
+	recordBoot(h.Image, ev)
+}
+
+//release of the running kernel
+func kernelRelease() string {
+	rel, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(rel))
+}
@@ -13,6 +13,7 @@
 package history
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -28,7 +29,8 @@
 )
 
 const (
-	histName = "recovery_history.json"
+	histName    = "recovery_history.json"
+	histVersion = 2 //current version of serializationFmt. 2: Events, KnownGood
 )
 
 var (
@@ -38,18 +40,26 @@
 )
 
 type ImageResult struct {
-	Image           string   //image name
-	ImagingAttempts uint     `json:",omitempty"` //currently only written, not used
-	ImagingFailures uint     `json:",omitempty"`
-	BootAttempts    uint     `json:",omitempty"` //currently only written, not used
-	BootFailures    uint     `json:",omitempty"`
-	Notes           []string `json:",omitempty"` //record timestamp+reason for failure
+	Image           string     //image name
+	ImagingAttempts uint       `json:",omitempty"`
+	ImagingFailures uint       `json:",omitempty"`
+	BootAttempts    uint       `json:",omitempty"`
+	BootFailures    uint       `json:",omitempty"` //sum of severities
+	Notes           []string   `json:",omitempty"` //free-form; only in files predating Events
+	Events          []Event    `json:",omitempty"`
+	LastGoodBoot    *time.Time `json:",omitempty"` //time of most recent successful boot
+	KnownGood       *time.Time `json:",omitempty"` //time of most recent passing health check, unless a later one failed
 }
 type ResultList []*ImageResult
 
-//makes the json look nice
+//makes the json look nice. Checksum is the sha256 of ImageResults, which is
+//kept in raw form so the checksum can be verified. Files written before
+//Version was added lack both Version and Checksum; they are migrated on the
+//next write.
 type serializationFmt struct {
-	ImageResults ResultList
+	Version      int    `json:",omitempty"`
+	Checksum     string `json:",omitempty"`
+	ImageResults json.RawMessage
 }
 
 //Sets where the history file can be found. Path is stored for use by other functions in the package.
@@ -75,65 +85,131 @@ func Rollover(path string) {
 	if err != nil && !os.IsNotExist(err) {
 		log.Logf("history log - roll %s: %s", histPath, err)
 	}
+	//otherwise Load would recover from it
+	err = os.Remove(bakPath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Logf("history log - removing %s: %s", bakPath(), err)
+	}
 }
 
-//Reads history file, comparing stored count with MaxFailures
-func Load() (ok bool) {
-	if len(histPath) == 0 {
-		panic("dir for history file must be specified")
-	}
-	_, err := os.Stat(histPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Logf("%s does not exist, assuming new install", histPath)
-			return true
-		} else {
-			log.Logf("error %s stat'ing %s", err, histPath)
-			return false
-		}
-	}
+//path of backup copy of history file, which holds the previous version
+func bakPath() string { return histPath + ".bak" }
+
+func checksum(data []byte) string { return fmt.Sprintf("%x", sha256.Sum256(data)) }
 
-	data, err := ioutil.ReadFile(histPath)
+//reads and validates a history file
+func readHist(path string) (res ResultList, version int, err error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Logf("error %s reading %s", err, histPath)
-		return false
+		return nil, 0, err
 	}
 	var content serializationFmt
 	err = json.Unmarshal(data, &content)
 	if err != nil {
+		return nil, 0, err
+	}
+	if content.Version > 0 && checksum(content.ImageResults) != content.Checksum {
+		return nil, 0, fmt.Errorf("%s: checksum mismatch", path)
+	}
+	if len(content.ImageResults) > 0 {
+		err = json.Unmarshal(content.ImageResults, &res)
+	}
+	return res, content.Version, err
+}
+
+//Reads history file, comparing stored count with MaxFailures. If the file is
+//damaged, the backup copy is used.
+func Load() (ok bool) {
+	if len(histPath) == 0 {
+		panic("dir for history file must be specified")
+	}
+	res, version, err := readHist(histPath)
+	if err == nil {
+		if version < histVersion {
+			log.Logf("%s: migrating from version %d to %d", histPath, version, histVersion)
+		} else if version > histVersion {
+			log.Logf("%s: version %d is newer than %d, some data may be ignored", histPath, version, histVersion)
+		}
+		results = res
+		return true
+	}
+	if !os.IsNotExist(err) {
 		log.Logf("Error %s loading imaging history", err)
 		futil.RenameUnique(histPath, histName+"_bad")
-	} else {
-		results = content.ImageResults
 	}
-	return
+	res, _, bakErr := readHist(bakPath())
+	if bakErr == nil {
+		log.Logf("imaging history recovered from %s", bakPath())
+		results = res
+		write(results)
+		return true
+	}
+	if os.IsNotExist(err) && os.IsNotExist(bakErr) {
+		log.Logf("%s does not exist, assuming new install", histPath)
+		return true
+	}
+	if !os.IsNotExist(bakErr) {
+		log.Logf("Error %s loading backup imaging history", bakErr)
+	}
+	return false
 }
 
-//Check returns false if too many failures are recorded for an image, true otherwise.
+//Check returns false if too many failures are recorded for an image, true
+//otherwise. See ImageResult.Score.
 func Check(name string) (ok bool) {
+	if img := find(name); img != nil {
+		return img.Score() <= float64(MaxFailuresPerImg)
+	}
+	return true
+}
+
+//Results returns the records loaded or written most recently.
+func Results() ResultList { return results }
+
+//BootFailures returns the sum of boot failure severities recorded for an image.
+func BootFailures(name string) uint {
 	for _, img := range results {
 		if img.Image == name {
-			return img.ImagingFailures+img.BootFailures <= MaxFailuresPerImg
+			return img.BootFailures
 		}
 	}
-	return true
+	return 0
+}
+
+//LastKnownGood returns the name of the image which most recently booted
+//successfully, or an empty string if there is none.
+func LastKnownGood() (name string) {
+	var latest time.Time
+	for _, img := range results {
+		if img.LastGoodBoot != nil && img.LastGoodBoot.After(latest) {
+			latest = *img.LastGoodBoot
+			name = img.Image
+		}
+	}
+	return
 }
 
 /* RecordBootState records boot status (success/failure, how badly failed).
 If imgName is empty or otherwise doesn't appear valid, update stats for first entry.
 */
 func RecordBootState(imgName string, success bool, severity uint, bTime time.Time, notes string) {
-	Load()
-	var result *ImageResult
-	i := 0
-
-	//find record, if it exists
-	for i = range results {
-		if results[i].Image == imgName {
-			result = results[i]
-			break
-		}
+	ev := Event{
+		Time:     bTime,
+		Stage:    StageBoot,
+		Success:  success,
+		Severity: severity,
+		Detail:   notes,
+	}
+	if !success {
+		ev.Reason = ReasonBoot
 	}
+	recordBoot(imgName, ev)
+}
+
+//records a boot or health check event
+func recordBoot(imgName string, ev Event) {
+	Load()
+	result := find(imgName)
 	if result == nil {
 		//no matching record (?!) - does imgName seem valid?
 		valid := strings.HasPrefix(imgName, strs.ImgPrefix())
@@ -148,30 +224,60 @@ func RecordBootState(imgName string, success bool, severity uint, bTime time.Tim
 			result = results[0]
 		}
 	}
-	result.BootAttempts++
-	thisBoot := fmt.Sprintf("Boot @ %s, success: %t", bTime.Format(time.RFC3339), success)
-	if !success {
-		if severity < 1 {
-			severity = 1
+	if ev.Kernel == "" {
+		ev.Kernel = kernelRelease()
+	}
+	if ev.Stage == StageBoot {
+		result.BootAttempts++
+	}
+	if !ev.Success {
+		if ev.Severity < 1 {
+			ev.Severity = 1
+		}
+		result.BootFailures += ev.Severity
+	} else {
+		t := ev.Time
+		result.LastGoodBoot = &t
+	}
+	if ev.Stage == StageHealth {
+		if ev.Success {
+			t := ev.Time
+			result.KnownGood = &t
+		} else {
+			result.KnownGood = nil
 		}
-		result.BootFailures += severity
-		thisBoot += fmt.Sprintf(", severity: %d, notes: %s", severity, notes)
 	}
-	result.Notes = append(result.Notes, thisBoot)
+	result.Events = append(result.Events, ev)
 	results.moveOrAddFront(result)
 
 	write(results)
 }
 
+//Writes history atomically. The previous version, if valid, becomes the
+//backup.
 func write(res ResultList) {
-	var content serializationFmt
-	content.ImageResults = res
+	list, err := json.Marshal(res)
+	if err != nil {
+		log.Logf("error %s marshalling json for %v", err, res)
+		return
+	}
+	content := serializationFmt{
+		Version:      histVersion,
+		Checksum:     checksum(list),
+		ImageResults: list,
+	}
 	data, err := json.Marshal(content)
 	if err != nil {
 		log.Logf("error %s marshalling json for %v", err, content)
 		return
 	}
-	err = ioutil.WriteFile(histPath, data, 0644)
+	if _, _, err = readHist(histPath); err == nil {
+		err = os.Rename(histPath, bakPath())
+		if err != nil {
+			log.Logf("error %s rotating %s", err, histPath)
+		}
+	}
+	err = futil.WriteFileAtomic(histPath, data, 0644)
 	if err != nil {
 		log.Logf("error %s writing data to %s", err, histPath)
 	}
@@ -189,25 +295,25 @@ func RebootHook(success bool) {
 	} else {
 		log.Logf("Adding to history file: img=%s success=%t", chosenImage, success)
 	}
-	var img *ImageResult
-	for idx := range results {
-		if results[idx].Image == chosenImage {
-			img = results[idx]
-		}
-	}
+	img := find(chosenImage)
 	if img == nil {
 		//no records for this image
 		img = &ImageResult{
 			Image: chosenImage,
 		}
 	}
+	ev := Event{
+		Time:    time.Now(),
+		Stage:   StageImaging,
+		Success: success,
+	}
 	img.ImagingAttempts++
 	if !success {
 		img.ImagingFailures++
+		ev.Severity = 1
+		ev.Reason = ReasonImaging
 	}
-	note := fmt.Sprintf("Imaging @ %s, success: %t", time.Now().Format(time.RFC3339), success)
-
-	img.Notes = append(img.Notes, note)
+	img.Events = append(img.Events, ev)
 	results.moveOrAddFront(img)
 	write(results)
 }
@@ -8,7 +8,10 @@
 package history
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"math"
 	"os"
 	"strings"
 	"testing"
@@ -75,6 +78,13 @@ func TestRecordBootState(t *testing.T) {
 	RecordBootState(imgName, false, 1, ti, "")
 	checkCounts(t, 2, 0, 5, 6, false)
 	checkCounts(t, 2, 1, 2, 6, false)
+	if got := BootFailures(imgName2); got != 6 {
+		t.Errorf("BootFailures(%s): want 6, got %d", imgName2, got)
+	}
+	RecordBootState(imgName2, true, 0, ti.Add(time.Minute), "")
+	if lkg := LastKnownGood(); lkg != imgName2 {
+		t.Errorf("LastKnownGood: want %s, got %s", imgName2, lkg)
+	}
 	if t.Failed() {
 		dumpResults(t)
 	}
@@ -102,3 +112,149 @@ func dumpResults(t *testing.T) {
 		t.Logf("results[%d] == %#v\n", i, results[i])
 	}
 }
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestHist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetRoot(dir)
+
+	//legacy file, lacking version and checksum
+	legacy := `{"ImageResults":[{"Image":"img1","BootFailures":2}]}`
+	if err = ioutil.WriteFile(histPath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	if !Load() || BootFailures("img1") != 2 {
+		t.Fatalf("failed to load legacy file: %v", results)
+	}
+	AddEvent("img1", Event{Stage: StageValidation, Detail: "first"})
+	data, err := ioutil.ReadFile(histPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content serializationFmt
+	if err = json.Unmarshal(data, &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.Version != histVersion || content.Checksum == "" {
+		t.Errorf("not migrated: %s", data)
+	}
+	//the legacy file becomes the backup
+	if _, v, err := readHist(bakPath()); err != nil || v != 0 {
+		t.Errorf("backup: want legacy file, got version %d, err %v", v, err)
+	}
+
+	AddEvent("img1", Event{Stage: StageValidation, Detail: "second"})
+	for _, td := range []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{name: "truncated", corrupt: func(d []byte) []byte { return d[:len(d)/2] }},
+		{name: "badChecksum", corrupt: func(d []byte) []byte {
+			return bytes.Replace(d, []byte(`"BootFailures":2`), []byte(`"BootFailures":0`), 1)
+		}},
+		{name: "missing"},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			good, err := ioutil.ReadFile(histPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bak, err := ioutil.ReadFile(bakPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = ioutil.WriteFile(histPath, good, 0644)
+				_ = ioutil.WriteFile(bakPath(), bak, 0644)
+			}()
+			if td.corrupt != nil {
+				err = ioutil.WriteFile(histPath, td.corrupt(good), 0644)
+			} else {
+				err = os.Remove(histPath)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = nil
+			if !Load() {
+				t.Fatal("load failed")
+			}
+			//backup holds the state before the second note
+			if len(results) != 1 || results[0].BootFailures != 2 || len(results[0].Events) != 1 {
+				t.Errorf("wrong results %#v", results)
+			}
+			//primary is restored
+			if _, _, err = readHist(histPath); err != nil {
+				t.Errorf("primary not restored: %s", err)
+			}
+		})
+	}
+
+	Rollover(dir)
+	results = nil
+	if !Load() || len(results) != 0 {
+		t.Errorf("history should be empty after rollover: %v", results)
+	}
+}
+
+func TestScore(t *testing.T) {
+	now := time.Now()
+	halfLife := FailureHalfLife
+	defer func() { FailureHalfLife = halfLife }()
+	FailureHalfLife = time.Hour
+	fail := func(age time.Duration, sev uint) Event {
+		return Event{Time: now.Add(-age), Stage: StageBoot, Severity: sev}
+	}
+	good := now.Add(-90 * time.Minute)
+	for _, td := range []struct {
+		name string
+		img  ImageResult
+		want float64
+	}{
+		{name: "legacy", img: ImageResult{ImagingFailures: 1, BootFailures: 3}, want: 4},
+		{name: "decay", img: ImageResult{BootFailures: 6, Events: []Event{fail(0, 2), fail(time.Hour, 4)}}, want: 4},
+		{name: "mixed", img: ImageResult{ImagingFailures: 2, BootFailures: 2, Events: []Event{fail(2*time.Hour, 2)}}, want: 2.5},
+		{name: "successIgnored", img: ImageResult{Events: []Event{{Time: now, Success: true, Severity: 3}}}, want: 0},
+		{name: "knownGood", img: ImageResult{ImagingFailures: 5, BootFailures: 1, KnownGood: &good,
+			Events: []Event{fail(2*time.Hour, 4), fail(0, 1)}}, want: 1},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			if got := td.img.score(now); math.Abs(got-td.want) > 0.001 {
+				t.Errorf("want %f, got %f", td.want, got)
+			}
+		})
+	}
+}
+
+func TestReportHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goTestHist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := strs.ImgPrefix() + "health"
+	results = nil
+	ReportHealth(dir, Health{Image: img, Healthy: false, Severity: 3, Detail: "service x failed"})
+	r := find(img)
+	if r == nil || r.BootFailures != 3 || r.KnownGood != nil || r.BootAttempts != 0 {
+		t.Fatalf("after failure: %#v", r)
+	}
+	if ev := r.Events[0]; ev.Stage != StageHealth || ev.Reason != ReasonHealth || ev.Success {
+		t.Errorf("bad event %#v", ev)
+	}
+	ReportHealth(dir, Health{Image: img, Healthy: true, Bootloader: "systemd-boot"})
+	r = find(img)
+	if r.KnownGood == nil || r.LastGoodBoot == nil || !Check(img) || r.Score() != 0 {
+		t.Errorf("after success: %#v", r)
+	}
+	//persisted
+	results = nil
+	Load()
+	if r = find(img); r == nil || len(r.Events) != 2 || r.Events[1].Bootloader != "systemd-boot" {
+		t.Errorf("not persisted: %#v", r)
+	}
+}
@@ -30,6 +30,7 @@
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	logflags "github.com/purecloudlabs/gprovision/pkg/log/flags"
 	"github.com/purecloudlabs/gprovision/pkg/log/lcd"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/ab"
 	"github.com/purecloudlabs/gprovision/pkg/recovery/archive"
 	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
 	"github.com/purecloudlabs/gprovision/pkg/recovery/emode"
@@ -41,13 +42,18 @@
 
 var Platform *appliance.Variant
 
-func WithEmergencyFile(efiles []string) { rmain(efiles, "") }
-func WithImgOpt(opt string)             { rmain(nil, opt) }
-func WithDefaults()                     { rmain(nil, "") }
+func WithEmergencyFile(efiles []string) { rmain(efiles, "", os.Getenv(frModeEnv)) }
+func WithImgOpt(opt string)             { rmain(nil, opt, os.Getenv(frModeEnv)) }
+func WithDefaults()                     { rmain(nil, "", os.Getenv(frModeEnv)) }
+
+//WithSlotUpdate writes the latest image to the inactive A/B slot; see updateSlot.
+func WithSlotUpdate() { rmain(nil, "", modeUpdate) }
 
 var frModeEnv = "frmode"
 
-func rmain(efiles []string, imgopt string) {
+const modeUpdate = "update"
+
+func rmain(efiles []string, imgopt, mode string) {
 	if !udev.IsRunning() {
 		_, _ = udev.Start()
 		log.Log("started udev")
@@ -83,10 +89,14 @@ func rmain(efiles []string, imgopt string) {
 	 *   -----
 	 * default (recovery): do factory restore
 	 * shell: mount recovery & md, drop to shell. password protected via ipmi password
+	 * update: write image to inactive A/B slot, preserving data
 	 */
-	mode := os.Getenv(frModeEnv)
 	if mode == "shell" {
 		shell(recov)
+	} else if mode == modeUpdate {
+		if updateSlot(recov, u, imgopt) {
+			return
+		}
 	} else {
 		noreboot := recovery(recov, u, efiles, imgopt)
 		if noreboot {
@@ -101,6 +111,92 @@ func rmain(efiles []string, imgopt string) {
 }
 
 func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt string) (noreboot bool) {
+	emergencyImage, userCancel := prepare(recov, u, efiles, imgopt)
+	if userCancel {
+		return true //do not reboot
+	}
+	rpath := recov.Path()
+	serial := Platform.SerNum()
+
+	//FIXME refactor
+	fakeRaid := false
+	if Platform.HasRaid() {
+		fakeRaid = CheckBios(Platform.BiosConfigTool(), false, false)
+	}
+	if fakeRaid {
+		CheckBios(Platform.BiosConfigTool(), true, false)
+	}
+
+	disks := disk.FindTargets(Platform)
+	if Platform.HasRaid() {
+		paths := ""
+		for _, d := range disks {
+			paths += d.Device() + " "
+		}
+		log.Logf("RAID%d array using %s", Platform.RaidLevel(), paths)
+	}
+
+	for _, d := range disks {
+		log.Msgf("partitioning %s", d.Device())
+		err := d.Partition(Platform)
+		if err != nil {
+			log.Logf("partitioning %s: %s", d.Device(), err)
+		}
+	}
+	hostName := Hostify(serial)
+	var target *disk.Filesystem
+	if Platform.HasRaid() {
+		target = disk.CreateArray(disks, hostName, Platform)
+	} else {
+		if len(disks) != 1 {
+			log.Logf("error - multiple disks identified on non-raid platform. disks[]=%#v", disks)
+			log.Fatalf("unsupported configuration")
+		}
+		target = disks[0].CreateNonArray(Platform)
+	}
+	if enc := Platform.Encryption(); enc != nil {
+		key, err := stash.ReadDiskKey()
+		if err != nil {
+			log.Fatalf("reading disk key: %s", err)
+		}
+		if err = target.Encrypt(key, enc); err != nil {
+			log.Fatalf("encrypting %s: %s", target.Device(), err)
+		}
+	}
+	_ = target.Format(strs.PriVolName())
+	target.Mount()
+	otherParts := disk.FormatOther(disks, Platform)
+
+	log.Msg("Copying files...")
+	archive.ApplyUpdate(target)
+	if _, err := archive.Prune(fp.Join(rpath, "Image"), archive.DefaultKeepNewest, false); err != nil {
+		log.Logf("pruning images: %s", err)
+	}
+	if emergencyImage != "" {
+		//unmount the usb drive the emergency image was on
+		if err := mount.Unmount(fp.Dir(emergencyImage), false, false); err != nil {
+			log.Logf("umount drive containing emergency image: %s", err)
+		}
+	}
+
+	install(recov, target, disks, otherParts, serial, hostName)
+
+	if Platform.ABSlots() {
+		//image is in slot A, regardless of previous state
+		st := &ab.State{}
+		st.Reset(dt.Get())
+		if err := st.Write(rpath); err != nil {
+			log.Logf("writing slot state: %s", err)
+		}
+	}
+
+	log.Msg("recovery process complete")
+	return false //reboot
+}
+
+//common to factory restore and slot update: log setup, fr data, and choice of
+//image. returns true if the user canceled.
+func prepare(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt string) (emergencyImage string, userCancel bool) {
 	log.Msg("start recovery: " + log.Timestamp())
 	recov.Mount()
 	rpath := recov.Path()
@@ -126,7 +222,7 @@ func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt str
 
 	dt.SetPlatform(Platform.DeviceCodeName())
 
-	eJsons, emergencyImage := emode.CheckForEmergency(efiles)
+	eJsons, emergencyImage := emode.CheckForEmergency(efiles, Platform)
 
 	fr.SetUnit(u)
 	err := fr.ReadRecoveryOr(eJsons)
@@ -152,68 +248,22 @@ func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt str
 
 	log.Msg("waiting on update validation...")
 	//don't bother doing this in the background, adds complexity without benefit
-	updOk, userCancel := archive.FindValidUpd(emergencyImage, imgopt, fp.Join(rpath, "Image"), Platform.LowMemory())
+	updOk, userCancel := archive.FindValidUpd(emergencyImage, imgopt, fp.Join(rpath, "Image"), Platform)
 	if userCancel {
-		if imgopt == "" {
-			//should never get here
-			panic("user cancel with no imgopt")
-		}
+		//only possible if the image policy includes the menu
 		//discard preboot items; the only one we need is UnmountAll
 		hk.Preboots.Clear()
 		disk.UnmountAll(false)
-		return true //do not reboot
+		return "", true
 	}
 	if !updOk {
 		log.Fatalf("no valid update files found")
 	}
-	//FIXME refactor
-	fakeRaid := false
-	if Platform.HasRaid() {
-		fakeRaid = CheckBios(Platform.BiosConfigTool(), false, false)
-	}
-	if fakeRaid {
-		CheckBios(Platform.BiosConfigTool(), true, false)
-	}
-
-	disks := disk.FindTargets(Platform)
-	if Platform.HasRaid() {
-		paths := ""
-		for _, d := range disks {
-			paths += d.Device() + " "
-		}
-		log.Logf("RAID%d array using %s", Platform.RaidLevel(), paths)
-	}
-
-	for _, d := range disks {
-		log.Msgf("partitioning %s", d.Device())
-		err = d.Partition(Platform)
-		if err != nil {
-			log.Logf("partitioning %s: %s", d.Device(), err)
-		}
-	}
-	hostName := Hostify(serial)
-	var target *disk.Filesystem
-	if Platform.HasRaid() {
-		target = disk.CreateArray(disks, hostName, Platform)
-	} else {
-		if len(disks) != 1 {
-			log.Logf("error - multiple disks identified on non-raid platform. disks[]=%#v", disks)
-			log.Fatalf("unsupported configuration")
-		}
-		target = disks[0].CreateNonArray(Platform)
-	}
-	_ = target.Format(strs.PriVolName())
-	target.Mount()
-
-	log.Msg("Copying files...")
-	archive.ApplyUpdate(target)
-	if emergencyImage != "" {
-		//unmount the usb drive the emergency image was on
-		if err := mount.Unmount(fp.Dir(emergencyImage), false, false); err != nil {
-			log.Logf("umount drive containing emergency image: %s", err)
-		}
-	}
+	return emergencyImage, false
+}
 
+//make target bootable and configure it, once the image has been applied
+func install(recov, target *disk.Filesystem, disks []*disk.Disk, otherParts []*disk.Filesystem, serial, hostName string) {
 	recov.SetMountpoint("/mnt/" + strs.RecVolName())
 	target.SetMountpoint("/")
 
@@ -225,10 +275,14 @@ func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt str
 	for _, p := range bootParts {
 		parts = append(parts, p)
 	}
+	for _, p := range otherParts {
+		parts = append(parts, p)
+	}
 
 	//uid,gid are used when mounting the recovery key to ensure that our user can access it, since non-native fs types map to root by default.
 	uid, gid := getUidGid(target)
 	target.WriteFstab(uid, gid, parts...)
+	target.WriteCrypttab()
 
 	writeNetworkConfig(recov, target)
 
@@ -242,7 +296,7 @@ func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt str
 	}
 	recov.SetOwnerAndPerms(uid, gid)
 
-	err = fr.Delete()
+	err := fr.Delete()
 	if err != nil {
 		log.Logf("deleting FRData: %s", err)
 	}
@@ -258,9 +312,6 @@ func recovery(recov *disk.Filesystem, u common.Unit, efiles []string, imgopt str
 
 	//write platform info to disk
 	writeFacts(target)
-
-	log.Msg("recovery process complete")
-	return false //reboot
 }
 
 func writeNetworkConfig(recov, target common.Pather) {
@@ -15,6 +15,7 @@
 	"path/filepath"
 
 	"github.com/purecloudlabs/gprovision/pkg/common/stash"
+	"github.com/purecloudlabs/gprovision/pkg/hw/block"
 	"github.com/purecloudlabs/gprovision/pkg/log"
 	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
 )
@@ -90,7 +91,14 @@ func readyArray() string {
 			return "/dev/null"
 		}
 	}
-	md := disk.ExistingExt4Fs(dev, false)
+	//root may be ext4, xfs, or btrfs; see appliance.PartitionLayout
+	fsType := "ext4"
+	if bi, err := block.GetInfo(dev); err == nil && bi.FsType != block.FsUnknown {
+		fsType = bi.FsType.String()
+	} else {
+		log.Logf("readyArray: cannot detect fs type of %s, trying %s", dev, fsType)
+	}
+	md := disk.ExistingFs(dev, fsType, "auto,relatime", false)
 	md.SetMountpoint(mountpoint)
 	//fmt.Printf("md mountpoint %s\n",mountpoint)
 	path, err := md.MountErr()
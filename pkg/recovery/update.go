@@ -0,0 +1,85 @@
+// Copyright (C) 2015-2020 the Gprovision Authors. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// SPDX-License-Identifier: BSD-3-Clause
+//
+
+package recovery
+
+import (
+	fp "path/filepath"
+
+	"github.com/purecloudlabs/gprovision/pkg/common"
+	dt "github.com/purecloudlabs/gprovision/pkg/disktag"
+	"github.com/purecloudlabs/gprovision/pkg/hw/uefi"
+	"github.com/purecloudlabs/gprovision/pkg/log"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/ab"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/archive"
+	"github.com/purecloudlabs/gprovision/pkg/recovery/disk"
+)
+
+//updateSlot writes the image to the inactive A/B slot and makes it the boot
+//target, leaving the active slot and other partitions untouched. Init tries
+//the new slot, falling back to the active one unless the OS confirms it; see
+//package ab.
+//
+//Boot partitions are rewritten, so with legacy bios the grub default points at
+//the new slot; with uefi, BootNext is set to the normal boot entry.
+func updateSlot(recov *disk.Filesystem, u common.Unit, imgopt string) (noreboot bool) {
+	if !Platform.ABSlots() {
+		log.Msg("platform has no A/B slots, not updating")
+		return false
+	}
+	_, userCancel := prepare(recov, u, nil, imgopt)
+	if userCancel {
+		return true
+	}
+	rpath := recov.Path()
+	serial := Platform.SerNum()
+	st, err := ab.Load(rpath)
+	if err != nil {
+		log.Fatalf("reading slot state: %s", err)
+	}
+	if st.Pending != "" {
+		log.Logf("abandoning unconfirmed slot %s (%s)", st.Pending, st.PendingImage)
+	}
+	slot := st.Active.Other()
+
+	disks := disk.FindTargets(Platform)
+	if len(disks) != 1 {
+		log.Fatalf("A/B slots require a single disk, found %d", len(disks))
+	}
+	if err = disks[0].UseLayout(Platform); err != nil {
+		log.Logf("%s", err)
+		log.Fatalf("disk layout changed; factory restore required")
+	}
+	target := disks[0].CreateSlot(Platform, slot.Role())
+	log.Msgf("Writing image to slot %s...", slot)
+	if err = target.Format(slot.Label()); err != nil {
+		log.Fatalf("formatting slot %s: %s", slot, err)
+	}
+	target.Mount()
+
+	log.Msg("Copying files...")
+	archive.ApplyUpdate(target)
+	if _, err := archive.Prune(fp.Join(rpath, "Image"), archive.DefaultKeepNewest, false); err != nil {
+		log.Logf("pruning images: %s", err)
+	}
+
+	install(recov, target, disks, disk.ExistingOther(disks, Platform), serial, Hostify(serial))
+
+	st.SetPending(dt.Get())
+	if err = st.Write(rpath); err != nil {
+		log.Fatalf("writing slot state: %s", err)
+	}
+	if uefi.BootedUEFI() {
+		if e := uefi.AllBootEntryVars().NormalEntry(); e != nil {
+			if err = uefi.SetBootNext(e.Number); err != nil {
+				log.Logf("setting BootNext: %s", err)
+			}
+		}
+	}
+	log.Msgf("slot %s updated, will boot on trial", slot)
+	return false
+}
@@ -11,7 +11,7 @@
 import (
 	"archive/tar"
 	"bytes"
-	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -197,7 +197,7 @@ func (mi *Mockinfra) useExistingUpd(upd string) {
 	mi.TmplData.UpdName = fp.Base(upd) //ends up in manufData template, and from there in disktag
 
 	//calculate sha
-	sha := sha1.New()
+	sha := sha256.New()
 	f, err := os.Open(upd)
 	if err != nil {
 		mi.t.Fatal(err)
@@ -307,10 +307,10 @@ func (m *Mockinfra) Setup() {
 	}
 	m.stashXz = tarXzBuf(stash_sh_name, []byte(stash_sh), m.t, true, false)
 	//checksums
-	m.TmplData.CmdSum = fmt.Sprintf("%x", sha1.Sum([]byte(sampleCmd_sh)))
-	m.TmplData.StashSum = fmt.Sprintf("%x", sha1.Sum(m.stashXz))
+	m.TmplData.CmdSum = fmt.Sprintf("%x", sha256.Sum256([]byte(sampleCmd_sh)))
+	m.TmplData.StashSum = fmt.Sprintf("%x", sha256.Sum256(m.stashXz))
 	if len(m.updXz) != 0 {
-		m.TmplData.UpdSum = fmt.Sprintf("%x", sha1.Sum(m.updXz))
+		m.TmplData.UpdSum = fmt.Sprintf("%x", sha256.Sum256(m.updXz))
 	}
 	if len(m.BootKernelPath) > 0 {
 		var err error
@@ -318,7 +318,7 @@ func (m *Mockinfra) Setup() {
 		if err != nil {
 			m.t.Error(err)
 		}
-		m.TmplData.KSum = fmt.Sprintf("%x", sha1.Sum(m.kernel))
+		m.TmplData.KSum = fmt.Sprintf("%x", sha256.Sum256(m.kernel))
 	} else {
 		//dummy file
 		m.kernel = m.stashXz
@@ -397,18 +397,18 @@ func tarXzBuf(name string, content []byte, t gtst.TB, exe, sha bool) []byte {
       "_comment3": "adding an underscore has the same effect as omitting Dest entirely, except you can see what it _would_ look like",
       "_Dest": "Image/PRODUCT.Os.Plat.2017-08-15.6240.upd",
       "Src": "http://10.0.2.2:[[ .FPort ]]/linux_mfg/Image/[[ .UpdName ]]",
-      "Sha1": "[[ .UpdSum ]]"
+      "Sha256": "[[ .UpdSum ]]"
 	},
 	{
 	  "Src": "http://10.0.2.2:[[ .FPort ]]/[[ .KName ]]",
-	  "Sha1": "[[ .KSum ]]"
+	  "Sha256": "[[ .KSum ]]"
 	}
   ],
   "LogEndpoint": "[[ .LAddr ]]",
   "StashFiles": [
     {
       "Src": "http://10.0.2.2:[[ .FPort ]]/linux_mfg/stash.txz",
-      "Sha1": "[[ .StashSum ]]"
+      "Sha256": "[[ .StashSum ]]"
     }
   ],
   "CredentialEndpoint": "[[ .CredEP ]]",
@@ -487,7 +487,7 @@ func tarXzBuf(name string, content []byte, t gtst.TB, exe, sha bool) []byte {
           "Files": [
             {
               "Src": "http://10.0.2.2:[[ .FPort ]]/sampleCmd.sh",
-              "Sha1": "[[ .CmdSum ]]"
+              "Sha256": "[[ .CmdSum ]]"
             }
           ],
           "Commands": [